@@ -19,6 +19,11 @@ type FakeUser struct {
 	DeleteUserName string
 	DeleteError    error
 
+	ListCalled    bool
+	ListIamPath   string
+	ListUserNames []string
+	ListError     error
+
 	ListAccessKeysCalled     bool
 	ListAccessKeysUserName   string
 	ListAccessKeysAccessKeys []string
@@ -123,6 +128,13 @@ func (f *FakeUser) DeletePolicy(policyARN string) error {
 	return f.DeletePolicyError
 }
 
+func (f *FakeUser) List(iamPath string) ([]string, error) {
+	f.ListCalled = true
+	f.ListIamPath = iamPath
+
+	return f.ListUserNames, f.ListError
+}
+
 func (f *FakeUser) ListAttachedUserPolicies(userName string) ([]string, error) {
 	f.ListAttachedUserPoliciesCalled = true
 	f.ListAttachedUserPoliciesUserName = userName
@@ -0,0 +1,326 @@
+package awsiam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// refreshPolicyName is the name of the inline policy that lets a role assume
+// itself, which is how apps refresh their temporary credentials.
+const refreshPolicyName = "refresh-credentials"
+
+// SessionDuration is how long the sessions the broker starts for roles last.
+// It is the longest AWS allows, and is also the role's maximum session
+// duration. Sessions that apps start with a session's own credentials are
+// role chaining, which AWS limits to one hour.
+const SessionDuration = 12 * time.Hour
+
+type IAMRole struct {
+	iamsvc *iam.IAM
+	stssvc *sts.STS
+	logger lager.Logger
+
+	// assumeRetryInterval is how long to wait between attempts to assume a
+	// role that IAM has not finished propagating.
+	assumeRetryInterval time.Duration
+}
+
+func NewIAMRole(
+	iamsvc *iam.IAM,
+	stssvc *sts.STS,
+	logger lager.Logger,
+) *IAMRole {
+	return &IAMRole{
+		iamsvc:              iamsvc,
+		stssvc:              stssvc,
+		logger:              logger.Session("iam-role"),
+		assumeRetryInterval: 2 * time.Second,
+	}
+}
+
+func (i *IAMRole) Exists(roleName string) (bool, error) {
+	getRoleInput := &iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	}
+	i.logger.Debug("exists-role", lager.Data{"input": getRoleInput})
+	_, err := i.iamsvc.GetRole(getRoleInput)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			if awsErr.Code() == iam.ErrCodeNoSuchEntityException {
+				return false, nil
+			}
+		}
+		i.logger.Error("exists-role.aws-iam-error", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// Create creates a role that principals in the broker's account can assume
+// for up to SessionDuration, and allows the role to assume itself so that
+// sessions can be renewed with the session's own credentials. It returns the
+// role's ARN.
+func (i *IAMRole) Create(
+	roleName,
+	iamPath string,
+	iamTags []*iam.Tag,
+) (string, error) {
+	callerIdentityOutput, err := i.stssvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		i.logger.Error("create-role.aws-sts-error", err)
+		return "", awsError(err)
+	}
+	callerARN, err := arn.Parse(aws.StringValue(callerIdentityOutput.Arn))
+	if err != nil {
+		return "", err
+	}
+
+	assumeRolePolicy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect": "Allow",
+			"Principal": map[string]string{
+				"AWS": fmt.Sprintf("arn:%s:iam::%s:root", callerARN.Partition, aws.StringValue(callerIdentityOutput.Account)),
+			},
+			"Action": "sts:AssumeRole",
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	roleARN, err := i.createRole(roleName, iamPath, string(assumeRolePolicy), aws.Int64(int64(SessionDuration.Seconds())), iamTags)
+	if err != nil {
+		return "", err
+	}
+
+	refreshPolicy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":   "Allow",
+			"Action":   "sts:AssumeRole",
+			"Resource": roleARN,
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	putRolePolicyInput := &iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(refreshPolicyName),
+		PolicyDocument: aws.String(string(refreshPolicy)),
+	}
+	i.logger.Debug("put-role-policy", lager.Data{"input": putRolePolicyInput})
+	if _, err := i.iamsvc.PutRolePolicy(putRolePolicyInput); err != nil {
+		i.logger.Error("put-role-policy.aws-iam-error", err)
+		return "", awsError(err)
+	}
+
+	return roleARN, nil
+}
+
+// CreateServiceRole creates a role that the AWS service, such as
+// "s3.amazonaws.com", can assume. It returns the role's ARN, which is that of
+// the existing role if it was already created, so that provisioning can be
+// retried.
+func (i *IAMRole) CreateServiceRole(
+	roleName,
+	iamPath,
+	service string,
+	iamTags []*iam.Tag,
+) (string, error) {
+	assumeRolePolicy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":    "Allow",
+			"Principal": map[string]string{"Service": service},
+			"Action":    "sts:AssumeRole",
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	roleARN, err := i.createRole(roleName, iamPath, string(assumeRolePolicy), nil, iamTags)
+	if err != ErrEntityAlreadyExists {
+		return roleARN, err
+	}
+
+	getRoleInput := &iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	}
+	i.logger.Debug("get-role", lager.Data{"input": getRoleInput})
+	getRoleOutput, err := i.iamsvc.GetRole(getRoleInput)
+	if err != nil {
+		i.logger.Error("get-role.aws-iam-error", err)
+		return "", awsError(err)
+	}
+	return aws.StringValue(getRoleOutput.Role.Arn), nil
+}
+
+func (i *IAMRole) createRole(roleName, iamPath, assumeRolePolicy string, maxSessionDuration *int64, iamTags []*iam.Tag) (string, error) {
+	createRoleInput := &iam.CreateRoleInput{
+		RoleName:                 aws.String(roleName),
+		Path:                     stringOrNil(iamPath),
+		AssumeRolePolicyDocument: aws.String(assumeRolePolicy),
+		MaxSessionDuration:       maxSessionDuration,
+		Tags:                     iamTags,
+	}
+	i.logger.Debug("create-role", lager.Data{"input": createRoleInput})
+
+	createRoleOutput, err := i.iamsvc.CreateRole(createRoleInput)
+	if err != nil {
+		i.logger.Error("create-role.aws-iam-error", err)
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == iam.ErrCodeEntityAlreadyExistsException {
+			return "", ErrEntityAlreadyExists
+		}
+		return "", awsError(err)
+	}
+	i.logger.Debug("create-role", lager.Data{"output": createRoleOutput})
+
+	return aws.StringValue(createRoleOutput.Role.Arn), nil
+}
+
+// Delete deletes the role along with its refresh policy. Managed policies
+// must be detached first.
+func (i *IAMRole) Delete(roleName string) error {
+	deleteRolePolicyInput := &iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(refreshPolicyName),
+	}
+	i.logger.Debug("delete-role-policy", lager.Data{"input": deleteRolePolicyInput})
+	if _, err := i.iamsvc.DeleteRolePolicy(deleteRolePolicyInput); err != nil {
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != iam.ErrCodeNoSuchEntityException {
+			i.logger.Error("delete-role-policy.aws-iam-error", err)
+			return err
+		}
+	}
+
+	deleteRoleInput := &iam.DeleteRoleInput{
+		RoleName: aws.String(roleName),
+	}
+	i.logger.Debug("delete-role", lager.Data{"input": deleteRoleInput})
+
+	deleteRoleOutput, err := i.iamsvc.DeleteRole(deleteRoleInput)
+	if err != nil {
+		i.logger.Error("delete-role.aws-iam-error", err)
+		return err
+	}
+	i.logger.Debug("delete-role", lager.Data{"output": deleteRoleOutput})
+
+	return nil
+}
+
+func (i *IAMRole) ListAttachedRolePolicies(roleName, iamPath string) ([]string, error) {
+	var rolePolicies []string
+
+	listAttachedRolePoliciesInput := &iam.ListAttachedRolePoliciesInput{
+		RoleName:   aws.String(roleName),
+		PathPrefix: stringOrNil(iamPath),
+	}
+	i.logger.Debug("list-attached-role-policies", lager.Data{"input": listAttachedRolePoliciesInput})
+
+	listAttachedRolePoliciesOutput, err := i.iamsvc.ListAttachedRolePolicies(listAttachedRolePoliciesInput)
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		return rolePolicies, err
+	}
+	i.logger.Debug("list-attached-role-policies", lager.Data{"output": listAttachedRolePoliciesOutput})
+
+	for _, rolePolicy := range listAttachedRolePoliciesOutput.AttachedPolicies {
+		rolePolicies = append(rolePolicies, aws.StringValue(rolePolicy.PolicyArn))
+	}
+
+	return rolePolicies, nil
+}
+
+func (i *IAMRole) AttachRolePolicy(roleName, policyARN string) error {
+	attachRolePolicyInput := &iam.AttachRolePolicyInput{
+		PolicyArn: aws.String(policyARN),
+		RoleName:  aws.String(roleName),
+	}
+	i.logger.Debug("attach-role-policy", lager.Data{"input": attachRolePolicyInput})
+
+	attachRolePolicyOutput, err := i.iamsvc.AttachRolePolicy(attachRolePolicyInput)
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		return awsError(err)
+	}
+	i.logger.Debug("attach-role-policy", lager.Data{"output": attachRolePolicyOutput})
+
+	return nil
+}
+
+func (i *IAMRole) DetachRolePolicy(roleName, policyARN string) error {
+	detachRolePolicyInput := &iam.DetachRolePolicyInput{
+		PolicyArn: aws.String(policyARN),
+		RoleName:  aws.String(roleName),
+	}
+	i.logger.Debug("detach-role-policy", lager.Data{"input": detachRolePolicyInput})
+
+	detachRolePolicyOutput, err := i.iamsvc.DetachRolePolicy(detachRolePolicyInput)
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		return awsError(err)
+	}
+	i.logger.Debug("detach-role-policy", lager.Data{"output": detachRolePolicyOutput})
+
+	return nil
+}
+
+// AssumeRole starts a session for roleARN that lasts SessionDuration. Newly
+// created roles take a few seconds to become assumable, so access denied
+// errors are retried.
+func (i *IAMRole) AssumeRole(roleARN, sessionName string) (RoleCredentials, error) {
+	assumeRoleInput := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleARN),
+		RoleSessionName: aws.String(sessionName),
+		DurationSeconds: aws.Int64(int64(SessionDuration.Seconds())),
+	}
+	i.logger.Debug("assume-role", lager.Data{"input": assumeRoleInput})
+
+	assumeRoleOutput, err := i.stssvc.AssumeRole(assumeRoleInput)
+	retries := 0
+	maxRetries := 10
+	for err != nil && retries < maxRetries && isAccessDenied(err) {
+		retries += 1
+		time.Sleep(i.assumeRetryInterval)
+		assumeRoleOutput, err = i.stssvc.AssumeRole(assumeRoleInput)
+	}
+	if err != nil {
+		i.logger.Error("assume-role.aws-sts-error", err)
+		return RoleCredentials{}, awsError(err)
+	}
+
+	return RoleCredentials{
+		AccessKeyID:     aws.StringValue(assumeRoleOutput.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(assumeRoleOutput.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(assumeRoleOutput.Credentials.SessionToken),
+		Expiration:      aws.TimeValue(assumeRoleOutput.Credentials.Expiration),
+	}, nil
+}
+
+func isAccessDenied(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return strings.Contains(awsErr.Code(), "AccessDenied")
+	}
+	return false
+}
+
+func awsError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return errors.New(awsErr.Code() + ": " + awsErr.Message())
+	}
+	return err
+}
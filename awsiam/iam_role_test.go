@@ -0,0 +1,268 @@
+package awsiam_test
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloud-gov/s3-broker/awsiam"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+var _ = Describe("IAM Role", func() {
+	var (
+		roleName string
+		iamPath  string
+
+		awsSession *session.Session
+		iamsvc     *iam.IAM
+		stssvc     *sts.STS
+
+		testSink *lagertest.TestSink
+		logger   lager.Logger
+
+		role Role
+	)
+
+	BeforeEach(func() {
+		roleName = "iam-role"
+		iamPath = "/path/"
+	})
+
+	JustBeforeEach(func() {
+		awsSession = session.New(nil)
+		iamsvc = iam.New(awsSession)
+		stssvc = sts.New(awsSession)
+
+		logger = lager.NewLogger("iamrole_test")
+		testSink = lagertest.NewTestSink()
+		logger.RegisterSink(testSink)
+
+		role = NewIAMRole(iamsvc, stssvc, logger)
+	})
+
+	var _ = Describe("Exists", func() {
+		var getRoleError error
+
+		BeforeEach(func() {
+			getRoleError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+			iamsvc.Handlers.Send.PushBack(func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("GetRole"))
+				Expect(r.Params).To(Equal(&iam.GetRoleInput{RoleName: aws.String(roleName)}))
+				r.Error = getRoleError
+			})
+		})
+
+		It("is true for an existing role", func() {
+			exists, err := role.Exists(roleName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists).To(BeTrue())
+		})
+
+		Context("when the role does not exist", func() {
+			BeforeEach(func() {
+				getRoleError = awserr.New(iam.ErrCodeNoSuchEntityException, "no such role", nil)
+			})
+
+			It("is false", func() {
+				exists, err := role.Exists(roleName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(exists).To(BeFalse())
+			})
+		})
+	})
+
+	var _ = Describe("Create", func() {
+		var (
+			operations         []string
+			createRoleErr      error
+			assumePolicy       string
+			refreshPolicy      string
+			maxSessionDuration *int64
+		)
+
+		BeforeEach(func() {
+			operations = nil
+			createRoleErr = nil
+		})
+
+		JustBeforeEach(func() {
+			stssvc.Handlers.Clear()
+			stssvc.Handlers.Send.PushBack(func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("GetCallerIdentity"))
+				data := r.Data.(*sts.GetCallerIdentityOutput)
+				data.Account = aws.String("123456789012")
+				data.Arn = aws.String("arn:aws-us-gov:iam::123456789012:user/broker")
+			})
+
+			iamsvc.Handlers.Clear()
+			iamsvc.Handlers.Send.PushBack(func(r *request.Request) {
+				operations = append(operations, r.Operation.Name)
+				switch input := r.Params.(type) {
+				case *iam.CreateRoleInput:
+					Expect(aws.StringValue(input.RoleName)).To(Equal(roleName))
+					Expect(aws.StringValue(input.Path)).To(Equal(iamPath))
+					assumePolicy = aws.StringValue(input.AssumeRolePolicyDocument)
+					maxSessionDuration = input.MaxSessionDuration
+					data := r.Data.(*iam.CreateRoleOutput)
+					data.Role = &iam.Role{Arn: aws.String("arn:aws-us-gov:iam::123456789012:role/path/iam-role")}
+					r.Error = createRoleErr
+				case *iam.PutRolePolicyInput:
+					Expect(aws.StringValue(input.RoleName)).To(Equal(roleName))
+					refreshPolicy = aws.StringValue(input.PolicyDocument)
+				case *iam.GetRoleInput:
+					Expect(aws.StringValue(input.RoleName)).To(Equal(roleName))
+					data := r.Data.(*iam.GetRoleOutput)
+					data.Role = &iam.Role{Arn: aws.String("arn:aws-us-gov:iam::123456789012:role/path/iam-role")}
+				}
+			})
+		})
+
+		It("creates a role that can be assumed and can renew itself", func() {
+			roleARN, err := role.Create(roleName, iamPath, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(roleARN).To(Equal("arn:aws-us-gov:iam::123456789012:role/path/iam-role"))
+			Expect(operations).To(Equal([]string{"CreateRole", "PutRolePolicy"}))
+			Expect(assumePolicy).To(ContainSubstring(`"AWS":"arn:aws-us-gov:iam::123456789012:root"`))
+			Expect(refreshPolicy).To(ContainSubstring(`"Resource":"arn:aws-us-gov:iam::123456789012:role/path/iam-role"`))
+			Expect(maxSessionDuration).To(Equal(aws.Int64(43200)))
+		})
+
+		It("lets the credentials of a session of the role renew the session", func() {
+			roleARN, err := role.Create(roleName, iamPath, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			// A session's credentials act as the role, so renewing them needs
+			// the role's own policy to allow assuming it, and the trust policy
+			// to trust principals of the account the role is in.
+			var refresh, trust struct {
+				Statement []struct {
+					Effect    string
+					Action    string
+					Resource  string
+					Principal map[string]string
+				}
+			}
+			Expect(json.Unmarshal([]byte(refreshPolicy), &refresh)).To(Succeed())
+			Expect(refresh.Statement).To(HaveLen(1))
+			Expect(refresh.Statement[0].Effect).To(Equal("Allow"))
+			Expect(refresh.Statement[0].Action).To(Equal("sts:AssumeRole"))
+			Expect(refresh.Statement[0].Resource).To(Equal(roleARN))
+
+			Expect(json.Unmarshal([]byte(assumePolicy), &trust)).To(Succeed())
+			Expect(trust.Statement).To(HaveLen(1))
+			Expect(trust.Statement[0].Effect).To(Equal("Allow"))
+			Expect(trust.Statement[0].Action).To(Equal("sts:AssumeRole"))
+			Expect(trust.Statement[0].Principal).To(Equal(map[string]string{"AWS": "arn:aws-us-gov:iam::123456789012:root"}))
+		})
+
+		It("creates a role that an AWS service can assume", func() {
+			roleARN, err := role.CreateServiceRole(roleName, iamPath, "s3.amazonaws.com", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(roleARN).To(Equal("arn:aws-us-gov:iam::123456789012:role/path/iam-role"))
+			Expect(operations).To(Equal([]string{"CreateRole"}))
+			Expect(assumePolicy).To(ContainSubstring(`"Principal":{"Service":"s3.amazonaws.com"}`))
+			Expect(maxSessionDuration).To(BeNil())
+		})
+
+		Context("when creating the role fails", func() {
+			BeforeEach(func() {
+				createRoleErr = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				_, err := role.Create(roleName, iamPath, nil)
+				Expect(err).To(MatchError("code: message"))
+				Expect(operations).To(Equal([]string{"CreateRole"}))
+			})
+		})
+
+		Context("when the role already exists", func() {
+			BeforeEach(func() {
+				createRoleErr = awserr.New(iam.ErrCodeEntityAlreadyExistsException, "message", errors.New("operation failed"))
+			})
+
+			It("does not create a binding role again", func() {
+				_, err := role.Create(roleName, iamPath, nil)
+				Expect(err).To(Equal(ErrEntityAlreadyExists))
+				Expect(operations).To(Equal([]string{"CreateRole"}))
+			})
+
+			It("returns the ARN of the existing service role", func() {
+				roleARN, err := role.CreateServiceRole(roleName, iamPath, "s3.amazonaws.com", nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(roleARN).To(Equal("arn:aws-us-gov:iam::123456789012:role/path/iam-role"))
+				Expect(operations).To(Equal([]string{"CreateRole", "GetRole"}))
+			})
+		})
+	})
+
+	var _ = Describe("AssumeRole", func() {
+		var (
+			expiration    time.Time
+			assumeRoleErr error
+		)
+
+		BeforeEach(func() {
+			expiration = time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+			assumeRoleErr = nil
+		})
+
+		JustBeforeEach(func() {
+			stssvc.Handlers.Clear()
+			stssvc.Handlers.Send.PushBack(func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("AssumeRole"))
+				Expect(r.Params).To(Equal(&sts.AssumeRoleInput{
+					RoleArn:         aws.String("role-arn"),
+					RoleSessionName: aws.String("session"),
+					DurationSeconds: aws.Int64(43200),
+				}))
+				data := r.Data.(*sts.AssumeRoleOutput)
+				data.Credentials = &sts.Credentials{
+					AccessKeyId:     aws.String("access-key-id"),
+					SecretAccessKey: aws.String("secret-access-key"),
+					SessionToken:    aws.String("session-token"),
+					Expiration:      aws.Time(expiration),
+				}
+				r.Error = assumeRoleErr
+			})
+		})
+
+		It("returns temporary credentials", func() {
+			credentials, err := role.AssumeRole("role-arn", "session")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials).To(Equal(RoleCredentials{
+				AccessKeyID:     "access-key-id",
+				SecretAccessKey: "secret-access-key",
+				SessionToken:    "session-token",
+				Expiration:      expiration,
+			}))
+		})
+
+		Context("when assuming the role fails", func() {
+			BeforeEach(func() {
+				assumeRoleErr = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				_, err := role.AssumeRole("role-arn", "session")
+				Expect(err).To(MatchError("code: message"))
+			})
+		})
+	})
+})
@@ -1,10 +1,8 @@
 package awsiam
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
-	"text/template"
+	"net/url"
 
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/aws/aws-sdk-go/aws"
@@ -67,6 +65,10 @@ func (i *IAMUser) Describe(userName string) (UserDetails, error) {
 
 	userDetails.UserARN = aws.StringValue(getUserOutput.User.Arn)
 	userDetails.UserID = aws.StringValue(getUserOutput.User.UserId)
+	userDetails.Tags = make(map[string]string, len(getUserOutput.User.Tags))
+	for _, tag := range getUserOutput.User.Tags {
+		userDetails.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
 
 	return userDetails, nil
 }
@@ -178,27 +180,10 @@ func (i *IAMUser) CreatePolicy(
 	iamPath,
 	policyTemplate string,
 	resources []string,
+	prefix string,
 	iamTags []*iam.Tag,
 ) (string, error) {
-	tmpl, err := template.New("policy").Funcs(template.FuncMap{
-		"resources": func(suffix string) string {
-			resourcePaths := make([]string, len(resources))
-			for idx, resource := range resources {
-				resourcePaths[idx] = resource + suffix
-			}
-			marshaled, _ := json.Marshal(resourcePaths)
-			return string(marshaled)
-		},
-	}).Parse(policyTemplate)
-	if err != nil {
-		i.logger.Error("aws-iam-error", err)
-		return "", err
-	}
-	policy := bytes.Buffer{}
-	err = tmpl.Execute(&policy, map[string]interface{}{
-		"Resource":  resources[0],
-		"Resources": resources,
-	})
+	policy, err := RenderPolicy(policyTemplate, resources, prefix)
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		return "", err
@@ -206,7 +191,7 @@ func (i *IAMUser) CreatePolicy(
 
 	createPolicyInput := &iam.CreatePolicyInput{
 		PolicyName:     aws.String(policyName),
-		PolicyDocument: aws.String(policy.String()),
+		PolicyDocument: aws.String(policy),
 		Path:           stringOrNil(iamPath),
 		Tags:           iamTags,
 	}
@@ -216,6 +201,9 @@ func (i *IAMUser) CreatePolicy(
 	if err != nil {
 		i.logger.Error("aws-iam-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
+			if awsErr.Code() == iam.ErrCodeEntityAlreadyExistsException {
+				return "", ErrEntityAlreadyExists
+			}
 			return "", errors.New(awsErr.Code() + ": " + awsErr.Message())
 		}
 		return "", err
@@ -244,6 +232,70 @@ func (i *IAMUser) DeletePolicy(policyARN string) error {
 	return nil
 }
 
+// GetPolicyDocument returns the JSON document of the default version of the
+// managed policy policyARN.
+func (i *IAMUser) GetPolicyDocument(policyARN string) (string, error) {
+	getPolicyInput := &iam.GetPolicyInput{
+		PolicyArn: aws.String(policyARN),
+	}
+	i.logger.Debug("get-policy", lager.Data{"input": getPolicyInput})
+
+	getPolicyOutput, err := i.iamsvc.GetPolicy(getPolicyInput)
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return "", errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return "", err
+	}
+	i.logger.Debug("get-policy", lager.Data{"output": getPolicyOutput})
+
+	getPolicyVersionInput := &iam.GetPolicyVersionInput{
+		PolicyArn: aws.String(policyARN),
+		VersionId: getPolicyOutput.Policy.DefaultVersionId,
+	}
+	i.logger.Debug("get-policy-version", lager.Data{"input": getPolicyVersionInput})
+
+	getPolicyVersionOutput, err := i.iamsvc.GetPolicyVersion(getPolicyVersionInput)
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return "", errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return "", err
+	}
+	i.logger.Debug("get-policy-version", lager.Data{"output": getPolicyVersionOutput})
+
+	// IAM returns policy documents URL-encoded.
+	return url.QueryUnescape(aws.StringValue(getPolicyVersionOutput.PolicyVersion.Document))
+}
+
+// List returns the names of the users under iamPath.
+func (i *IAMUser) List(iamPath string) ([]string, error) {
+	var userNames []string
+
+	listUsersInput := &iam.ListUsersInput{
+		PathPrefix: stringOrNil(iamPath),
+	}
+	i.logger.Debug("list-users", lager.Data{"input": listUsersInput})
+
+	err := i.iamsvc.ListUsersPages(listUsersInput, func(page *iam.ListUsersOutput, lastPage bool) bool {
+		for _, user := range page.Users {
+			userNames = append(userNames, aws.StringValue(user.UserName))
+		}
+		return true
+	})
+	if err != nil {
+		i.logger.Error("aws-iam-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return userNames, errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return userNames, err
+	}
+
+	return userNames, nil
+}
+
 func (i *IAMUser) ListAttachedUserPolicies(userName, iamPath string) ([]string, error) {
 	var userPolicies []string
 
@@ -125,11 +125,15 @@ var _ = Describe("IAM User", func() {
 				UserName: userName,
 				UserARN:  "user-arn",
 				UserID:   "user-id",
+				Tags:     map[string]string{"Expires at": "2024-01-01T00:00:00Z"},
 			}
 
 			getUser = &iam.User{
 				Arn:    aws.String("user-arn"),
 				UserId: aws.String("user-id"),
+				Tags: []*iam.Tag{
+					{Key: aws.String("Expires at"), Value: aws.String("2024-01-01T00:00:00Z")},
+				},
 			}
 			getUserInput = &iam.GetUserInput{
 				UserName: aws.String(userName),
@@ -561,7 +565,7 @@ var _ = Describe("IAM User", func() {
 		})
 
 		It("creates the Access Key", func() {
-			policyARN, err := user.CreatePolicy(policyName, iamPath, template, resources, iamTags)
+			policyARN, err := user.CreatePolicy(policyName, iamPath, template, resources, "", iamTags)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(policyARN).To(Equal("policy-arn"))
 		})
@@ -572,7 +576,7 @@ var _ = Describe("IAM User", func() {
 			})
 
 			It("returns the proper error", func() {
-				_, err := user.CreatePolicy(policyName, iamPath, template, resources, iamTags)
+				_, err := user.CreatePolicy(policyName, iamPath, template, resources, "", iamTags)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(Equal("operation failed"))
 			})
@@ -583,11 +587,22 @@ var _ = Describe("IAM User", func() {
 				})
 
 				It("returns the proper error", func() {
-					_, err := user.CreatePolicy(policyName, iamPath, template, resources, iamTags)
+					_, err := user.CreatePolicy(policyName, iamPath, template, resources, "", iamTags)
 					Expect(err).To(HaveOccurred())
 					Expect(err.Error()).To(Equal("code: message"))
 				})
 			})
+
+			Context("because the Policy already exists", func() {
+				BeforeEach(func() {
+					createPolicyError = awserr.New(iam.ErrCodeEntityAlreadyExistsException, "message", errors.New("operation failed"))
+				})
+
+				It("returns ErrEntityAlreadyExists", func() {
+					_, err := user.CreatePolicy(policyName, iamPath, template, resources, "", iamTags)
+					Expect(err).To(Equal(ErrEntityAlreadyExists))
+				})
+			})
 		})
 	})
 
@@ -650,6 +665,148 @@ var _ = Describe("IAM User", func() {
 		})
 	})
 
+	var _ = Describe("GetPolicyDocument", func() {
+		var (
+			policyARN string
+
+			getPolicyError        error
+			getPolicyVersionError error
+		)
+
+		BeforeEach(func() {
+			policyARN = "policy-arn"
+
+			getPolicyError = nil
+			getPolicyVersionError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				switch r.Operation.Name {
+				case "GetPolicy":
+					Expect(r.Params).To(Equal(&iam.GetPolicyInput{
+						PolicyArn: aws.String(policyARN),
+					}))
+					data := r.Data.(*iam.GetPolicyOutput)
+					data.Policy = &iam.Policy{
+						DefaultVersionId: aws.String("v2"),
+					}
+					r.Error = getPolicyError
+				case "GetPolicyVersion":
+					Expect(r.Params).To(Equal(&iam.GetPolicyVersionInput{
+						PolicyArn: aws.String(policyARN),
+						VersionId: aws.String("v2"),
+					}))
+					data := r.Data.(*iam.GetPolicyVersionOutput)
+					data.PolicyVersion = &iam.PolicyVersion{
+						Document: aws.String("%7B%22Version%22%3A%222012-10-17%22%7D"),
+					}
+					r.Error = getPolicyVersionError
+				default:
+					Fail("unexpected operation " + r.Operation.Name)
+				}
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("returns the decoded default version of the Policy", func() {
+			document, err := user.GetPolicyDocument(policyARN)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(document).To(Equal(`{"Version":"2012-10-17"}`))
+		})
+
+		Context("when getting the Policy fails", func() {
+			BeforeEach(func() {
+				getPolicyError = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("returns the proper error", func() {
+				_, err := user.GetPolicyDocument(policyARN)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("code: message"))
+			})
+		})
+
+		Context("when getting the Policy version fails", func() {
+			BeforeEach(func() {
+				getPolicyVersionError = errors.New("operation failed")
+			})
+
+			It("returns the proper error", func() {
+				_, err := user.GetPolicyDocument(policyARN)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("operation failed"))
+			})
+		})
+	})
+
+	var _ = Describe("List", func() {
+		var (
+			listUsersUsers []*iam.User
+
+			listUsersInput *iam.ListUsersInput
+			listUsersError error
+		)
+
+		BeforeEach(func() {
+			listUsersUsers = []*iam.User{
+				&iam.User{UserName: aws.String("user-1")},
+				&iam.User{UserName: aws.String("user-2")},
+			}
+
+			listUsersInput = &iam.ListUsersInput{
+				PathPrefix: aws.String(iamPath),
+			}
+			listUsersError = nil
+		})
+
+		JustBeforeEach(func() {
+			iamsvc.Handlers.Clear()
+
+			iamCall = func(r *request.Request) {
+				Expect(r.Operation.Name).To(Equal("ListUsers"))
+				Expect(r.Params).To(BeAssignableToTypeOf(&iam.ListUsersInput{}))
+				Expect(r.Params).To(Equal(listUsersInput))
+				data := r.Data.(*iam.ListUsersOutput)
+				data.Users = listUsersUsers
+				r.Error = listUsersError
+			}
+			iamsvc.Handlers.Send.PushBack(iamCall)
+		})
+
+		It("lists the Users", func() {
+			userNames, err := user.List(iamPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(userNames).To(Equal([]string{"user-1", "user-2"}))
+		})
+
+		Context("when listing the Users fails", func() {
+			BeforeEach(func() {
+				listUsersError = errors.New("operation failed")
+			})
+
+			It("returns the proper error", func() {
+				_, err := user.List(iamPath)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("operation failed"))
+			})
+
+			Context("and it is an AWS error", func() {
+				BeforeEach(func() {
+					listUsersError = awserr.New("code", "message", errors.New("operation failed"))
+				})
+
+				It("returns the proper error", func() {
+					_, err := user.List(iamPath)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(Equal("code: message"))
+				})
+			})
+		})
+	})
+
 	var _ = Describe("ListAttachedUserPolicies", func() {
 		var (
 			listAttachedUserPoliciesAttachedPolicies []*iam.AttachedPolicy
@@ -0,0 +1,149 @@
+package awsiam
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// listBucketActions are the bucket-level actions that accept an s3:prefix
+// condition.
+var listBucketActions = []string{"s3:ListBucket", "s3:ListBucketVersions"}
+
+// RenderPolicy renders an IAM policy template for a binding to resources, the
+// ARNs of the bound buckets. The template can use {{resources "suffix"}} to
+// render a JSON list of the resources with suffix appended, and .Resource and
+// .Resources for the raw ARNs.
+//
+// If prefix is not empty, the binding is confined to keys under prefix: object
+// ARNs rendered by the resources function are scoped to the prefix, listing is
+// only allowed with an s3:prefix condition matching it, and no other
+// bucket-level action but reading the bucket's configuration is allowed.
+func RenderPolicy(policyTemplate string, resources []string, prefix string) (string, error) {
+	tmpl, err := template.New("policy").Funcs(template.FuncMap{
+		"resources": func(suffix string) string {
+			resourcePaths := make([]string, len(resources))
+			for idx, resource := range resources {
+				if prefix != "" && strings.HasPrefix(suffix, "/") {
+					resourcePaths[idx] = resource + "/" + prefix + strings.TrimPrefix(suffix, "/")
+				} else {
+					resourcePaths[idx] = resource + suffix
+				}
+			}
+			marshaled, _ := json.Marshal(resourcePaths)
+			return string(marshaled)
+		},
+	}).Parse(policyTemplate)
+	if err != nil {
+		return "", err
+	}
+	policy := bytes.Buffer{}
+	err = tmpl.Execute(&policy, map[string]interface{}{
+		"Resource":  resources[0],
+		"Resources": resources,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if prefix == "" {
+		return policy.String(), nil
+	}
+	return restrictToPrefix(policy.String(), prefix)
+}
+
+// restrictToPrefix confines the bucket-level actions of policy to a binding
+// with a prefix, which shares its bucket with bindings to other prefixes and
+// must neither see their keys nor change the bucket's configuration. In
+// statements that allow actions on buckets rather than objects, the listing
+// actions are moved into statements of their own that only allow listing keys
+// under prefix, the read-only Get actions are kept, and every other action is
+// dropped, since the s3:prefix condition key is only present on list requests.
+func restrictToPrefix(policy, prefix string) (string, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return "", err
+	}
+
+	var statements []interface{}
+	switch statement := document["Statement"].(type) {
+	case []interface{}:
+		statements = statement
+	case map[string]interface{}:
+		statements = []interface{}{statement}
+	}
+
+	var scoped []interface{}
+	for _, s := range statements {
+		statement, ok := s.(map[string]interface{})
+		if !ok || statement["Effect"] != "Allow" || !hasBucketResource(statement) {
+			scoped = append(scoped, s)
+			continue
+		}
+
+		var getActions, listActions []string
+		for _, action := range oneOrMany(statement["Action"]) {
+			switch {
+			case slices.Contains(listBucketActions, action):
+				listActions = append(listActions, action)
+			case strings.HasPrefix(action, "s3:Get"):
+				getActions = append(getActions, action)
+			}
+		}
+
+		if len(getActions) > 0 {
+			statement["Action"] = getActions
+			scoped = append(scoped, statement)
+		}
+		if len(listActions) > 0 {
+			scoped = append(scoped, map[string]interface{}{
+				"Effect":   statement["Effect"],
+				"Action":   listActions,
+				"Resource": statement["Resource"],
+				"Condition": map[string]interface{}{
+					"StringLike": map[string]interface{}{
+						"s3:prefix": []string{prefix + "*"},
+					},
+				},
+			})
+		}
+	}
+	document["Statement"] = scoped
+
+	marshaled, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(marshaled), nil
+}
+
+// hasBucketResource reports whether a statement applies to buckets, rather
+// than only to objects. Object ARNs have the form arn:partition:s3:::bucket/key.
+func hasBucketResource(statement map[string]interface{}) bool {
+	for _, resource := range oneOrMany(statement["Resource"]) {
+		if _, key, _ := strings.Cut(resource, ":::"); !strings.Contains(key, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// oneOrMany returns the strings of a policy element that may hold a single
+// string or a list.
+func oneOrMany(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if v, ok := v.(string); ok {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	return nil
+}
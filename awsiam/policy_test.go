@@ -0,0 +1,168 @@
+package awsiam_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloud-gov/s3-broker/awsiam"
+)
+
+var _ = Describe("RenderPolicy", func() {
+	var (
+		template  string
+		resources []string
+		prefix    string
+	)
+
+	BeforeEach(func() {
+		template = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": ["s3:GetBucketLocation", "s3:ListBucket"],
+			"Resource": {{resources ""}}
+		},
+		{
+			"Effect": "Allow",
+			"Action": ["s3:GetObject", "s3:PutObject"],
+			"Resource": {{resources "/*"}}
+		}
+	]
+}`
+		resources = []string{"arn:aws:s3:::bucket-1", "arn:aws:s3:::bucket-2"}
+		prefix = ""
+	})
+
+	It("renders the resources", func() {
+		policy, err := RenderPolicy(template, resources, prefix)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(policy).To(MatchJSON(`{
+			"Version": "2012-10-17",
+			"Statement": [
+				{
+					"Effect": "Allow",
+					"Action": ["s3:GetBucketLocation", "s3:ListBucket"],
+					"Resource": ["arn:aws:s3:::bucket-1", "arn:aws:s3:::bucket-2"]
+				},
+				{
+					"Effect": "Allow",
+					"Action": ["s3:GetObject", "s3:PutObject"],
+					"Resource": ["arn:aws:s3:::bucket-1/*", "arn:aws:s3:::bucket-2/*"]
+				}
+			]
+		}`))
+	})
+
+	It("returns error if the template is invalid", func() {
+		_, err := RenderPolicy("{{resources", resources, prefix)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("when a prefix is given", func() {
+		BeforeEach(func() {
+			prefix = "tenant-x/"
+		})
+
+		It("scopes object resources and listing to the prefix", func() {
+			policy, err := RenderPolicy(template, resources, prefix)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy).To(MatchJSON(`{
+				"Version": "2012-10-17",
+				"Statement": [
+					{
+						"Effect": "Allow",
+						"Action": ["s3:GetBucketLocation"],
+						"Resource": ["arn:aws:s3:::bucket-1", "arn:aws:s3:::bucket-2"]
+					},
+					{
+						"Effect": "Allow",
+						"Action": ["s3:ListBucket"],
+						"Resource": ["arn:aws:s3:::bucket-1", "arn:aws:s3:::bucket-2"],
+						"Condition": {"StringLike": {"s3:prefix": ["tenant-x/*"]}}
+					},
+					{
+						"Effect": "Allow",
+						"Action": ["s3:GetObject", "s3:PutObject"],
+						"Resource": ["arn:aws:s3:::bucket-1/tenant-x/*", "arn:aws:s3:::bucket-2/tenant-x/*"]
+					}
+				]
+			}`))
+		})
+
+		It("does not let the binding change the bucket's configuration", func() {
+			template = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": [
+				"s3:GetBucketLocation",
+				"s3:GetBucketVersioning",
+				"s3:ListBucket",
+				"s3:ListBucketMultipartUploads",
+				"s3:PutBucketVersioning",
+				"s3:PutBucketWebsite",
+				"s3:DeleteBucketWebsite",
+				"s3:PutBucketNotification"
+			],
+			"Resource": {{resources ""}}
+		},
+		{
+			"Effect": "Allow",
+			"Action": ["s3:GetObject", "s3:PutObject"],
+			"Resource": {{resources "/*"}}
+		}
+	]
+}`
+			policy, err := RenderPolicy(template, resources, prefix)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy).To(MatchJSON(`{
+				"Version": "2012-10-17",
+				"Statement": [
+					{
+						"Effect": "Allow",
+						"Action": ["s3:GetBucketLocation", "s3:GetBucketVersioning"],
+						"Resource": ["arn:aws:s3:::bucket-1", "arn:aws:s3:::bucket-2"]
+					},
+					{
+						"Effect": "Allow",
+						"Action": ["s3:ListBucket"],
+						"Resource": ["arn:aws:s3:::bucket-1", "arn:aws:s3:::bucket-2"],
+						"Condition": {"StringLike": {"s3:prefix": ["tenant-x/*"]}}
+					},
+					{
+						"Effect": "Allow",
+						"Action": ["s3:GetObject", "s3:PutObject"],
+						"Resource": ["arn:aws:s3:::bucket-1/tenant-x/*", "arn:aws:s3:::bucket-2/tenant-x/*"]
+					}
+				]
+			}`))
+		})
+
+		It("leaves deny statements alone", func() {
+			template = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Deny",
+			"Action": ["s3:PutBucketPolicy"],
+			"Resource": {{resources ""}}
+		}
+	]
+}`
+			policy, err := RenderPolicy(template, resources, prefix)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy).To(MatchJSON(`{
+				"Version": "2012-10-17",
+				"Statement": [
+					{
+						"Effect": "Deny",
+						"Action": ["s3:PutBucketPolicy"],
+						"Resource": ["arn:aws:s3:::bucket-1", "arn:aws:s3:::bucket-2"]
+					}
+				]
+			}`))
+		})
+	})
+})
@@ -0,0 +1,38 @@
+package awsiam
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Role manages the IAM roles that back bindings using temporary credentials.
+// It is the role-based counterpart of User. It also manages the roles that AWS
+// services assume on the broker's behalf, such as for bucket replication.
+type Role interface {
+	Exists(roleName string) (bool, error)
+	Create(roleName, iamPath string, iamTags []*iam.Tag) (string, error)
+	CreateServiceRole(roleName, iamPath, service string, iamTags []*iam.Tag) (string, error)
+	Delete(roleName string) error
+	ListAttachedRolePolicies(roleName, iamPath string) ([]string, error)
+	AttachRolePolicy(roleName, policyARN string) error
+	DetachRolePolicy(roleName, policyARN string) error
+	AssumeRole(roleARN, sessionName string) (RoleCredentials, error)
+}
+
+// RoleCredentials are temporary credentials for a role session.
+type RoleCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+func NewRole(logger lager.Logger, awsSession *session.Session) Role {
+	fmt.Printf("Setting up AWS IAM role provider...\n")
+	return NewIAMRole(iam.New(awsSession), sts.New(awsSession), logger)
+}
@@ -14,11 +14,13 @@ type User interface {
 	Describe(userName string) (UserDetails, error)
 	Create(userName, iamPath string, iamTags []*iam.Tag) (string, error)
 	Delete(userName string) error
+	List(iamPath string) ([]string, error)
 	ListAccessKeys(userName string) ([]string, error)
 	CreateAccessKey(userName string) (string, string, error)
 	DeleteAccessKey(userName, accessKeyID string) error
-	CreatePolicy(policyName, iamPath, policyTemplate string, resources []string, iamTags []*iam.Tag) (string, error)
+	CreatePolicy(policyName, iamPath, policyTemplate string, resources []string, prefix string, iamTags []*iam.Tag) (string, error)
 	DeletePolicy(policyARN string) error
+	GetPolicyDocument(policyARN string) (string, error)
 	ListAttachedUserPolicies(userName, iamPath string) ([]string, error)
 	AttachUserPolicy(userName, policyARN string) error
 	DetachUserPolicy(userName, policyARN string) error
@@ -28,10 +30,22 @@ type UserDetails struct {
 	UserName string
 	UserARN  string
 	UserID   string
+	Tags     map[string]string
 }
 
+// ExpiresAtTagKey is the IAM tag that records when a binding expires, as an
+// RFC 3339 timestamp. Expired bindings are removed by the
+// revoke-expired-bindings task.
+const ExpiresAtTagKey = "Expires at"
+
+// PermissionsTagKey is the IAM tag that records the permissions a binding was
+// created with, or "default" for the plan's iam_policy, so that its policy
+// can be rendered again by the reconcile-policies task.
+const PermissionsTagKey = "Binding permissions"
+
 var (
-	ErrUserDoesNotExist = errors.New("iam user does not exist")
+	ErrUserDoesNotExist    = errors.New("iam user does not exist")
+	ErrEntityAlreadyExists = errors.New("iam entity already exists")
 )
 
 func NewUser(provider string, logger lager.Logger, awsSession *session.Session, endpoint string, insecureSkipVerify bool) (User, error) {
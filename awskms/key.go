@@ -0,0 +1,26 @@
+package awskms
+
+import (
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Key manages the KMS customer managed keys that encrypt the buckets of
+// instances with a dedicated key. Keys are found by their alias, such as
+// "alias/cf-1234".
+type Key interface {
+	Create(alias, description string, tags map[string]string) (string, error)
+	Describe(alias string) (string, error)
+	ScheduleDeletion(alias string, pendingWindowInDays int64) error
+}
+
+var ErrKeyDoesNotExist = errors.New("kms key does not exist")
+
+func NewKey(logger lager.Logger, awsSession *session.Session) Key {
+	fmt.Printf("Setting up AWS KMS key provider...\n")
+	return NewKMSKey(kms.New(awsSession), logger)
+}
@@ -0,0 +1,155 @@
+package awskms
+
+import (
+	"errors"
+	"sort"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+type KMSKey struct {
+	kmssvc *kms.KMS
+	logger lager.Logger
+}
+
+func NewKMSKey(
+	kmssvc *kms.KMS,
+	logger lager.Logger,
+) *KMSKey {
+	return &KMSKey{
+		kmssvc: kmssvc,
+		logger: logger.Session("kms-key"),
+	}
+}
+
+// Create creates a symmetric encryption key with the default key policy, which
+// defers to IAM policies in the broker's account, and points alias at it. It
+// returns the key's ARN.
+func (k *KMSKey) Create(alias, description string, tags map[string]string) (string, error) {
+	createKeyInput := &kms.CreateKeyInput{
+		Description: aws.String(description),
+		Tags:        convertTagsMapToKMSTags(tags),
+	}
+	k.logger.Debug("create-key", lager.Data{"input": createKeyInput})
+
+	createKeyOutput, err := k.kmssvc.CreateKey(createKeyInput)
+	if err != nil {
+		k.logger.Error("create-key.aws-kms-error", err)
+		return "", awsError(err)
+	}
+	k.logger.Debug("create-key", lager.Data{"output": createKeyOutput})
+	keyID := createKeyOutput.KeyMetadata.KeyId
+
+	createAliasInput := &kms.CreateAliasInput{
+		AliasName:   aws.String(alias),
+		TargetKeyId: keyID,
+	}
+	k.logger.Debug("create-alias", lager.Data{"input": createAliasInput})
+	if _, err := k.kmssvc.CreateAlias(createAliasInput); err != nil {
+		k.logger.Error("create-alias.aws-kms-error", err)
+		// Without its alias the key cannot be found again, so do not leave it
+		// behind.
+		if derr := k.scheduleKeyDeletion(keyID, 7); derr != nil {
+			k.logger.Error("create-alias.schedule-key-deletion", derr)
+		}
+		return "", awsError(err)
+	}
+
+	return aws.StringValue(createKeyOutput.KeyMetadata.Arn), nil
+}
+
+// Describe returns the ARN of the key that alias points to, or
+// ErrKeyDoesNotExist.
+func (k *KMSKey) Describe(alias string) (string, error) {
+	describeKeyInput := &kms.DescribeKeyInput{
+		KeyId: aws.String(alias),
+	}
+	k.logger.Debug("describe-key", lager.Data{"input": describeKeyInput})
+
+	describeKeyOutput, err := k.kmssvc.DescribeKey(describeKeyInput)
+	if err != nil {
+		if isAWSErrorCode(err, kms.ErrCodeNotFoundException) {
+			return "", ErrKeyDoesNotExist
+		}
+		k.logger.Error("describe-key.aws-kms-error", err)
+		return "", awsError(err)
+	}
+	k.logger.Debug("describe-key", lager.Data{"output": describeKeyOutput})
+
+	return aws.StringValue(describeKeyOutput.KeyMetadata.Arn), nil
+}
+
+// ScheduleDeletion deletes alias and schedules the key it points to for
+// deletion after pendingWindowInDays, between 7 and 30. Until then the key can
+// be recovered with kms:CancelKeyDeletion. It does nothing if the alias does
+// not exist.
+func (k *KMSKey) ScheduleDeletion(alias string, pendingWindowInDays int64) error {
+	keyARN, err := k.Describe(alias)
+	if err != nil {
+		if err == ErrKeyDoesNotExist {
+			return nil
+		}
+		return err
+	}
+
+	deleteAliasInput := &kms.DeleteAliasInput{
+		AliasName: aws.String(alias),
+	}
+	k.logger.Debug("delete-alias", lager.Data{"input": deleteAliasInput})
+	if _, err := k.kmssvc.DeleteAlias(deleteAliasInput); err != nil && !isAWSErrorCode(err, kms.ErrCodeNotFoundException) {
+		k.logger.Error("delete-alias.aws-kms-error", err)
+		return awsError(err)
+	}
+
+	return k.scheduleKeyDeletion(aws.String(keyARN), pendingWindowInDays)
+}
+
+func (k *KMSKey) scheduleKeyDeletion(keyID *string, pendingWindowInDays int64) error {
+	scheduleKeyDeletionInput := &kms.ScheduleKeyDeletionInput{
+		KeyId:               keyID,
+		PendingWindowInDays: aws.Int64(pendingWindowInDays),
+	}
+	k.logger.Debug("schedule-key-deletion", lager.Data{"input": scheduleKeyDeletionInput})
+
+	scheduleKeyDeletionOutput, err := k.kmssvc.ScheduleKeyDeletion(scheduleKeyDeletionInput)
+	if err != nil {
+		// The key is already pending deletion.
+		if isAWSErrorCode(err, kms.ErrCodeInvalidStateException) {
+			return nil
+		}
+		k.logger.Error("schedule-key-deletion.aws-kms-error", err)
+		return awsError(err)
+	}
+	k.logger.Debug("schedule-key-deletion", lager.Data{"output": scheduleKeyDeletionOutput})
+
+	return nil
+}
+
+func convertTagsMapToKMSTags(tags map[string]string) []*kms.Tag {
+	var kmsTags []*kms.Tag
+	for key, value := range tags {
+		kmsTags = append(kmsTags, &kms.Tag{
+			TagKey:   aws.String(key),
+			TagValue: aws.String(value),
+		})
+	}
+	sort.Slice(kmsTags, func(i, j int) bool {
+		return aws.StringValue(kmsTags[i].TagKey) < aws.StringValue(kmsTags[j].TagKey)
+	})
+	return kmsTags
+}
+
+func isAWSErrorCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
+
+func awsError(err error) error {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return errors.New(awsErr.Code() + ": " + awsErr.Message())
+	}
+	return err
+}
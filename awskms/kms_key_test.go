@@ -0,0 +1,157 @@
+package awskms_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloud-gov/s3-broker/awskms"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+var _ = Describe("KMS Key", func() {
+	var (
+		alias  string
+		keyARN string
+
+		awsSession *session.Session
+		kmssvc     *kms.KMS
+
+		testSink *lagertest.TestSink
+		logger   lager.Logger
+
+		key Key
+
+		operations []string
+	)
+
+	BeforeEach(func() {
+		alias = "alias/cf-instance"
+		keyARN = "arn:aws-us-gov:kms:us-gov-west-1:123456789012:key/key-id"
+		operations = nil
+	})
+
+	JustBeforeEach(func() {
+		awsSession = session.New(nil)
+		kmssvc = kms.New(awsSession)
+
+		logger = lager.NewLogger("kmskey_test")
+		testSink = lagertest.NewTestSink()
+		logger.RegisterSink(testSink)
+
+		key = NewKMSKey(kmssvc, logger)
+	})
+
+	var _ = Describe("Create", func() {
+		var (
+			createAliasErr error
+			scheduledKeyID string
+		)
+
+		BeforeEach(func() {
+			createAliasErr = nil
+			scheduledKeyID = ""
+		})
+
+		JustBeforeEach(func() {
+			kmssvc.Handlers.Clear()
+			kmssvc.Handlers.Send.PushBack(func(r *request.Request) {
+				operations = append(operations, r.Operation.Name)
+				switch input := r.Params.(type) {
+				case *kms.CreateKeyInput:
+					Expect(aws.StringValue(input.Description)).To(Equal("S3 instance"))
+					Expect(input.Tags).To(Equal([]*kms.Tag{
+						{TagKey: aws.String("a"), TagValue: aws.String("1")},
+						{TagKey: aws.String("b"), TagValue: aws.String("2")},
+					}))
+					data := r.Data.(*kms.CreateKeyOutput)
+					data.KeyMetadata = &kms.KeyMetadata{KeyId: aws.String("key-id"), Arn: aws.String(keyARN)}
+				case *kms.CreateAliasInput:
+					Expect(aws.StringValue(input.AliasName)).To(Equal(alias))
+					Expect(aws.StringValue(input.TargetKeyId)).To(Equal("key-id"))
+					r.Error = createAliasErr
+				case *kms.ScheduleKeyDeletionInput:
+					scheduledKeyID = aws.StringValue(input.KeyId)
+				}
+			})
+		})
+
+		It("creates a key with an alias", func() {
+			createdARN, err := key.Create(alias, "S3 instance", map[string]string{"b": "2", "a": "1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(createdARN).To(Equal(keyARN))
+			Expect(operations).To(Equal([]string{"CreateKey", "CreateAlias"}))
+		})
+
+		Context("when creating the alias fails", func() {
+			BeforeEach(func() {
+				createAliasErr = awserr.New("code", "message", errors.New("operation failed"))
+			})
+
+			It("schedules the new key for deletion", func() {
+				_, err := key.Create(alias, "S3 instance", map[string]string{"b": "2", "a": "1"})
+				Expect(err).To(MatchError("code: message"))
+				Expect(operations).To(Equal([]string{"CreateKey", "CreateAlias", "ScheduleKeyDeletion"}))
+				Expect(scheduledKeyID).To(Equal("key-id"))
+			})
+		})
+	})
+
+	var _ = Describe("ScheduleDeletion", func() {
+		var (
+			describeKeyErr error
+			pendingWindow  int64
+		)
+
+		BeforeEach(func() {
+			describeKeyErr = nil
+			pendingWindow = 0
+		})
+
+		JustBeforeEach(func() {
+			kmssvc.Handlers.Clear()
+			kmssvc.Handlers.Send.PushBack(func(r *request.Request) {
+				operations = append(operations, r.Operation.Name)
+				switch input := r.Params.(type) {
+				case *kms.DescribeKeyInput:
+					Expect(aws.StringValue(input.KeyId)).To(Equal(alias))
+					data := r.Data.(*kms.DescribeKeyOutput)
+					data.KeyMetadata = &kms.KeyMetadata{KeyId: aws.String("key-id"), Arn: aws.String(keyARN)}
+					r.Error = describeKeyErr
+				case *kms.DeleteAliasInput:
+					Expect(aws.StringValue(input.AliasName)).To(Equal(alias))
+				case *kms.ScheduleKeyDeletionInput:
+					Expect(aws.StringValue(input.KeyId)).To(Equal(keyARN))
+					pendingWindow = aws.Int64Value(input.PendingWindowInDays)
+				}
+			})
+		})
+
+		It("deletes the alias and schedules the key for deletion", func() {
+			err := key.ScheduleDeletion(alias, 30)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(operations).To(Equal([]string{"DescribeKey", "DeleteAlias", "ScheduleKeyDeletion"}))
+			Expect(pendingWindow).To(Equal(int64(30)))
+		})
+
+		Context("when the key does not exist", func() {
+			BeforeEach(func() {
+				describeKeyErr = awserr.New(kms.ErrCodeNotFoundException, "not found", nil)
+			})
+
+			It("does nothing", func() {
+				err := key.ScheduleDeletion(alias, 30)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(operations).To(Equal([]string{"DescribeKey"}))
+			})
+		})
+	})
+})
@@ -2,13 +2,18 @@ package awss3
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 )
 
 type Bucket interface {
 	Describe(bucketName, partition string) (BucketDetails, error)
+	Inspect(bucketName, partition string) (BucketDetails, error)
 	Create(bucketName string, details BucketDetails) (string, error)
 	Modify(bucketName string, details BucketDetails) error
 	Delete(bucketName string, deleteObjects bool) error
+	Empty(bucketName string, progress func(deleted int)) error
+	List(prefix string) ([]string, error)
 }
 
 type BucketDetails struct {
@@ -21,8 +26,73 @@ type BucketDetails struct {
 	Tags            map[string]string
 	FIPSEndpoint    string
 	ObjectOwnership string
+	Public          bool
+
+	// Versioning is the bucket's versioning status, "Enabled" or "Suspended".
+	// When empty, Create and Modify leave versioning unchanged.
+	Versioning string
+
+	// Lifecycle is the JSON encoding of the bucket's
+	// BucketLifecycleConfiguration. When empty, Create leaves the bucket
+	// without a lifecycle configuration and Modify removes it; a
+	// configuration without rules also removes it.
+	Lifecycle string
+
+	// CORS is the JSON encoding of the bucket's CORSConfiguration. Like
+	// Lifecycle, when empty Create leaves it unset and Modify removes it, and
+	// no rules removes it.
+	CORS string
+
+	// Website is the JSON encoding of the bucket's WebsiteConfiguration. When
+	// empty, Create leaves website hosting off and Modify turns it off.
+	Website string
+
+	// ObjectLock is the JSON encoding of the bucket's ObjectLockConfiguration.
+	// Create enables object lock on the new bucket when it is set. Object lock
+	// cannot be turned off once enabled, so when empty Create and Modify leave
+	// it unchanged.
+	ObjectLock string
+
+	// Replication is the JSON encoding of the bucket's
+	// ReplicationConfiguration. Replication requires versioning. When empty,
+	// Create and Modify leave replication unchanged.
+	Replication string
+
+	// Logging is the JSON encoding of the bucket's LoggingEnabled settings:
+	// the bucket and prefix its server access logs are delivered to. When
+	// empty, Create leaves access logging off and Modify turns it off.
+	Logging string
 }
 
+// ConfiguredTagKey is the bucket tag that Create sets once it has applied all
+// of a new bucket's configuration. A bucket without it was left half
+// configured by an interrupted Create.
+const ConfiguredTagKey = "Configured at"
+
+// QuotaStatementSid identifies the bucket policy statement that the
+// reconcile-quotas task adds to deny uploads to a bucket over quota. Modify
+// keeps it when re-applying the plan's policy.
+const QuotaStatementSid = "QuotaExceeded"
+
 var (
-	ErrBucketDoesNotExist = errors.New("s3 bucket does not exist")
+	ErrBucketDoesNotExist     = errors.New("s3 bucket does not exist")
+	ErrBucketHasLockedObjects = errors.New("s3 bucket has objects under object lock retention or legal hold")
+	ErrBucketAlreadyOwned     = errors.New("s3 bucket already exists and is owned by the broker")
 )
+
+// Regions whose website endpoints use a dash, rather than a dot, after
+// "s3-website".
+var dashWebsiteRegions = []string{
+	"us-east-1", "us-west-1", "us-west-2", "us-gov-west-1",
+	"ap-southeast-1", "ap-southeast-2", "ap-northeast-1",
+	"eu-west-1", "sa-east-1",
+}
+
+// WebsiteEndpoint returns the URL of the static website hosted by a bucket.
+func WebsiteEndpoint(bucketName, region string) string {
+	separator := "."
+	if slices.Contains(dashWebsiteRegions, region) {
+		separator = "-"
+	}
+	return fmt.Sprintf("http://%s.s3-website%s%s.amazonaws.com", bucketName, separator, region)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"text/template"
 	"time"
 
@@ -25,6 +26,34 @@ type S3Client interface {
 	DeletePublicAccessBlock(input *s3.DeletePublicAccessBlockInput) (*s3.DeletePublicAccessBlockOutput, error)
 	DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error)
 	GetPublicAccessBlock(input *s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error)
+	PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error)
+	GetBucketTagging(input *s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error)
+	DeleteBucketPolicy(input *s3.DeleteBucketPolicyInput) (*s3.DeleteBucketPolicyOutput, error)
+	GetBucketOwnershipControls(input *s3.GetBucketOwnershipControlsInput) (*s3.GetBucketOwnershipControlsOutput, error)
+	GetBucketEncryption(input *s3.GetBucketEncryptionInput) (*s3.GetBucketEncryptionOutput, error)
+	GetBucketPolicy(input *s3.GetBucketPolicyInput) (*s3.GetBucketPolicyOutput, error)
+	GetBucketPolicyStatus(input *s3.GetBucketPolicyStatusInput) (*s3.GetBucketPolicyStatusOutput, error)
+	GetBucketVersioning(input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error)
+	PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error)
+	GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error)
+	DeleteBucketLifecycle(input *s3.DeleteBucketLifecycleInput) (*s3.DeleteBucketLifecycleOutput, error)
+	GetBucketCors(input *s3.GetBucketCorsInput) (*s3.GetBucketCorsOutput, error)
+	PutBucketCors(input *s3.PutBucketCorsInput) (*s3.PutBucketCorsOutput, error)
+	DeleteBucketCors(input *s3.DeleteBucketCorsInput) (*s3.DeleteBucketCorsOutput, error)
+	GetBucketWebsite(input *s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error)
+	PutBucketWebsite(input *s3.PutBucketWebsiteInput) (*s3.PutBucketWebsiteOutput, error)
+	DeleteBucketWebsite(input *s3.DeleteBucketWebsiteInput) (*s3.DeleteBucketWebsiteOutput, error)
+	GetObjectLockConfiguration(input *s3.GetObjectLockConfigurationInput) (*s3.GetObjectLockConfigurationOutput, error)
+	PutObjectLockConfiguration(input *s3.PutObjectLockConfigurationInput) (*s3.PutObjectLockConfigurationOutput, error)
+	ListObjectVersions(input *s3.ListObjectVersionsInput) (*s3.ListObjectVersionsOutput, error)
+	GetObjectRetention(input *s3.GetObjectRetentionInput) (*s3.GetObjectRetentionOutput, error)
+	GetObjectLegalHold(input *s3.GetObjectLegalHoldInput) (*s3.GetObjectLegalHoldOutput, error)
+	GetBucketReplication(input *s3.GetBucketReplicationInput) (*s3.GetBucketReplicationOutput, error)
+	PutBucketReplication(input *s3.PutBucketReplicationInput) (*s3.PutBucketReplicationOutput, error)
+	GetBucketLogging(input *s3.GetBucketLoggingInput) (*s3.GetBucketLoggingOutput, error)
+	PutBucketLogging(input *s3.PutBucketLoggingInput) (*s3.PutBucketLoggingOutput, error)
+	ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error)
 }
 
 type S3Bucket struct {
@@ -61,168 +90,914 @@ func (s *S3Bucket) Describe(bucketName, partition string) (BucketDetails, error)
 	s.logger.Debug("get-bucket-location", lager.Data{"input": getLocationInput})
 
 	getLocationOutput, err := s.s3svc.GetBucketLocation(getLocationInput)
+	if err != nil {
+		if isNoSuchBucketError(err) {
+			return BucketDetails{}, ErrBucketDoesNotExist
+		}
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return BucketDetails{}, errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return BucketDetails{}, err
+	}
+	s.logger.Debug("get-bucket-location", lager.Data{"output": getLocationOutput})
+
+	region := getLocationOutput.LocationConstraint
+	if region == nil {
+		region = aws.String("us-east-1")
+	}
+
+	return s.buildBucketDetails(bucketName, *region, partition, nil), nil
+}
+
+// Inspect describes the bucket like Describe, and also reads its live
+// configuration: object ownership, default encryption, bucket policy, public
+// status, versioning, lifecycle, CORS, website, access logging and tags.
+// Encryption, lifecycle, CORS, website and logging configuration are returned
+// as the JSON encoding of the bucket's configuration, matching the format of
+// plan settings.
+func (s *S3Bucket) Inspect(bucketName, partition string) (BucketDetails, error) {
+	getLocationInput := &s3.GetBucketLocationInput{
+		Bucket: aws.String(bucketName),
+	}
+	s.logger.Debug("get-bucket-location", lager.Data{"input": getLocationInput})
+
+	getLocationOutput, err := s.s3svc.GetBucketLocation(getLocationInput)
+	if err != nil {
+		if isNoSuchBucketError(err) {
+			return BucketDetails{}, ErrBucketDoesNotExist
+		}
+		return BucketDetails{}, s.inspectError(err)
+	}
+	region := getLocationOutput.LocationConstraint
+	if region == nil || *region == "" {
+		region = aws.String("us-east-1")
+	}
+	bucketDetails := s.buildBucketDetails(bucketName, *region, partition, nil)
+	bucketDetails.AwsPartition = partition
+
+	ownershipOutput, err := s.s3svc.GetBucketOwnershipControls(&s3.GetBucketOwnershipControlsInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "OwnershipControlsNotFoundError") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if err == nil && ownershipOutput.OwnershipControls != nil {
+		for _, rule := range ownershipOutput.OwnershipControls.Rules {
+			bucketDetails.ObjectOwnership = aws.StringValue(rule.ObjectOwnership)
+		}
+	}
+
+	encryptionOutput, err := s.s3svc.GetBucketEncryption(&s3.GetBucketEncryptionInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "ServerSideEncryptionConfigurationNotFoundError") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if err == nil && encryptionOutput.ServerSideEncryptionConfiguration != nil {
+		encryption, err := json.Marshal(encryptionOutput.ServerSideEncryptionConfiguration)
+		if err != nil {
+			return BucketDetails{}, err
+		}
+		bucketDetails.Encryption = string(encryption)
+	}
+
+	policyOutput, err := s.s3svc.GetBucketPolicy(&s3.GetBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "NoSuchBucketPolicy") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if err == nil {
+		bucketDetails.Policy = aws.StringValue(policyOutput.Policy)
+
+		policyStatusOutput, err := s.s3svc.GetBucketPolicyStatus(&s3.GetBucketPolicyStatusInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			return BucketDetails{}, s.inspectError(err)
+		}
+		if policyStatusOutput.PolicyStatus != nil {
+			bucketDetails.Public = aws.BoolValue(policyStatusOutput.PolicyStatus.IsPublic)
+		}
+	}
+
+	versioningOutput, err := s.s3svc.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	bucketDetails.Versioning = aws.StringValue(versioningOutput.Status)
+
+	lifecycleOutput, err := s.s3svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "NoSuchLifecycleConfiguration") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if err == nil {
+		lifecycle, err := json.Marshal(s3.BucketLifecycleConfiguration{Rules: lifecycleOutput.Rules})
+		if err != nil {
+			return BucketDetails{}, err
+		}
+		bucketDetails.Lifecycle = string(lifecycle)
+	}
+
+	corsOutput, err := s.s3svc.GetBucketCors(&s3.GetBucketCorsInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "NoSuchCORSConfiguration") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if err == nil {
+		cors, err := json.Marshal(s3.CORSConfiguration{CORSRules: corsOutput.CORSRules})
+		if err != nil {
+			return BucketDetails{}, err
+		}
+		bucketDetails.CORS = string(cors)
+	}
+
+	websiteOutput, err := s.s3svc.GetBucketWebsite(&s3.GetBucketWebsiteInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "NoSuchWebsiteConfiguration") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if err == nil {
+		website, err := json.Marshal(s3.WebsiteConfiguration{
+			IndexDocument:         websiteOutput.IndexDocument,
+			ErrorDocument:         websiteOutput.ErrorDocument,
+			RedirectAllRequestsTo: websiteOutput.RedirectAllRequestsTo,
+			RoutingRules:          websiteOutput.RoutingRules,
+		})
+		if err != nil {
+			return BucketDetails{}, err
+		}
+		bucketDetails.Website = string(website)
+	}
+
+	replicationOutput, err := s.s3svc.GetBucketReplication(&s3.GetBucketReplicationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "ReplicationConfigurationNotFoundError") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if err == nil && replicationOutput.ReplicationConfiguration != nil {
+		replication, err := json.Marshal(replicationOutput.ReplicationConfiguration)
+		if err != nil {
+			return BucketDetails{}, err
+		}
+		bucketDetails.Replication = string(replication)
+	}
+
+	objectLockOutput, err := s.s3svc.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "ObjectLockConfigurationNotFoundError") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if err == nil && objectLockOutput.ObjectLockConfiguration != nil {
+		objectLock, err := json.Marshal(objectLockOutput.ObjectLockConfiguration)
+		if err != nil {
+			return BucketDetails{}, err
+		}
+		bucketDetails.ObjectLock = string(objectLock)
+	}
+
+	loggingOutput, err := s.s3svc.GetBucketLogging(&s3.GetBucketLoggingInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	if loggingOutput.LoggingEnabled != nil {
+		logging, err := json.Marshal(loggingOutput.LoggingEnabled)
+		if err != nil {
+			return BucketDetails{}, err
+		}
+		bucketDetails.Logging = string(logging)
+	}
+
+	taggingOutput, err := s.s3svc.GetBucketTagging(&s3.GetBucketTaggingInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil && !isAWSErrorCode(err, "NoSuchTagSet") {
+		return BucketDetails{}, s.inspectError(err)
+	}
+	bucketDetails.Tags = map[string]string{}
+	if err == nil {
+		for _, tag := range taggingOutput.TagSet {
+			bucketDetails.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+
+	s.logger.Debug("inspect-bucket", lager.Data{"details": bucketDetails})
+	return bucketDetails, nil
+}
+
+func (s *S3Bucket) inspectError(err error) error {
+	s.logger.Error("aws-s3-error", err)
+	if awsErr, ok := err.(awserr.Error); ok {
+		return errors.New(awsErr.Code() + ": " + awsErr.Message())
+	}
+	return err
+}
+
+// Create attempts to create an S3 bucket. If successful, it returns the bucket's location
+// and a nil error. If not, it returns an empty string and an error.
+func (s *S3Bucket) Create(bucketName string, bucketDetails BucketDetails) (string, error) {
+	createBucketInput := s.buildCreateBucketInput(bucketName, bucketDetails)
+	s.logger.Debug("create-bucket", lager.Data{"input": createBucketInput})
+
+	createBucketOutput, err := s.s3svc.CreateBucket(createBucketInput)
+	if err != nil {
+		if isAWSErrorCode(err, s3.ErrCodeBucketAlreadyOwnedByYou) {
+			return "", ErrBucketAlreadyOwned
+		}
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return "", errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return "", err
+	}
+	s.logger.Debug("create-bucket", lager.Data{"output": createBucketOutput})
+
+	if err := s.putBucketTags(bucketName, bucketDetails.Tags); err != nil {
+		return "", err
+	}
+
+	if err := s.putBucketEncryption(bucketName, bucketDetails.Encryption); err != nil {
+		return "", err
+	}
+
+	if err := s.putBucketVersioning(bucketName, bucketDetails.Versioning); err != nil {
+		return "", err
+	}
+
+	if err := s.putObjectLock(bucketName, bucketDetails.ObjectLock); err != nil {
+		return "", err
+	}
+
+	if err := s.putBucketReplication(bucketName, bucketDetails.Replication); err != nil {
+		return "", err
+	}
+
+	if err := s.putBucketLifecycle(bucketName, bucketDetails.Lifecycle); err != nil {
+		return "", err
+	}
+
+	if err := s.putBucketCORS(bucketName, bucketDetails.CORS); err != nil {
+		return "", err
+	}
+
+	if err := s.putBucketWebsite(bucketName, bucketDetails.Website); err != nil {
+		return "", err
+	}
+
+	if err := s.putBucketLogging(bucketName, bucketDetails.Logging); err != nil {
+		return "", err
+	}
+
+	if err = s.checkDeletePublicAccessBlock(bucketDetails, bucketName); err != nil {
+		return "", err
+	}
+
+	if err = s.putBucketPolicyWithRetries(bucketDetails, bucketName); err != nil {
+		return "", err
+	}
+
+	// The bucket is tagged as configured last, so that the tag means every
+	// step above succeeded.
+	configuredTags := map[string]string{}
+	for key, value := range bucketDetails.Tags {
+		configuredTags[key] = value
+	}
+	configuredTags[ConfiguredTagKey] = time.Now().UTC().Format(time.RFC3339)
+	if err := s.putBucketTags(bucketName, configuredTags); err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(createBucketOutput.Location), nil
+}
+
+// checkDeletePublicAccessBlock checks the Policy of bucketDetails to see if the bucket
+// is intended to be public. If so, it deletes the Public Access Block that is set on all
+// new S3 buckets by default as of April 2023.
+func (s *S3Bucket) checkDeletePublicAccessBlock(bucketDetails BucketDetails, bucketName string) error {
+	// buckets with no policy are private by default.
+	if bucketDetails.Policy == "" {
+		return nil
+	}
+
+	isPublic, err := s.isPublicPolicy(bucketDetails.Policy)
+	if err != nil {
+		return err
+	}
+	if isPublic {
+		deletePublicAccessBlockInput := &s3.DeletePublicAccessBlockInput{
+			Bucket: aws.String(bucketName),
+		}
+		s.logger.Debug("delete-public-access-block", lager.Data{"input": deletePublicAccessBlockInput})
+		_, err := s.s3svc.DeletePublicAccessBlock(deletePublicAccessBlockInput)
+		if err != nil {
+			s.logger.Error("failed to delete public access block", err)
+			return err
+		}
+
+		isDeleted, err := s.checkIsPublicAccessBlockDeleted(bucketName)
+		if err != nil {
+			s.logger.Error("failed to get public access block", err)
+			return err
+		}
+		retries := 0
+		maxRetries := 10
+		for !isDeleted && retries < maxRetries {
+			retries += 1
+
+			time.Sleep(1 * time.Second)
+
+			isDeleted, err = s.checkIsPublicAccessBlockDeleted(bucketName)
+
+			if err != nil {
+				s.logger.Error("failed to get public access block", err)
+				return err
+			}
+		}
+		if retries == maxRetries {
+			s.logger.Info(fmt.Sprintf("could not verify that public access block was deleted for bucket %s, gave up after %d retries", bucketName, retries))
+		}
+	}
+
+	return nil
+}
+
+// isPublicPolicy reports whether policy grants anonymous read access to the
+// objects in a bucket.
+func (s *S3Bucket) isPublicPolicy(policy string) (bool, error) {
+	if policy == "" {
+		return false, nil
+	}
+
+	var parsed bucketPolicy
+	err := json.Unmarshal([]byte(policy), &parsed)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		return false, err
+	}
+	if len(parsed.Statement) > 1 {
+		err = fmt.Errorf("expected 1 policy statement, got %v", len(parsed.Statement))
+		s.logger.Error("aws-s3-error", err)
+		return false, err
+	}
+
+	publicAccessPolicy := bucketPolicyStatement{
+		Effect:    "Allow",
+		Principal: "*",
+		Action:    []string{"s3:GetObject"},
+	}
+	return slices.ContainsFunc(parsed.Statement, func(statement bucketPolicyStatement) bool {
+		return statement.Effect == publicAccessPolicy.Effect &&
+			statement.Principal == publicAccessPolicy.Principal &&
+			slices.Equal(statement.Action, publicAccessPolicy.Action)
+	}), nil
+}
+
+func (s *S3Bucket) checkIsPublicAccessBlockDeleted(bucketName string) (bool, error) {
+	getPublicAccessBlockInput := &s3.GetPublicAccessBlockInput{
+		Bucket: aws.String(bucketName),
+	}
+	_, err := s.s3svc.GetPublicAccessBlock(getPublicAccessBlockInput)
+	if awsErr, ok := err.(awserr.Error); ok {
+		if awsErr.Code() == "NoSuchPublicAccessBlockConfiguration" {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// Modify re-applies the tags, encryption, versioning, lifecycle, CORS, website,
+// access logging, public access block and bucket policy in bucketDetails to an
+// existing bucket. The lifecycle, CORS, website and access logging
+// configuration is removed when bucketDetails does not define it, so that
+// moving to a plan without it does not leave the old plan's behind. Tags
+// already on the bucket that are not part of bucketDetails, like "Created at",
+// are preserved.
+func (s *S3Bucket) Modify(bucketName string, bucketDetails BucketDetails) error {
+	getTaggingInput := &s3.GetBucketTaggingInput{
+		Bucket: aws.String(bucketName),
+	}
+	s.logger.Debug("get-bucket-tagging", lager.Data{"input": getTaggingInput})
+
+	tags := map[string]string{}
+	getTaggingOutput, err := s.s3svc.GetBucketTagging(getTaggingInput)
+	if err != nil {
+		if isNoSuchBucketError(err) {
+			return ErrBucketDoesNotExist
+		}
+		if !isAWSErrorCode(err, "NoSuchTagSet") {
+			s.logger.Error("aws-s3-error", err)
+			return err
+		}
+	} else {
+		s.logger.Debug("get-bucket-tagging", lager.Data{"output": getTaggingOutput})
+		for _, tag := range getTaggingOutput.TagSet {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	for key, value := range bucketDetails.Tags {
+		tags[key] = value
+	}
+	if err := s.putBucketTags(bucketName, tags); err != nil {
+		return err
+	}
+
+	if err := s.putBucketEncryption(bucketName, bucketDetails.Encryption); err != nil {
+		return err
+	}
+
+	if err := s.putBucketVersioning(bucketName, bucketDetails.Versioning); err != nil {
+		return err
+	}
+
+	if err := s.putObjectLock(bucketName, bucketDetails.ObjectLock); err != nil {
+		return err
+	}
+
+	if err := s.putBucketReplication(bucketName, bucketDetails.Replication); err != nil {
+		return err
+	}
+
+	if len(bucketDetails.Lifecycle) > 0 {
+		if err := s.putBucketLifecycle(bucketName, bucketDetails.Lifecycle); err != nil {
+			return err
+		}
+	} else if err := s.deleteBucketLifecycle(bucketName); err != nil {
+		return err
+	}
+
+	if len(bucketDetails.CORS) > 0 {
+		if err := s.putBucketCORS(bucketName, bucketDetails.CORS); err != nil {
+			return err
+		}
+	} else if err := s.deleteBucketCORS(bucketName); err != nil {
+		return err
+	}
+
+	if len(bucketDetails.Website) > 0 {
+		if err := s.putBucketWebsite(bucketName, bucketDetails.Website); err != nil {
+			return err
+		}
+	} else if err := s.deleteBucketWebsite(bucketName); err != nil {
+		return err
+	}
+
+	if len(bucketDetails.Logging) > 0 {
+		if err := s.putBucketLogging(bucketName, bucketDetails.Logging); err != nil {
+			return err
+		}
+	} else if err := s.disableBucketLogging(bucketName); err != nil {
+		return err
+	}
+
+	isPublic, err := s.isPublicPolicy(bucketDetails.Policy)
+	if err != nil {
+		return err
+	}
+
+	var policy string
+	if len(bucketDetails.Policy) > 0 {
+		policy, err = s.renderBucketPolicy(bucketDetails, bucketName)
+		if err != nil {
+			return err
+		}
+	}
+	// The statement that blocks uploads to a bucket over quota is not part of
+	// the plan, and stays until the quota task removes it.
+	quotaStatement, err := s.quotaStatement(bucketName)
+	if err != nil {
+		return err
+	}
+	if quotaStatement != nil {
+		policy, err = addPolicyStatement(policy, quotaStatement)
+		if err != nil {
+			return err
+		}
+	}
+
+	if isPublic {
+		if err := s.checkDeletePublicAccessBlock(bucketDetails, bucketName); err != nil {
+			return err
+		}
+		return s.putBucketPolicy(bucketName, policy)
+	}
+
+	// The public access block rejects public policies, so the policy has to be
+	// replaced or removed before the block is restored.
+	if len(policy) > 0 {
+		if err := s.putBucketPolicy(bucketName, policy); err != nil {
+			return err
+		}
+	} else if err := s.deleteBucketPolicy(bucketName); err != nil {
+		return err
+	}
+
+	return s.putPublicAccessBlock(bucketName)
+}
+
+// quotaStatement returns the statement of a bucket's policy that blocks
+// uploads because the bucket is over quota, or nil if it has none.
+func (s *S3Bucket) quotaStatement(bucketName string) (map[string]interface{}, error) {
+	getPolicyInput := &s3.GetBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+	}
+	s.logger.Debug("get-bucket-policy", lager.Data{"input": getPolicyInput})
+	getPolicyOutput, err := s.s3svc.GetBucketPolicy(getPolicyInput)
+	if err != nil {
+		if isAWSErrorCode(err, "NoSuchBucketPolicy") {
+			return nil, nil
+		}
+		s.logger.Error("aws-s3-error", err)
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(aws.StringValue(getPolicyOutput.Policy)), &document); err != nil {
+		return nil, err
+	}
+	for _, element := range policyStatements(document) {
+		if statement, ok := element.(map[string]interface{}); ok && statement["Sid"] == QuotaStatementSid {
+			return statement, nil
+		}
+	}
+	return nil, nil
+}
+
+// addPolicyStatement adds statement to a rendered policy, which may be empty.
+func addPolicyStatement(policy string, statement map[string]interface{}) (string, error) {
+	document := map[string]interface{}{"Version": "2012-10-17"}
+	if len(policy) > 0 {
+		if err := json.Unmarshal([]byte(policy), &document); err != nil {
+			return "", err
+		}
+	}
+	document["Statement"] = append(policyStatements(document), statement)
+
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// policyStatements returns the statements of a policy document, which may
+// hold a single statement or a list.
+func policyStatements(document map[string]interface{}) []interface{} {
+	switch statement := document["Statement"].(type) {
+	case []interface{}:
+		return statement
+	case map[string]interface{}:
+		return []interface{}{statement}
+	}
+	return nil
+}
+
+func (s *S3Bucket) putBucketTags(bucketName string, bucketTags map[string]string) error {
+	var tags []*s3.Tag
+	for key, value := range bucketTags {
+		tags = append(tags, &s3.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	if _, err := s.s3svc.PutBucketTagging(&s3.PutBucketTaggingInput{
+		Bucket: aws.String(bucketName),
+		Tagging: &s3.Tagging{
+			TagSet: tags,
+		},
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *S3Bucket) putBucketEncryption(bucketName, encryption string) error {
+	if len(encryption) == 0 {
+		return nil
+	}
+
+	var encryptionConfig s3.ServerSideEncryptionConfiguration
+	if err := json.Unmarshal([]byte(encryption), &encryptionConfig); err != nil {
+		return err
+	}
+	putEncryptionInput := &s3.PutBucketEncryptionInput{
+		Bucket:                            aws.String(bucketName),
+		ServerSideEncryptionConfiguration: &encryptionConfig,
+	}
+	s.logger.Debug("put-bucket-encryption", lager.Data{"input": putEncryptionInput})
+	putEncryptionOutput, err := s.s3svc.PutBucketEncryption(putEncryptionInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
+	}
+	s.logger.Debug("put-bucket-encryption", lager.Data{"output": putEncryptionOutput})
+	return nil
+}
+
+func (s *S3Bucket) putBucketVersioning(bucketName, versioning string) error {
+	if len(versioning) == 0 {
+		return nil
+	}
+
+	putVersioningInput := &s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String(versioning),
+		},
+	}
+	s.logger.Debug("put-bucket-versioning", lager.Data{"input": putVersioningInput})
+	putVersioningOutput, err := s.s3svc.PutBucketVersioning(putVersioningInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
+	}
+	s.logger.Debug("put-bucket-versioning", lager.Data{"output": putVersioningOutput})
+	return nil
+}
+
+func (s *S3Bucket) putBucketLifecycle(bucketName, lifecycle string) error {
+	if len(lifecycle) == 0 {
+		return nil
+	}
+
+	var lifecycleConfig s3.BucketLifecycleConfiguration
+	if err := json.Unmarshal([]byte(lifecycle), &lifecycleConfig); err != nil {
+		return err
+	}
+
+	if len(lifecycleConfig.Rules) == 0 {
+		return s.deleteBucketLifecycle(bucketName)
+	}
+
+	putLifecycleInput := &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucketName),
+		LifecycleConfiguration: &lifecycleConfig,
+	}
+	s.logger.Debug("put-bucket-lifecycle", lager.Data{"input": putLifecycleInput})
+	_, err := s.s3svc.PutBucketLifecycleConfiguration(putLifecycleInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3Bucket) putBucketCORS(bucketName, cors string) error {
+	if len(cors) == 0 {
+		return nil
+	}
+
+	var corsConfig s3.CORSConfiguration
+	if err := json.Unmarshal([]byte(cors), &corsConfig); err != nil {
+		return err
+	}
+
+	if len(corsConfig.CORSRules) == 0 {
+		return s.deleteBucketCORS(bucketName)
+	}
+
+	putCORSInput := &s3.PutBucketCorsInput{
+		Bucket:            aws.String(bucketName),
+		CORSConfiguration: &corsConfig,
+	}
+	s.logger.Debug("put-bucket-cors", lager.Data{"input": putCORSInput})
+	_, err := s.s3svc.PutBucketCors(putCORSInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3Bucket) putBucketWebsite(bucketName, website string) error {
+	if len(website) == 0 {
+		return nil
+	}
+
+	var websiteConfig s3.WebsiteConfiguration
+	if err := json.Unmarshal([]byte(website), &websiteConfig); err != nil {
+		return err
+	}
+	putWebsiteInput := &s3.PutBucketWebsiteInput{
+		Bucket:               aws.String(bucketName),
+		WebsiteConfiguration: &websiteConfig,
+	}
+	s.logger.Debug("put-bucket-website", lager.Data{"input": putWebsiteInput})
+	putWebsiteOutput, err := s.s3svc.PutBucketWebsite(putWebsiteInput)
 	if err != nil {
 		s.logger.Error("aws-s3-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
-			return BucketDetails{}, errors.New(awsErr.Code() + ": " + awsErr.Message())
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
 		}
-		return BucketDetails{}, err
+		return err
 	}
-	s.logger.Debug("get-bucket-location", lager.Data{"output": getLocationOutput})
+	s.logger.Debug("put-bucket-website", lager.Data{"output": putWebsiteOutput})
+	return nil
+}
 
-	region := getLocationOutput.LocationConstraint
-	if region == nil {
-		region = aws.String("us-east-1")
+func (s *S3Bucket) putObjectLock(bucketName, objectLock string) error {
+	if len(objectLock) == 0 {
+		return nil
 	}
 
-	return s.buildBucketDetails(bucketName, *region, partition, nil), nil
+	var objectLockConfig s3.ObjectLockConfiguration
+	if err := json.Unmarshal([]byte(objectLock), &objectLockConfig); err != nil {
+		return err
+	}
+	putObjectLockInput := &s3.PutObjectLockConfigurationInput{
+		Bucket:                  aws.String(bucketName),
+		ObjectLockConfiguration: &objectLockConfig,
+	}
+	s.logger.Debug("put-object-lock-configuration", lager.Data{"input": putObjectLockInput})
+	putObjectLockOutput, err := s.s3svc.PutObjectLockConfiguration(putObjectLockInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
+	}
+	s.logger.Debug("put-object-lock-configuration", lager.Data{"output": putObjectLockOutput})
+	return nil
 }
 
-// Create attempts to create an S3 bucket. If successful, it returns the bucket's location
-// and a nil error. If not, it returns an empty string and an error.
-func (s *S3Bucket) Create(bucketName string, bucketDetails BucketDetails) (string, error) {
-	createBucketInput := s.buildCreateBucketInput(bucketName, bucketDetails)
-	s.logger.Debug("create-bucket", lager.Data{"input": createBucketInput})
+func (s *S3Bucket) putBucketReplication(bucketName, replication string) error {
+	if len(replication) == 0 {
+		return nil
+	}
 
-	createBucketOutput, err := s.s3svc.CreateBucket(createBucketInput)
+	var replicationConfig s3.ReplicationConfiguration
+	if err := json.Unmarshal([]byte(replication), &replicationConfig); err != nil {
+		return err
+	}
+	putReplicationInput := &s3.PutBucketReplicationInput{
+		Bucket:                   aws.String(bucketName),
+		ReplicationConfiguration: &replicationConfig,
+	}
+	s.logger.Debug("put-bucket-replication", lager.Data{"input": putReplicationInput})
+	putReplicationOutput, err := s.s3svc.PutBucketReplication(putReplicationInput)
 	if err != nil {
 		s.logger.Error("aws-s3-error", err)
 		if awsErr, ok := err.(awserr.Error); ok {
-			return "", errors.New(awsErr.Code() + ": " + awsErr.Message())
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
 		}
-		return "", err
+		return err
 	}
-	s.logger.Debug("create-bucket", lager.Data{"output": createBucketOutput})
+	s.logger.Debug("put-bucket-replication", lager.Data{"output": putReplicationOutput})
+	return nil
+}
 
-	var tags []*s3.Tag
-	for key, value := range bucketDetails.Tags {
-		tags = append(tags, &s3.Tag{Key: aws.String(key), Value: aws.String(value)})
+func (s *S3Bucket) putBucketLogging(bucketName, logging string) error {
+	if len(logging) == 0 {
+		return nil
 	}
-	if _, err := s.s3svc.PutBucketTagging(&s3.PutBucketTaggingInput{
+
+	var loggingEnabled s3.LoggingEnabled
+	if err := json.Unmarshal([]byte(logging), &loggingEnabled); err != nil {
+		return err
+	}
+	putLoggingInput := &s3.PutBucketLoggingInput{
 		Bucket: aws.String(bucketName),
-		Tagging: &s3.Tagging{
-			TagSet: tags,
+		BucketLoggingStatus: &s3.BucketLoggingStatus{
+			LoggingEnabled: &loggingEnabled,
 		},
-	}); err != nil {
-		return "", err
 	}
-
-	if len(bucketDetails.Encryption) > 0 {
-		var encryptionConfig s3.ServerSideEncryptionConfiguration
-		if err := json.Unmarshal([]byte(bucketDetails.Encryption), &encryptionConfig); err != nil {
-			return "", err
-		}
-		putEncryptionInput := &s3.PutBucketEncryptionInput{
-			Bucket:                            aws.String(bucketName),
-			ServerSideEncryptionConfiguration: &encryptionConfig,
-		}
-		s.logger.Debug("put-bucket-encryption", lager.Data{"input": putEncryptionInput})
-		putEncryptionOutput, err := s.s3svc.PutBucketEncryption(putEncryptionInput)
-		if err != nil {
-			s.logger.Error("aws-s3-error", err)
-			if awsErr, ok := err.(awserr.Error); ok {
-				return "", errors.New(awsErr.Code() + ": " + awsErr.Message())
-			}
-			return "", err
+	s.logger.Debug("put-bucket-logging", lager.Data{"input": putLoggingInput})
+	putLoggingOutput, err := s.s3svc.PutBucketLogging(putLoggingInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
 		}
-		s.logger.Debug("put-bucket-encryption", lager.Data{"output": putEncryptionOutput})
+		return err
 	}
+	s.logger.Debug("put-bucket-logging", lager.Data{"output": putLoggingOutput})
+	return nil
+}
 
-	if err = s.checkDeletePublicAccessBlock(bucketDetails, bucketName); err != nil {
-		return "", err
+func (s *S3Bucket) deleteBucketLifecycle(bucketName string) error {
+	deleteLifecycleInput := &s3.DeleteBucketLifecycleInput{
+		Bucket: aws.String(bucketName),
 	}
-
-	if err = s.putBucketPolicyWithRetries(bucketDetails, bucketName); err != nil {
-		return "", err
+	s.logger.Debug("delete-bucket-lifecycle", lager.Data{"input": deleteLifecycleInput})
+	_, err := s.s3svc.DeleteBucketLifecycle(deleteLifecycleInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return err
 	}
-
-	return aws.StringValue(createBucketOutput.Location), nil
+	return nil
 }
 
-// checkDeletePublicAccessBlock checks the Policy of bucketDetails to see if the bucket
-// is intended to be public. If so, it deletes the Public Access Block that is set on all
-// new S3 buckets by default as of April 2023.
-func (s *S3Bucket) checkDeletePublicAccessBlock(bucketDetails BucketDetails, bucketName string) error {
-	// buckets with no policy are private by default.
-	if bucketDetails.Policy == "" {
-		return nil
+func (s *S3Bucket) deleteBucketCORS(bucketName string) error {
+	deleteCORSInput := &s3.DeleteBucketCorsInput{
+		Bucket: aws.String(bucketName),
 	}
-
-	var policy bucketPolicy
-	err := json.Unmarshal([]byte(bucketDetails.Policy), &policy)
+	s.logger.Debug("delete-bucket-cors", lager.Data{"input": deleteCORSInput})
+	_, err := s.s3svc.DeleteBucketCors(deleteCORSInput)
 	if err != nil {
 		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
 		return err
 	}
-	if len(policy.Statement) > 1 {
-		err = fmt.Errorf("expected 1 policy statement, got %v", len(policy.Statement))
+	return nil
+}
+
+func (s *S3Bucket) deleteBucketWebsite(bucketName string) error {
+	deleteWebsiteInput := &s3.DeleteBucketWebsiteInput{
+		Bucket: aws.String(bucketName),
+	}
+	s.logger.Debug("delete-bucket-website", lager.Data{"input": deleteWebsiteInput})
+	_, err := s.s3svc.DeleteBucketWebsite(deleteWebsiteInput)
+	if err != nil {
 		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
 		return err
 	}
+	return nil
+}
 
-	publicAccessPolicy := bucketPolicyStatement{
-		Effect:    "Allow",
-		Principal: "*",
-		Action:    []string{"s3:GetObject"},
+// disableBucketLogging turns off server access logging, which S3 does by
+// putting a logging status without a target.
+func (s *S3Bucket) disableBucketLogging(bucketName string) error {
+	putLoggingInput := &s3.PutBucketLoggingInput{
+		Bucket:              aws.String(bucketName),
+		BucketLoggingStatus: &s3.BucketLoggingStatus{},
 	}
-	if slices.ContainsFunc(policy.Statement, func(statement bucketPolicyStatement) bool {
-		return statement.Effect == publicAccessPolicy.Effect &&
-			statement.Principal == publicAccessPolicy.Principal &&
-			slices.Equal(statement.Action, publicAccessPolicy.Action)
-	}) {
-		deletePublicAccessBlockInput := &s3.DeletePublicAccessBlockInput{
-			Bucket: aws.String(bucketName),
-		}
-		s.logger.Debug("delete-public-access-block", lager.Data{"input": deletePublicAccessBlockInput})
-		_, err := s.s3svc.DeletePublicAccessBlock(deletePublicAccessBlockInput)
-		if err != nil {
-			s.logger.Error("failed to delete public access block", err)
-			return err
-		}
-
-		isDeleted, err := s.checkIsPublicAccessBlockDeleted(bucketName)
-		if err != nil {
-			s.logger.Error("failed to get public access block", err)
-			return err
-		}
-		retries := 0
-		maxRetries := 10
-		for !isDeleted && retries < maxRetries {
-			retries += 1
-
-			time.Sleep(1 * time.Second)
-
-			isDeleted, err = s.checkIsPublicAccessBlockDeleted(bucketName)
-
-			if err != nil {
-				s.logger.Error("failed to get public access block", err)
-				return err
-			}
-		}
-		if retries == maxRetries {
-			s.logger.Info(fmt.Sprintf("could not verify that public access block was deleted for bucket %s, gave up after %d retries", bucketName, retries))
+	s.logger.Debug("disable-bucket-logging", lager.Data{"input": putLoggingInput})
+	_, err := s.s3svc.PutBucketLogging(putLoggingInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return errors.New(awsErr.Code() + ": " + awsErr.Message())
 		}
+		return err
 	}
-
 	return nil
 }
 
-func (s *S3Bucket) checkIsPublicAccessBlockDeleted(bucketName string) (bool, error) {
-	getPublicAccessBlockInput := &s3.GetPublicAccessBlockInput{
+func (s *S3Bucket) deleteBucketPolicy(bucketName string) error {
+	deletePolicyInput := &s3.DeleteBucketPolicyInput{
 		Bucket: aws.String(bucketName),
 	}
-	_, err := s.s3svc.GetPublicAccessBlock(getPublicAccessBlockInput)
-	if awsErr, ok := err.(awserr.Error); ok {
-		if awsErr.Code() == "NoSuchPublicAccessBlockConfiguration" {
-			return true, nil
+	s.logger.Debug("delete-bucket-policy", lager.Data{"input": deletePolicyInput})
+	deletePolicyOutput, err := s.s3svc.DeleteBucketPolicy(deletePolicyInput)
+	if err != nil {
+		if isAWSErrorCode(err, "NoSuchBucketPolicy") {
+			return nil
 		}
-		return false, err
+		s.logger.Error("aws-s3-error", err)
+		return err
 	}
-	return false, nil
+	s.logger.Debug("delete-bucket-policy", lager.Data{"output": deletePolicyOutput})
+	return nil
 }
 
-func (s *S3Bucket) Modify(bucketName string, bucketDetails BucketDetails) error {
-	// TODO Implement modifx
+// putPublicAccessBlock restores the Public Access Block that AWS sets on all new
+// S3 buckets, blocking every form of public access.
+func (s *S3Bucket) putPublicAccessBlock(bucketName string) error {
+	putPublicAccessBlockInput := &s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(bucketName),
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	}
+	s.logger.Debug("put-public-access-block", lager.Data{"input": putPublicAccessBlockInput})
+	putPublicAccessBlockOutput, err := s.s3svc.PutPublicAccessBlock(putPublicAccessBlockInput)
+	if err != nil {
+		s.logger.Error("failed to put public access block", err)
+		return err
+	}
+	s.logger.Debug("put-public-access-block", lager.Data{"output": putPublicAccessBlockOutput})
 	return nil
 }
 
@@ -231,8 +1006,11 @@ func (s *S3Bucket) Delete(bucketName string, deleteObjects bool) error {
 		Bucket: aws.String(bucketName),
 	}
 	s.logger.Debug("delete-bucket", lager.Data{"input": deleteBucketInput})
+	if err := s.checkNoLockedObjects(bucketName); err != nil {
+		return err
+	}
 	if deleteObjects {
-		contentDeleteErr := s.deleteBucketContents(bucketName)
+		contentDeleteErr := s.deleteBucketContents(bucketName, nil)
 		if contentDeleteErr != nil {
 			return contentDeleteErr
 		}
@@ -249,10 +1027,196 @@ func (s *S3Bucket) Delete(bucketName string, deleteObjects bool) error {
 	return nil
 }
 
-func (s *S3Bucket) deleteBucketContents(bucketName string) error {
-	iter := s3manager.NewDeleteListIterator(s.s3svc.(*s3.S3), &s3.ListObjectsInput{
+// List returns the names of the account's buckets that start with prefix.
+func (s *S3Bucket) List(prefix string) ([]string, error) {
+	listBucketsInput := &s3.ListBucketsInput{}
+	s.logger.Debug("list-buckets", lager.Data{"input": listBucketsInput})
+
+	listBucketsOutput, err := s.s3svc.ListBuckets(listBucketsInput)
+	if err != nil {
+		s.logger.Error("aws-s3-error", err)
+		if awsErr, ok := err.(awserr.Error); ok {
+			return nil, errors.New(awsErr.Code() + ": " + awsErr.Message())
+		}
+		return nil, err
+	}
+
+	var bucketNames []string
+	for _, bucket := range listBucketsOutput.Buckets {
+		if name := aws.StringValue(bucket.Name); strings.HasPrefix(name, prefix) {
+			bucketNames = append(bucketNames, name)
+		}
+	}
+	return bucketNames, nil
+}
+
+// Empty deletes every object in the bucket without deleting the bucket itself.
+// If progress is not nil, it is called with the running count of deleted
+// objects so that long-running deletes can report how far they have got.
+func (s *S3Bucket) Empty(bucketName string, progress func(deleted int)) error {
+	s.logger.Debug("empty-bucket", lager.Data{"bucket": bucketName})
+	if err := s.checkNoLockedObjects(bucketName); err != nil {
+		return err
+	}
+	return s.deleteBucketContents(bucketName, progress)
+}
+
+// checkNoLockedObjects returns ErrBucketHasLockedObjects if any object version
+// in the bucket is under an unexpired retention period or a legal hold. S3
+// refuses to delete such versions, and with them the bucket, so callers check
+// first to fail with a clear error rather than part way through.
+func (s *S3Bucket) checkNoLockedObjects(bucketName string) error {
+	objectLockOutput, err := s.s3svc.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		if isNoSuchBucketError(err) || isAWSErrorCode(err, "ObjectLockConfigurationNotFoundError") {
+			return nil
+		}
+		s.logger.Error("aws-s3-error", err)
+		return err
+	}
+	if objectLockOutput.ObjectLockConfiguration == nil ||
+		aws.StringValue(objectLockOutput.ObjectLockConfiguration.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+		return nil
+	}
+
+	now := time.Now()
+	listVersionsInput := &s3.ListObjectVersionsInput{
 		Bucket: aws.String(bucketName),
+	}
+	for {
+		listVersionsOutput, err := s.s3svc.ListObjectVersions(listVersionsInput)
+		if err != nil {
+			s.logger.Error("aws-s3-error", err)
+			return err
+		}
+		for _, version := range listVersionsOutput.Versions {
+			locked, err := s.isLocked(bucketName, version, now)
+			if err != nil {
+				return err
+			}
+			if locked {
+				s.logger.Info("locked-object", lager.Data{
+					"bucket":    bucketName,
+					"key":       aws.StringValue(version.Key),
+					"versionId": aws.StringValue(version.VersionId),
+				})
+				return ErrBucketHasLockedObjects
+			}
+		}
+		if !aws.BoolValue(listVersionsOutput.IsTruncated) {
+			return nil
+		}
+		listVersionsInput.KeyMarker = listVersionsOutput.NextKeyMarker
+		listVersionsInput.VersionIdMarker = listVersionsOutput.NextVersionIdMarker
+	}
+}
+
+func (s *S3Bucket) isLocked(bucketName string, version *s3.ObjectVersion, now time.Time) (bool, error) {
+	retentionOutput, err := s.s3svc.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket:    aws.String(bucketName),
+		Key:       version.Key,
+		VersionId: version.VersionId,
+	})
+	if err != nil && !isAWSErrorCode(err, "NoSuchObjectLockConfiguration") {
+		s.logger.Error("aws-s3-error", err)
+		return false, err
+	}
+	if err == nil && retentionOutput.Retention != nil &&
+		aws.TimeValue(retentionOutput.Retention.RetainUntilDate).After(now) {
+		return true, nil
+	}
+
+	legalHoldOutput, err := s.s3svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket:    aws.String(bucketName),
+		Key:       version.Key,
+		VersionId: version.VersionId,
 	})
+	if err != nil && !isAWSErrorCode(err, "NoSuchObjectLockConfiguration") {
+		s.logger.Error("aws-s3-error", err)
+		return false, err
+	}
+	return err == nil && legalHoldOutput.LegalHold != nil &&
+		aws.StringValue(legalHoldOutput.LegalHold.Status) == s3.ObjectLockLegalHoldStatusOn, nil
+}
+
+// countingDeleteIterator wraps a BatchDeleteIterator and reports each object
+// handed to the batch deleter.
+type countingDeleteIterator struct {
+	s3manager.BatchDeleteIterator
+	deleted  int
+	progress func(deleted int)
+}
+
+func (i *countingDeleteIterator) DeleteObject() s3manager.BatchDeleteObject {
+	i.deleted++
+	if i.progress != nil {
+		i.progress(i.deleted)
+	}
+	return i.BatchDeleteIterator.DeleteObject()
+}
+
+// versionDeleteIterator iterates over every version and delete marker in a
+// bucket, so that versioned buckets can be emptied. Objects in unversioned
+// buckets are listed with the version ID "null".
+type versionDeleteIterator struct {
+	s3svc      S3Client
+	bucketName string
+
+	input   *s3.ListObjectVersionsInput
+	pending []*s3.ObjectIdentifier
+	done    bool
+	err     error
+}
+
+func (i *versionDeleteIterator) Next() bool {
+	if len(i.pending) > 1 {
+		i.pending = i.pending[1:]
+		return true
+	}
+	i.pending = nil
+	for len(i.pending) == 0 && !i.done {
+		if i.input == nil {
+			i.input = &s3.ListObjectVersionsInput{Bucket: aws.String(i.bucketName)}
+		}
+		output, err := i.s3svc.ListObjectVersions(i.input)
+		if err != nil {
+			i.err = err
+			return false
+		}
+		for _, version := range output.Versions {
+			i.pending = append(i.pending, &s3.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, marker := range output.DeleteMarkers {
+			i.pending = append(i.pending, &s3.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+		}
+		i.done = !aws.BoolValue(output.IsTruncated)
+		i.input.KeyMarker = output.NextKeyMarker
+		i.input.VersionIdMarker = output.NextVersionIdMarker
+	}
+	return len(i.pending) > 0
+}
+
+func (i *versionDeleteIterator) Err() error {
+	return i.err
+}
+
+func (i *versionDeleteIterator) DeleteObject() s3manager.BatchDeleteObject {
+	return s3manager.BatchDeleteObject{
+		Object: &s3.DeleteObjectInput{
+			Bucket:    aws.String(i.bucketName),
+			Key:       i.pending[0].Key,
+			VersionId: i.pending[0].VersionId,
+		},
+	}
+}
+
+func (s *S3Bucket) deleteBucketContents(bucketName string, progress func(deleted int)) error {
+	iter := &countingDeleteIterator{
+		BatchDeleteIterator: &versionDeleteIterator{s3svc: s.s3svc, bucketName: bucketName},
+		progress:            progress,
+	}
 
 	if err := s3manager.NewBatchDeleteWithClient(s.s3svc.(*s3.S3)).Delete(aws.BackgroundContext(), iter); err != nil {
 		s.logger.Error("aws-s3-delete-bucket-contents-error", err)
@@ -277,6 +1241,9 @@ func (s *S3Bucket) buildCreateBucketInput(bucketName string, bucketDetails Bucke
 		Bucket:          aws.String(bucketName),
 		ObjectOwnership: aws.String(bucketDetails.ObjectOwnership),
 	}
+	if len(bucketDetails.ObjectLock) > 0 {
+		createBucketInput.ObjectLockEnabledForBucket = aws.Bool(true)
+	}
 	return createBucketInput
 }
 
@@ -288,23 +1255,38 @@ func (s *S3Bucket) putBucketPolicyWithRetries(
 		return nil
 	}
 
+	policy, err := s.renderBucketPolicy(bucketDetails, bucketName)
+	if err != nil {
+		return err
+	}
+	return s.putBucketPolicy(bucketName, policy)
+}
+
+// renderBucketPolicy renders the bucket policy template in bucketDetails for
+// bucketName.
+func (s *S3Bucket) renderBucketPolicy(bucketDetails BucketDetails, bucketName string) (string, error) {
 	bucketDetails.BucketName = bucketName
 	tmpl, err := template.New("policy").Parse(bucketDetails.Policy)
 	if err != nil {
 		s.logger.Error("aws-s3-error", err)
-		return err
+		return "", err
 	}
 
 	policy := bytes.Buffer{}
 	err = tmpl.Execute(&policy, bucketDetails)
 	if err != nil {
 		s.logger.Error("aws-s3-error", err)
-		return err
+		return "", err
 	}
+	return policy.String(), nil
+}
 
+// putBucketPolicy puts a rendered policy on a bucket, retrying while AWS
+// denies access, as it does until a deleted public access block takes effect.
+func (s *S3Bucket) putBucketPolicy(bucketName, policy string) error {
 	putPolicyInput := &s3.PutBucketPolicyInput{
-		Bucket: aws.String(bucketDetails.BucketName),
-		Policy: aws.String(policy.String()),
+		Bucket: aws.String(bucketName),
+		Policy: aws.String(policy),
 	}
 	s.logger.Debug("put-bucket-policy", lager.Data{"input": putPolicyInput})
 
@@ -360,6 +1342,13 @@ func isNoSuchBucketError(err error) bool {
 	return false
 }
 
+func isAWSErrorCode(err error, code string) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == code
+	}
+	return false
+}
+
 func isAccessDeniedException(err error) bool {
 	if awsErr, ok := err.(awserr.Error); ok {
 		return awsErr.Code() == "AccessDenied"
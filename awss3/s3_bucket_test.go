@@ -1,15 +1,18 @@
 package awss3
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/go-cmp/cmp"
 )
 
 type MockS3Client struct {
@@ -17,23 +20,251 @@ type MockS3Client struct {
 	numPutBucketPolicyCalls          int
 	numPutBucketPolicyCallsShouldErr int
 	putBucketPolicyErr               error
+	putPolicy                        *string
+
+	deleteBucketPolicyCalled   bool
+	getBucketTaggingErr        error
+	getBucketTaggingTags       []*s3.Tag
+	putBucketTaggingTags       []*s3.Tag
+	putPublicAccessBlockCalled bool
+
+	getBucketLocationErr error
+	getBucketLocation    *string
+	objectOwnership      string
+	encryption           *s3.ServerSideEncryptionConfiguration
+	policy               *string
+	policyIsPublic       bool
+
+	versioning    *string
+	putVersioning *string
+
+	lifecycleRules        []*s3.LifecycleRule
+	putLifecycleRules     []*s3.LifecycleRule
+	deleteLifecycleCalled bool
+
+	corsRules        []*s3.CORSRule
+	putCORSRules     []*s3.CORSRule
+	deleteCORSCalled bool
+
+	website             *s3.GetBucketWebsiteOutput
+	putWebsite          *s3.WebsiteConfiguration
+	deleteWebsiteCalled bool
+
+	createBucketErr         error
+	createObjectLockEnabled bool
+	objectLock              *s3.ObjectLockConfiguration
+	putObjectLock           *s3.ObjectLockConfiguration
+	objectVersions          []*s3.ObjectVersion
+	retainUntil             map[string]time.Time
+	legalHolds              map[string]bool
+	deleteMarkers           []*s3.DeleteMarkerEntry
+
+	replication    *s3.ReplicationConfiguration
+	putReplication *s3.ReplicationConfiguration
+
+	logging         *s3.LoggingEnabled
+	putLogging      *s3.LoggingEnabled
+	loggingDisabled bool
+
+	buckets []string
+}
+
+func (c *MockS3Client) ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	output := &s3.ListBucketsOutput{}
+	for _, name := range c.buckets {
+		output.Buckets = append(output.Buckets, &s3.Bucket{Name: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (c *MockS3Client) GetBucketLogging(input *s3.GetBucketLoggingInput) (*s3.GetBucketLoggingOutput, error) {
+	return &s3.GetBucketLoggingOutput{LoggingEnabled: c.logging}, nil
+}
+
+func (c *MockS3Client) PutBucketLogging(input *s3.PutBucketLoggingInput) (*s3.PutBucketLoggingOutput, error) {
+	c.putLogging = input.BucketLoggingStatus.LoggingEnabled
+	c.loggingDisabled = input.BucketLoggingStatus.LoggingEnabled == nil
+	return &s3.PutBucketLoggingOutput{}, nil
+}
+
+func (c *MockS3Client) GetBucketReplication(input *s3.GetBucketReplicationInput) (*s3.GetBucketReplicationOutput, error) {
+	if c.replication == nil {
+		return nil, awserr.New("ReplicationConfigurationNotFoundError", "not found", errors.New("fail"))
+	}
+	return &s3.GetBucketReplicationOutput{ReplicationConfiguration: c.replication}, nil
+}
+
+func (c *MockS3Client) PutBucketReplication(input *s3.PutBucketReplicationInput) (*s3.PutBucketReplicationOutput, error) {
+	c.putReplication = input.ReplicationConfiguration
+	return &s3.PutBucketReplicationOutput{}, nil
+}
+
+func (c *MockS3Client) GetObjectLockConfiguration(input *s3.GetObjectLockConfigurationInput) (*s3.GetObjectLockConfigurationOutput, error) {
+	if c.objectLock == nil {
+		return nil, awserr.New("ObjectLockConfigurationNotFoundError", "not found", errors.New("fail"))
+	}
+	return &s3.GetObjectLockConfigurationOutput{ObjectLockConfiguration: c.objectLock}, nil
+}
+
+func (c *MockS3Client) PutObjectLockConfiguration(input *s3.PutObjectLockConfigurationInput) (*s3.PutObjectLockConfigurationOutput, error) {
+	c.putObjectLock = input.ObjectLockConfiguration
+	return &s3.PutObjectLockConfigurationOutput{}, nil
+}
+
+func (c *MockS3Client) ListObjectVersions(input *s3.ListObjectVersionsInput) (*s3.ListObjectVersionsOutput, error) {
+	return &s3.ListObjectVersionsOutput{Versions: c.objectVersions, DeleteMarkers: c.deleteMarkers}, nil
+}
+
+func (c *MockS3Client) GetObjectRetention(input *s3.GetObjectRetentionInput) (*s3.GetObjectRetentionOutput, error) {
+	retainUntil, ok := c.retainUntil[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New("NoSuchObjectLockConfiguration", "not found", errors.New("fail"))
+	}
+	return &s3.GetObjectRetentionOutput{
+		Retention: &s3.ObjectLockRetention{RetainUntilDate: aws.Time(retainUntil)},
+	}, nil
+}
+
+func (c *MockS3Client) GetObjectLegalHold(input *s3.GetObjectLegalHoldInput) (*s3.GetObjectLegalHoldOutput, error) {
+	if !c.legalHolds[aws.StringValue(input.Key)] {
+		return nil, awserr.New("NoSuchObjectLockConfiguration", "not found", errors.New("fail"))
+	}
+	return &s3.GetObjectLegalHoldOutput{
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(s3.ObjectLockLegalHoldStatusOn)},
+	}, nil
+}
+
+func (c *MockS3Client) GetBucketWebsite(input *s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error) {
+	if c.website == nil {
+		return nil, awserr.New("NoSuchWebsiteConfiguration", "not found", errors.New("fail"))
+	}
+	return c.website, nil
+}
+
+func (c *MockS3Client) PutBucketWebsite(input *s3.PutBucketWebsiteInput) (*s3.PutBucketWebsiteOutput, error) {
+	c.putWebsite = input.WebsiteConfiguration
+	return &s3.PutBucketWebsiteOutput{}, nil
+}
+
+func (c *MockS3Client) DeleteBucketWebsite(input *s3.DeleteBucketWebsiteInput) (*s3.DeleteBucketWebsiteOutput, error) {
+	c.deleteWebsiteCalled = true
+	return &s3.DeleteBucketWebsiteOutput{}, nil
+}
+
+func (c *MockS3Client) GetBucketCors(input *s3.GetBucketCorsInput) (*s3.GetBucketCorsOutput, error) {
+	if c.corsRules == nil {
+		return nil, awserr.New("NoSuchCORSConfiguration", "not found", errors.New("fail"))
+	}
+	return &s3.GetBucketCorsOutput{CORSRules: c.corsRules}, nil
+}
+
+func (c *MockS3Client) PutBucketCors(input *s3.PutBucketCorsInput) (*s3.PutBucketCorsOutput, error) {
+	c.putCORSRules = input.CORSConfiguration.CORSRules
+	return &s3.PutBucketCorsOutput{}, nil
+}
+
+func (c *MockS3Client) DeleteBucketCors(input *s3.DeleteBucketCorsInput) (*s3.DeleteBucketCorsOutput, error) {
+	c.deleteCORSCalled = true
+	return &s3.DeleteBucketCorsOutput{}, nil
+}
+
+func (c *MockS3Client) GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	if c.lifecycleRules == nil {
+		return nil, awserr.New("NoSuchLifecycleConfiguration", "not found", errors.New("fail"))
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: c.lifecycleRules}, nil
+}
+
+func (c *MockS3Client) PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	c.putLifecycleRules = input.LifecycleConfiguration.Rules
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func (c *MockS3Client) DeleteBucketLifecycle(input *s3.DeleteBucketLifecycleInput) (*s3.DeleteBucketLifecycleOutput, error) {
+	c.deleteLifecycleCalled = true
+	return &s3.DeleteBucketLifecycleOutput{}, nil
+}
+
+func (c *MockS3Client) GetBucketVersioning(input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error) {
+	return &s3.GetBucketVersioningOutput{Status: c.versioning}, nil
+}
+
+func (c *MockS3Client) PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+	c.putVersioning = input.VersioningConfiguration.Status
+	return &s3.PutBucketVersioningOutput{}, nil
 }
 
 func (c *MockS3Client) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
-	return nil, nil
+	if c.getBucketLocationErr != nil {
+		return nil, c.getBucketLocationErr
+	}
+	return &s3.GetBucketLocationOutput{LocationConstraint: c.getBucketLocation}, nil
+}
+
+func (c *MockS3Client) GetBucketOwnershipControls(input *s3.GetBucketOwnershipControlsInput) (*s3.GetBucketOwnershipControlsOutput, error) {
+	if c.objectOwnership == "" {
+		return nil, awserr.New("OwnershipControlsNotFoundError", "not found", errors.New("fail"))
+	}
+	return &s3.GetBucketOwnershipControlsOutput{
+		OwnershipControls: &s3.OwnershipControls{
+			Rules: []*s3.OwnershipControlsRule{{ObjectOwnership: aws.String(c.objectOwnership)}},
+		},
+	}, nil
+}
+
+func (c *MockS3Client) GetBucketEncryption(input *s3.GetBucketEncryptionInput) (*s3.GetBucketEncryptionOutput, error) {
+	if c.encryption == nil {
+		return nil, awserr.New("ServerSideEncryptionConfigurationNotFoundError", "not found", errors.New("fail"))
+	}
+	return &s3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: c.encryption}, nil
+}
+
+func (c *MockS3Client) GetBucketPolicy(input *s3.GetBucketPolicyInput) (*s3.GetBucketPolicyOutput, error) {
+	if c.policy == nil {
+		return nil, awserr.New("NoSuchBucketPolicy", "not found", errors.New("fail"))
+	}
+	return &s3.GetBucketPolicyOutput{Policy: c.policy}, nil
+}
+
+func (c *MockS3Client) GetBucketPolicyStatus(input *s3.GetBucketPolicyStatusInput) (*s3.GetBucketPolicyStatusOutput, error) {
+	return &s3.GetBucketPolicyStatusOutput{
+		PolicyStatus: &s3.PolicyStatus{IsPublic: aws.Bool(c.policyIsPublic)},
+	}, nil
 }
 
 func (c *MockS3Client) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
 	location := fmt.Sprint("/", *input.Bucket)
+	if c.createBucketErr != nil {
+		return nil, c.createBucketErr
+	}
+	c.createObjectLockEnabled = aws.BoolValue(input.ObjectLockEnabledForBucket)
 	return &s3.CreateBucketOutput{
 		Location: &location,
 	}, nil
 }
 
 func (c *MockS3Client) PutBucketTagging(input *s3.PutBucketTaggingInput) (*s3.PutBucketTaggingOutput, error) {
+	c.putBucketTaggingTags = input.Tagging.TagSet
 	return &s3.PutBucketTaggingOutput{}, nil
 }
 
+func (c *MockS3Client) GetBucketTagging(input *s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error) {
+	if c.getBucketTaggingErr != nil {
+		return nil, c.getBucketTaggingErr
+	}
+	return &s3.GetBucketTaggingOutput{TagSet: c.getBucketTaggingTags}, nil
+}
+
+func (c *MockS3Client) DeleteBucketPolicy(input *s3.DeleteBucketPolicyInput) (*s3.DeleteBucketPolicyOutput, error) {
+	c.deleteBucketPolicyCalled = true
+	return &s3.DeleteBucketPolicyOutput{}, nil
+}
+
+func (c *MockS3Client) PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error) {
+	c.putPublicAccessBlockCalled = true
+	return &s3.PutPublicAccessBlockOutput{}, nil
+}
+
 func (c *MockS3Client) PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
 	return &s3.PutBucketEncryptionOutput{}, nil
 }
@@ -43,6 +274,7 @@ func (c *MockS3Client) PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutB
 	if c.numPutBucketPolicyCalls <= c.numPutBucketPolicyCallsShouldErr {
 		return nil, c.putBucketPolicyErr
 	}
+	c.putPolicy = input.Policy
 	return &s3.PutBucketPolicyOutput{}, nil
 }
 
@@ -80,6 +312,11 @@ func TestCreate(t *testing.T) {
 		Location                            string
 		Error                               error
 		expectDeletePublicAccessBlockCalled bool
+		expectWebsite                       *s3.WebsiteConfiguration
+		expectObjectLock                    *s3.ObjectLockConfiguration
+		expectReplication                   *s3.ReplicationConfiguration
+		expectLogging                       *s3.LoggingEnabled
+		createBucketErr                     error
 	}{
 		{
 			Name:       "basic bucket",
@@ -100,11 +337,77 @@ func TestCreate(t *testing.T) {
 			Error:                               nil,
 			expectDeletePublicAccessBlockCalled: true,
 		},
+		{
+			Name:       "website bucket",
+			BucketName: "b",
+			BucketDetails: BucketDetails{
+				Policy:  publicPolicy,
+				Website: `{"IndexDocument":{"Suffix":"index.html"},"ErrorDocument":{"Key":"404.html"}}`,
+			},
+			Location:                            "/b",
+			Error:                               nil,
+			expectDeletePublicAccessBlockCalled: true,
+			expectWebsite: &s3.WebsiteConfiguration{
+				IndexDocument: &s3.IndexDocument{Suffix: aws.String("index.html")},
+				ErrorDocument: &s3.ErrorDocument{Key: aws.String("404.html")},
+			},
+		},
+		{
+			Name:       "object lock bucket",
+			BucketName: "b",
+			BucketDetails: BucketDetails{
+				ObjectLock: `{"ObjectLockEnabled":"Enabled","Rule":{"DefaultRetention":{"Mode":"COMPLIANCE","Years":7}}}`,
+			},
+			Location: "/b",
+			Error:    nil,
+			expectObjectLock: &s3.ObjectLockConfiguration{
+				ObjectLockEnabled: aws.String("Enabled"),
+				Rule: &s3.ObjectLockRule{
+					DefaultRetention: &s3.DefaultRetention{Mode: aws.String("COMPLIANCE"), Years: aws.Int64(7)},
+				},
+			},
+		},
+		{
+			Name:       "replicated bucket",
+			BucketName: "b",
+			BucketDetails: BucketDetails{
+				Versioning:  "Enabled",
+				Replication: `{"Role":"arn:aws:iam::123456789012:role/replication","Rules":[{"Destination":{"Bucket":"arn:aws:s3:::b-replica"},"Status":"Enabled"}]}`,
+			},
+			Location: "/b",
+			Error:    nil,
+			expectReplication: &s3.ReplicationConfiguration{
+				Role: aws.String("arn:aws:iam::123456789012:role/replication"),
+				Rules: []*s3.ReplicationRule{{
+					Destination: &s3.Destination{Bucket: aws.String("arn:aws:s3:::b-replica")},
+					Status:      aws.String("Enabled"),
+				}},
+			},
+		},
+		{
+			Name:       "logged bucket",
+			BucketName: "b",
+			BucketDetails: BucketDetails{
+				Logging: `{"TargetBucket":"audit-logs","TargetPrefix":"org/space/instance/"}`,
+			},
+			Location: "/b",
+			Error:    nil,
+			expectLogging: &s3.LoggingEnabled{
+				TargetBucket: aws.String("audit-logs"),
+				TargetPrefix: aws.String("org/space/instance/"),
+			},
+		},
+		{
+			Name:            "bucket already owned",
+			BucketName:      "b",
+			createBucketErr: awserr.New(s3.ErrCodeBucketAlreadyOwnedByYou, "already owned", errors.New("fail")),
+			Error:           ErrBucketAlreadyOwned,
+		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.Name, func(t *testing.T) {
-			mocks3Client := &MockS3Client{}
+			mocks3Client := &MockS3Client{createBucketErr: tc.createBucketErr}
 			b := NewS3Bucket(mocks3Client, lager.NewLogger("test"))
 			location, err := b.Create(tc.BucketName, tc.BucketDetails)
 			if location != tc.Location {
@@ -116,10 +419,434 @@ func TestCreate(t *testing.T) {
 			if tc.expectDeletePublicAccessBlockCalled != mocks3Client.deletePublicAccessBlockCalled {
 				t.Errorf("expected public access called: %v, got: %v", tc.expectDeletePublicAccessBlockCalled, mocks3Client.deletePublicAccessBlockCalled)
 			}
+			if !cmp.Equal(tc.expectWebsite, mocks3Client.putWebsite) {
+				t.Error(cmp.Diff(mocks3Client.putWebsite, tc.expectWebsite))
+			}
+			if (tc.expectObjectLock != nil) != mocks3Client.createObjectLockEnabled {
+				t.Errorf("expected object lock enabled: %v, got: %v", tc.expectObjectLock != nil, mocks3Client.createObjectLockEnabled)
+			}
+			if !cmp.Equal(tc.expectObjectLock, mocks3Client.putObjectLock) {
+				t.Error(cmp.Diff(mocks3Client.putObjectLock, tc.expectObjectLock))
+			}
+			if !cmp.Equal(tc.expectReplication, mocks3Client.putReplication) {
+				t.Error(cmp.Diff(mocks3Client.putReplication, tc.expectReplication))
+			}
+			if !cmp.Equal(tc.expectLogging, mocks3Client.putLogging) {
+				t.Error(cmp.Diff(mocks3Client.putLogging, tc.expectLogging))
+			}
+			configured := false
+			for _, tag := range mocks3Client.putBucketTaggingTags {
+				if aws.StringValue(tag.Key) == ConfiguredTagKey {
+					configured = true
+				}
+			}
+			if configured != (tc.Error == nil) {
+				t.Errorf("expected bucket tagged as configured: %v, got: %v", tc.Error == nil, configured)
+			}
 		})
 	}
 }
 
+var quotaStatement = `{"Sid":"QuotaExceeded","Effect":"Deny","Principal":"*","Action":"s3:PutObject","Resource":"arn:aws:s3:::b/*"}`
+
+var secureTransportPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Deny",
+      "Principal": "*",
+      "Action": ["s3:*"],
+      "Resource": ["arn:{{.AwsPartition}}:s3:::{{.BucketName}}/*"],
+      "Condition": {"Bool": {"aws:SecureTransport": "false"}}
+    }
+  ]
+}`
+
+func TestModify(t *testing.T) {
+	cases := map[string]struct {
+		bucketDetails                       BucketDetails
+		s3Client                            *MockS3Client
+		expectErr                           error
+		expectTags                          map[string]string
+		expectDeletePublicAccessBlockCalled bool
+		expectPutPublicAccessBlockCalled    bool
+		expectDeleteBucketPolicyCalled      bool
+		expectNumPutBucketPolicyCalls       int
+		expectVersioning                    *string
+		expectLifecycleRules                []*s3.LifecycleRule
+		expectDeleteLifecycleCalled         bool
+		expectCORSRules                     []*s3.CORSRule
+		expectDeleteCORSCalled              bool
+		expectDeleteWebsiteCalled           bool
+		expectLogging                       *s3.LoggingEnabled
+		expectLoggingDisabled               bool
+		expectPolicy                        string
+	}{
+		"bucket does not exist": {
+			s3Client: &MockS3Client{
+				getBucketTaggingErr: awserr.New("NoSuchBucket", "no such bucket", errors.New("original error")),
+			},
+			expectErr: ErrBucketDoesNotExist,
+		},
+		"private bucket": {
+			bucketDetails: BucketDetails{
+				Tags: map[string]string{"Updated at": "now"},
+			},
+			s3Client: &MockS3Client{
+				getBucketTaggingTags: []*s3.Tag{
+					{Key: aws.String("Created at"), Value: aws.String("then")},
+				},
+			},
+			expectTags: map[string]string{
+				"Created at": "then",
+				"Updated at": "now",
+			},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectDeleteLifecycleCalled:      true,
+			expectDeleteCORSCalled:           true,
+			expectDeleteWebsiteCalled:        true,
+			expectLoggingDisabled:            true,
+		},
+		"bucket without tags": {
+			bucketDetails: BucketDetails{
+				Tags: map[string]string{"Updated at": "now"},
+			},
+			s3Client: &MockS3Client{
+				getBucketTaggingErr: awserr.New("NoSuchTagSet", "no tags", errors.New("original error")),
+			},
+			expectTags: map[string]string{
+				"Updated at": "now",
+			},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectDeleteLifecycleCalled:      true,
+			expectDeleteCORSCalled:           true,
+			expectDeleteWebsiteCalled:        true,
+			expectLoggingDisabled:            true,
+		},
+		"public bucket": {
+			bucketDetails: BucketDetails{
+				Policy: publicPolicy,
+			},
+			s3Client:                            &MockS3Client{},
+			expectTags:                          map[string]string{},
+			expectDeletePublicAccessBlockCalled: true,
+			expectNumPutBucketPolicyCalls:       1,
+			expectDeleteLifecycleCalled:         true,
+			expectDeleteCORSCalled:              true,
+			expectDeleteWebsiteCalled:           true,
+			expectLoggingDisabled:               true,
+		},
+		"versioned bucket": {
+			bucketDetails: BucketDetails{
+				Versioning: s3.BucketVersioningStatusEnabled,
+			},
+			s3Client:                         &MockS3Client{},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectVersioning:                 aws.String(s3.BucketVersioningStatusEnabled),
+			expectDeleteLifecycleCalled:      true,
+			expectDeleteCORSCalled:           true,
+			expectDeleteWebsiteCalled:        true,
+			expectLoggingDisabled:            true,
+		},
+		"lifecycle rules": {
+			bucketDetails: BucketDetails{
+				Lifecycle: `{"Rules":[{"ID":"tmp","Status":"Enabled","Filter":{"Prefix":"tmp/"},"Expiration":{"Days":7}}]}`,
+			},
+			s3Client:                         &MockS3Client{},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectLifecycleRules: []*s3.LifecycleRule{{
+				ID:         aws.String("tmp"),
+				Status:     aws.String("Enabled"),
+				Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("tmp/")},
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(7)},
+			}},
+			expectDeleteCORSCalled:    true,
+			expectDeleteWebsiteCalled: true,
+			expectLoggingDisabled:     true,
+		},
+		"remove lifecycle rules": {
+			bucketDetails: BucketDetails{
+				Lifecycle: `{"Rules":[]}`,
+			},
+			s3Client:                         &MockS3Client{},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectDeleteLifecycleCalled:      true,
+			expectDeleteCORSCalled:           true,
+			expectDeleteWebsiteCalled:        true,
+			expectLoggingDisabled:            true,
+		},
+		"cors rules": {
+			bucketDetails: BucketDetails{
+				CORS: `{"CORSRules":[{"AllowedOrigins":["https://app.example.gov"],"AllowedMethods":["PUT"]}]}`,
+			},
+			s3Client:                         &MockS3Client{},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectCORSRules: []*s3.CORSRule{{
+				AllowedOrigins: []*string{aws.String("https://app.example.gov")},
+				AllowedMethods: []*string{aws.String("PUT")},
+			}},
+			expectDeleteLifecycleCalled: true,
+			expectDeleteWebsiteCalled:   true,
+			expectLoggingDisabled:       true,
+		},
+		"remove cors rules": {
+			bucketDetails: BucketDetails{
+				CORS: `{"CORSRules":[]}`,
+			},
+			s3Client:                         &MockS3Client{},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectDeleteCORSCalled:           true,
+			expectDeleteLifecycleCalled:      true,
+			expectDeleteWebsiteCalled:        true,
+			expectLoggingDisabled:            true,
+		},
+		"access logging": {
+			bucketDetails: BucketDetails{
+				Logging: `{"TargetBucket":"audit-logs","TargetPrefix":"org/space/instance/"}`,
+			},
+			s3Client:                         &MockS3Client{},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectLogging: &s3.LoggingEnabled{
+				TargetBucket: aws.String("audit-logs"),
+				TargetPrefix: aws.String("org/space/instance/"),
+			},
+			expectDeleteLifecycleCalled: true,
+			expectDeleteCORSCalled:      true,
+			expectDeleteWebsiteCalled:   true,
+		},
+		"bucket over quota": {
+			bucketDetails: BucketDetails{
+				Policy:       secureTransportPolicy,
+				AwsPartition: "aws",
+			},
+			s3Client: &MockS3Client{
+				policy: aws.String(`{"Version":"2012-10-17","Statement":[` + quotaStatement + `]}`),
+			},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectNumPutBucketPolicyCalls:    1,
+			expectDeleteLifecycleCalled:      true,
+			expectDeleteCORSCalled:           true,
+			expectDeleteWebsiteCalled:        true,
+			expectLoggingDisabled:            true,
+			expectPolicy: `{"Version":"2012-10-17","Statement":[
+				{"Effect":"Deny","Principal":"*","Action":["s3:*"],"Resource":["arn:aws:s3:::b/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}},
+				` + quotaStatement + `
+			]}`,
+		},
+		"bucket over quota without a plan policy": {
+			bucketDetails: BucketDetails{},
+			s3Client: &MockS3Client{
+				policy: aws.String(`{"Version":"2012-10-17","Statement":` + quotaStatement + `}`),
+			},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectNumPutBucketPolicyCalls:    1,
+			expectDeleteLifecycleCalled:      true,
+			expectDeleteCORSCalled:           true,
+			expectDeleteWebsiteCalled:        true,
+			expectLoggingDisabled:            true,
+			expectPolicy:                     `{"Version":"2012-10-17","Statement":[` + quotaStatement + `]}`,
+		},
+		"plan downgrade": {
+			bucketDetails: BucketDetails{},
+			s3Client: &MockS3Client{
+				lifecycleRules: []*s3.LifecycleRule{{ID: aws.String("tmp"), Status: aws.String("Enabled")}},
+				corsRules:      []*s3.CORSRule{{AllowedOrigins: []*string{aws.String("https://app.example.gov")}}},
+				website:        &s3.GetBucketWebsiteOutput{IndexDocument: &s3.IndexDocument{Suffix: aws.String("index.html")}},
+				logging:        &s3.LoggingEnabled{TargetBucket: aws.String("audit-logs")},
+			},
+			expectTags:                       map[string]string{},
+			expectPutPublicAccessBlockCalled: true,
+			expectDeleteBucketPolicyCalled:   true,
+			expectDeleteLifecycleCalled:      true,
+			expectDeleteCORSCalled:           true,
+			expectDeleteWebsiteCalled:        true,
+			expectLoggingDisabled:            true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.s3Client, lager.NewLogger("test"))
+			err := b.Modify("b", tc.bucketDetails)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("expected return error %v, got %v", tc.expectErr, err)
+			}
+			if tc.expectTags != nil {
+				tags := map[string]string{}
+				for _, tag := range tc.s3Client.putBucketTaggingTags {
+					tags[*tag.Key] = *tag.Value
+				}
+				if !cmp.Equal(tags, tc.expectTags) {
+					t.Error(cmp.Diff(tags, tc.expectTags))
+				}
+			}
+			if tc.expectDeletePublicAccessBlockCalled != tc.s3Client.deletePublicAccessBlockCalled {
+				t.Errorf("expected delete public access block called: %v, got: %v", tc.expectDeletePublicAccessBlockCalled, tc.s3Client.deletePublicAccessBlockCalled)
+			}
+			if tc.expectPutPublicAccessBlockCalled != tc.s3Client.putPublicAccessBlockCalled {
+				t.Errorf("expected put public access block called: %v, got: %v", tc.expectPutPublicAccessBlockCalled, tc.s3Client.putPublicAccessBlockCalled)
+			}
+			if tc.expectDeleteBucketPolicyCalled != tc.s3Client.deleteBucketPolicyCalled {
+				t.Errorf("expected delete bucket policy called: %v, got: %v", tc.expectDeleteBucketPolicyCalled, tc.s3Client.deleteBucketPolicyCalled)
+			}
+			if tc.expectNumPutBucketPolicyCalls != tc.s3Client.numPutBucketPolicyCalls {
+				t.Errorf("expected number of put bucket policy calls: %d, got: %d", tc.expectNumPutBucketPolicyCalls, tc.s3Client.numPutBucketPolicyCalls)
+			}
+			if !cmp.Equal(tc.expectVersioning, tc.s3Client.putVersioning) {
+				t.Error(cmp.Diff(tc.s3Client.putVersioning, tc.expectVersioning))
+			}
+			if !cmp.Equal(tc.expectLifecycleRules, tc.s3Client.putLifecycleRules) {
+				t.Error(cmp.Diff(tc.s3Client.putLifecycleRules, tc.expectLifecycleRules))
+			}
+			if tc.expectDeleteLifecycleCalled != tc.s3Client.deleteLifecycleCalled {
+				t.Errorf("expected delete lifecycle called: %v, got: %v", tc.expectDeleteLifecycleCalled, tc.s3Client.deleteLifecycleCalled)
+			}
+			if !cmp.Equal(tc.expectCORSRules, tc.s3Client.putCORSRules) {
+				t.Error(cmp.Diff(tc.s3Client.putCORSRules, tc.expectCORSRules))
+			}
+			if tc.expectDeleteCORSCalled != tc.s3Client.deleteCORSCalled {
+				t.Errorf("expected delete cors called: %v, got: %v", tc.expectDeleteCORSCalled, tc.s3Client.deleteCORSCalled)
+			}
+			if tc.expectDeleteWebsiteCalled != tc.s3Client.deleteWebsiteCalled {
+				t.Errorf("expected delete website called: %v, got: %v", tc.expectDeleteWebsiteCalled, tc.s3Client.deleteWebsiteCalled)
+			}
+			if !cmp.Equal(tc.expectLogging, tc.s3Client.putLogging) {
+				t.Error(cmp.Diff(tc.s3Client.putLogging, tc.expectLogging))
+			}
+			if tc.expectPolicy != "" {
+				var policy, expectPolicy interface{}
+				if err := json.Unmarshal([]byte(aws.StringValue(tc.s3Client.putPolicy)), &policy); err != nil {
+					t.Fatal(err)
+				}
+				if err := json.Unmarshal([]byte(tc.expectPolicy), &expectPolicy); err != nil {
+					t.Fatal(err)
+				}
+				if !cmp.Equal(policy, expectPolicy) {
+					t.Error(cmp.Diff(policy, expectPolicy))
+				}
+			}
+			if tc.expectLoggingDisabled != tc.s3Client.loggingDisabled {
+				t.Errorf("expected logging disabled: %v, got: %v", tc.expectLoggingDisabled, tc.s3Client.loggingDisabled)
+			}
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	cases := map[string]struct {
+		s3Client      *MockS3Client
+		expectDetails BucketDetails
+		expectErr     error
+	}{
+		"bucket does not exist": {
+			s3Client: &MockS3Client{
+				getBucketLocationErr: awserr.New("NoSuchBucket", "no such bucket", errors.New("original error")),
+			},
+			expectErr: ErrBucketDoesNotExist,
+		},
+		"unconfigured bucket": {
+			s3Client: &MockS3Client{
+				getBucketTaggingErr: awserr.New("NoSuchTagSet", "no tags", errors.New("original error")),
+			},
+			expectDetails: BucketDetails{
+				BucketName:   "b",
+				ARN:          "arn:aws:s3:::b",
+				Region:       "us-east-1",
+				AwsPartition: "aws",
+				FIPSEndpoint: "s3-fips.us-east-1.amazonaws.com",
+				Tags:         map[string]string{},
+			},
+		},
+		"configured bucket": {
+			s3Client: &MockS3Client{
+				getBucketLocation: aws.String("us-gov-west-1"),
+				objectOwnership:   "BucketOwnerEnforced",
+				encryption: &s3.ServerSideEncryptionConfiguration{
+					Rules: []*s3.ServerSideEncryptionRule{{
+						ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+							SSEAlgorithm: aws.String("AES256"),
+						},
+					}},
+				},
+				policy:         aws.String("policy"),
+				policyIsPublic: true,
+				versioning:     aws.String("Enabled"),
+				lifecycleRules: []*s3.LifecycleRule{{
+					ID:     aws.String("tmp"),
+					Status: aws.String("Enabled"),
+				}},
+				logging: &s3.LoggingEnabled{
+					TargetBucket: aws.String("audit-logs"),
+					TargetPrefix: aws.String("org/space/instance/"),
+				},
+				getBucketTaggingTags: []*s3.Tag{
+					{Key: aws.String("Service plan name"), Value: aws.String("public")},
+				},
+			},
+			expectDetails: BucketDetails{
+				BucketName:      "b",
+				ARN:             "arn:aws:s3:::b",
+				Region:          "us-gov-west-1",
+				AwsPartition:    "aws",
+				FIPSEndpoint:    "s3-fips.us-gov-west-1.amazonaws.com",
+				ObjectOwnership: "BucketOwnerEnforced",
+				Encryption:      `{"Rules":[{"ApplyServerSideEncryptionByDefault":{"KMSMasterKeyID":null,"SSEAlgorithm":"AES256"},"BucketKeyEnabled":null}]}`,
+				Policy:          "policy",
+				Public:          true,
+				Versioning:      "Enabled",
+				Lifecycle:       `{"Rules":[{"AbortIncompleteMultipartUpload":null,"Expiration":null,"Filter":null,"ID":"tmp","NoncurrentVersionExpiration":null,"NoncurrentVersionTransitions":null,"Prefix":null,"Status":"Enabled","Transitions":null}]}`,
+				Logging:         `{"TargetBucket":"audit-logs","TargetGrants":null,"TargetObjectKeyFormat":null,"TargetPrefix":"org/space/instance/"}`,
+				Tags:            map[string]string{"Service plan name": "public"},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.s3Client, lager.NewLogger("test"))
+			details, err := b.Inspect("b", "aws")
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("expected return error %v, got %v", tc.expectErr, err)
+			}
+			if !cmp.Equal(details, tc.expectDetails) {
+				t.Error(cmp.Diff(details, tc.expectDetails))
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	s3Client := &MockS3Client{buckets: []string{"prefix-1", "other-1", "prefix-2"}}
+	bucket := S3Bucket{
+		s3svc:  s3Client,
+		logger: lager.NewLogger("s3-test"),
+	}
+
+	bucketNames, err := bucket.List("prefix-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(bucketNames, []string{"prefix-1", "prefix-2"}) {
+		t.Error(cmp.Diff(bucketNames, []string{"prefix-1", "prefix-2"}))
+	}
+}
+
 func TestPutBucketPolicyWithRetries(t *testing.T) {
 	accessDeniedErr := awserr.New("AccessDenied", "access denied", errors.New("original error"))
 	unexpectedErr := errors.New("failure")
@@ -370,3 +1097,196 @@ func TestHandleDeleteError(t *testing.T) {
 		})
 	}
 }
+
+type fakeDeleteIterator struct {
+	remaining int
+}
+
+func (i *fakeDeleteIterator) Next() bool {
+	i.remaining--
+	return i.remaining >= 0
+}
+
+func (i *fakeDeleteIterator) Err() error {
+	return nil
+}
+
+func (i *fakeDeleteIterator) DeleteObject() s3manager.BatchDeleteObject {
+	return s3manager.BatchDeleteObject{}
+}
+
+func TestCountingDeleteIterator(t *testing.T) {
+	var reported []int
+	iter := &countingDeleteIterator{
+		BatchDeleteIterator: &fakeDeleteIterator{remaining: 3},
+		progress: func(deleted int) {
+			reported = append(reported, deleted)
+		},
+	}
+	for iter.Next() {
+		iter.DeleteObject()
+	}
+	if !cmp.Equal(reported, []int{1, 2, 3}) {
+		t.Error(cmp.Diff(reported, []int{1, 2, 3}))
+	}
+}
+
+func TestWebsiteEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"us-gov-west-1": "http://b.s3-website-us-gov-west-1.amazonaws.com",
+		"us-east-2":     "http://b.s3-website.us-east-2.amazonaws.com",
+	}
+	for region, expected := range cases {
+		if endpoint := WebsiteEndpoint("b", region); endpoint != expected {
+			t.Errorf("expected %s, got %s", expected, endpoint)
+		}
+	}
+}
+
+func TestCheckNoLockedObjects(t *testing.T) {
+	objectLock := &s3.ObjectLockConfiguration{ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled)}
+	versions := []*s3.ObjectVersion{
+		{Key: aws.String("a"), VersionId: aws.String("1")},
+		{Key: aws.String("b"), VersionId: aws.String("1")},
+	}
+
+	cases := map[string]struct {
+		s3Client  *MockS3Client
+		expectErr error
+	}{
+		"no object lock": {
+			s3Client:  &MockS3Client{objectVersions: versions},
+			expectErr: nil,
+		},
+		"expired retention": {
+			s3Client: &MockS3Client{
+				objectLock:     objectLock,
+				objectVersions: versions,
+				retainUntil:    map[string]time.Time{"a": time.Now().Add(-time.Hour)},
+			},
+			expectErr: nil,
+		},
+		"unexpired retention": {
+			s3Client: &MockS3Client{
+				objectLock:     objectLock,
+				objectVersions: versions,
+				retainUntil:    map[string]time.Time{"b": time.Now().Add(time.Hour)},
+			},
+			expectErr: ErrBucketHasLockedObjects,
+		},
+		"legal hold": {
+			s3Client: &MockS3Client{
+				objectLock:     objectLock,
+				objectVersions: versions,
+				legalHolds:     map[string]bool{"a": true},
+			},
+			expectErr: ErrBucketHasLockedObjects,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			b := NewS3Bucket(tc.s3Client, lager.NewLogger("test"))
+			err := b.checkNoLockedObjects("b")
+			if !errors.Is(err, tc.expectErr) {
+				t.Errorf("expected return error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestVersionDeleteIterator(t *testing.T) {
+	iter := &versionDeleteIterator{
+		s3svc: &MockS3Client{
+			objectVersions: []*s3.ObjectVersion{
+				{Key: aws.String("a"), VersionId: aws.String("1")},
+				{Key: aws.String("a"), VersionId: aws.String("2")},
+			},
+			deleteMarkers: []*s3.DeleteMarkerEntry{
+				{Key: aws.String("b"), VersionId: aws.String("3")},
+			},
+		},
+		bucketName: "b",
+	}
+
+	var deleted []string
+	for iter.Next() {
+		object := iter.DeleteObject().Object
+		deleted = append(deleted, aws.StringValue(object.Key)+"@"+aws.StringValue(object.VersionId))
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a@1", "a@2", "b@3"}
+	if !cmp.Equal(deleted, expected) {
+		t.Error(cmp.Diff(deleted, expected))
+	}
+}
+
+// pagedVersionsClient lists object versions a page at a time, as S3 does for
+// buckets with more versions than fit in one response.
+type pagedVersionsClient struct {
+	S3Client
+	pages  []*s3.ListObjectVersionsOutput
+	inputs []s3.ListObjectVersionsInput
+}
+
+func (c *pagedVersionsClient) ListObjectVersions(input *s3.ListObjectVersionsInput) (*s3.ListObjectVersionsOutput, error) {
+	c.inputs = append(c.inputs, *input)
+	return c.pages[len(c.inputs)-1], nil
+}
+
+func TestVersionDeleteIteratorPages(t *testing.T) {
+	client := &pagedVersionsClient{
+		pages: []*s3.ListObjectVersionsOutput{
+			{
+				Versions: []*s3.ObjectVersion{
+					{Key: aws.String("a"), VersionId: aws.String("1"), IsLatest: aws.Bool(true)},
+					{Key: aws.String("a"), VersionId: aws.String("2")},
+				},
+				IsTruncated:         aws.Bool(true),
+				NextKeyMarker:       aws.String("a"),
+				NextVersionIdMarker: aws.String("2"),
+			},
+			// A page with only delete markers, as left behind by deleting
+			// objects from a versioned bucket.
+			{
+				DeleteMarkers: []*s3.DeleteMarkerEntry{
+					{Key: aws.String("b"), VersionId: aws.String("3"), IsLatest: aws.Bool(true)},
+				},
+				IsTruncated:         aws.Bool(true),
+				NextKeyMarker:       aws.String("b"),
+				NextVersionIdMarker: aws.String("3"),
+			},
+			{
+				Versions: []*s3.ObjectVersion{
+					{Key: aws.String("b"), VersionId: aws.String("4")},
+				},
+				IsTruncated: aws.Bool(false),
+			},
+		},
+	}
+	iter := &versionDeleteIterator{s3svc: client, bucketName: "b"}
+
+	var deleted []string
+	for iter.Next() {
+		object := iter.DeleteObject().Object
+		deleted = append(deleted, aws.StringValue(object.Key)+"@"+aws.StringValue(object.VersionId))
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a@1", "a@2", "b@3", "b@4"}
+	if !cmp.Equal(deleted, expected) {
+		t.Error(cmp.Diff(deleted, expected))
+	}
+
+	var markers []string
+	for _, input := range client.inputs {
+		markers = append(markers, aws.StringValue(input.KeyMarker)+"@"+aws.StringValue(input.VersionIdMarker))
+	}
+	expectedMarkers := []string{"@", "a@2", "b@3"}
+	if !cmp.Equal(markers, expectedMarkers) {
+		t.Error(cmp.Diff(markers, expectedMarkers))
+	}
+}
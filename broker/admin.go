@@ -0,0 +1,246 @@
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+var (
+	ErrBindingNotFound    = errors.New("binding does not exist")
+	ErrBindingNotOrphaned = errors.New("binding's instance still exists; unbind it through Cloud Foundry instead")
+	ErrPlanNotFound       = errors.New("instance's plan is not in the catalog")
+)
+
+// AdminInstance is an instance's effective configuration, read back from AWS,
+// and the IDs of its user bindings.
+type AdminInstance struct {
+	InstanceID string      `json:"instance_id"`
+	PlanID     string      `json:"plan_id"`
+	Parameters interface{} `json:"parameters"`
+	Bindings   []string    `json:"bindings"`
+}
+
+// NewAdminHandler returns the operator API, which is served under /admin and
+// authenticated with its own username and password:
+//
+//	GET    /admin/buckets                      broker-managed buckets
+//	GET    /admin/users                        IAM users of user bindings
+//	GET    /admin/instances/{instance}         an instance and its bindings
+//	POST   /admin/instances/{instance}/reapply re-apply the instance's plan
+//	DELETE /admin/bindings/{binding}           delete an orphaned binding
+func NewAdminHandler(b *S3Broker, username, password string) http.Handler {
+	logger := b.logger.Session("admin")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/buckets", func(w http.ResponseWriter, r *http.Request) {
+		buckets, err := b.listBuckets()
+		writeAdminResponse(w, logger, map[string][]string{"buckets": buckets}, err)
+	})
+	mux.HandleFunc("GET /admin/users", func(w http.ResponseWriter, r *http.Request) {
+		users, err := b.listBindingUsers()
+		writeAdminResponse(w, logger, map[string][]string{"users": users}, err)
+	})
+	mux.HandleFunc("GET /admin/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+		instance, err := b.adminInstance(r.Context(), r.PathValue("instance"))
+		writeAdminResponse(w, logger, instance, err)
+	})
+	mux.HandleFunc("POST /admin/instances/{instance}/reapply", func(w http.ResponseWriter, r *http.Request) {
+		err := b.reapplyPlan(r.Context(), r.PathValue("instance"))
+		writeAdminResponse(w, logger, nil, err)
+	})
+	mux.HandleFunc("DELETE /admin/bindings/{binding}", func(w http.ResponseWriter, r *http.Request) {
+		err := b.deleteOrphanedBinding(r.Context(), r.PathValue("binding"))
+		writeAdminResponse(w, logger, nil, err)
+	})
+
+	// Hash the credentials so that comparing them takes the same time
+	// whatever their lengths.
+	usernameHash := sha256.Sum256([]byte(username))
+	passwordHash := sha256.Sum256([]byte(password))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestUsername, requestPassword, ok := r.BasicAuth()
+		requestUsernameHash := sha256.Sum256([]byte(requestUsername))
+		requestPasswordHash := sha256.Sum256([]byte(requestPassword))
+		if !ok ||
+			subtle.ConstantTimeCompare(usernameHash[:], requestUsernameHash[:]) != 1 ||
+			subtle.ConstantTimeCompare(passwordHash[:], requestPasswordHash[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="s3-broker admin"`)
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+		logger.Info("request", lager.Data{"method": r.Method, "path": r.URL.Path})
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeAdminResponse(w http.ResponseWriter, logger lager.Logger, body interface{}, err error) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case ErrInstanceNotFound, ErrBindingNotFound, ErrPlanNotFound:
+			status = http.StatusNotFound
+		case ErrBindingNotOrphaned:
+			status = http.StatusConflict
+		default:
+			logger.Error("request-failed", err)
+		}
+		body = apiresponses.ErrorResponse{Description: err.Error()}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	if body == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// listBuckets returns the names of the buckets the broker created.
+func (b *S3Broker) listBuckets() ([]string, error) {
+	return b.bucket.List(b.bucketPrefix + "-")
+}
+
+// listBindingUsers returns the names of the IAM users of user bindings.
+func (b *S3Broker) listBindingUsers() ([]string, error) {
+	users, err := b.user.List(b.iamPath)
+	if err != nil {
+		return nil, err
+	}
+	var bindingUsers []string
+	for _, user := range users {
+		if strings.HasPrefix(user, b.userPrefix+"-") {
+			bindingUsers = append(bindingUsers, user)
+		}
+	}
+	return bindingUsers, nil
+}
+
+// instanceBindings returns the IDs of an instance's user bindings. Binding
+// users record their instance in their tags, so every binding user is
+// described.
+func (b *S3Broker) instanceBindings(instanceID string) ([]string, error) {
+	users, err := b.listBindingUsers()
+	if err != nil {
+		return nil, err
+	}
+	bindings := []string{}
+	for _, user := range users {
+		userDetails, err := b.user.Describe(user)
+		if err != nil {
+			return nil, err
+		}
+		if userDetails.Tags[brokertags.ServiceInstanceGUIDTagKey] == instanceID {
+			bindings = append(bindings, strings.TrimPrefix(user, b.userPrefix+"-"))
+		}
+	}
+	return bindings, nil
+}
+
+func (b *S3Broker) adminInstance(ctx context.Context, instanceID string) (*AdminInstance, error) {
+	spec, err := b.GetInstance(ctx, instanceID, domain.FetchInstanceDetails{})
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := b.instanceBindings(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return &AdminInstance{
+		InstanceID: instanceID,
+		PlanID:     spec.PlanID,
+		Parameters: spec.Parameters,
+		Bindings:   bindings,
+	}, nil
+}
+
+// reapplyPlan updates an instance to the current settings of its plan, as an
+// update that keeps the plan would. The user's lifecycle rules and CORS
+// configuration are kept.
+func (b *S3Broker) reapplyPlan(ctx context.Context, instanceID string) error {
+	bucketDetails, err := b.bucket.Inspect(b.bucketName(instanceID), b.awsPartition)
+	if err != nil {
+		if err == awss3.ErrBucketDoesNotExist {
+			return ErrInstanceNotFound
+		}
+		return err
+	}
+
+	planName := bucketDetails.Tags[brokertags.ServicePlanName]
+	var plan ServicePlan
+	for _, p := range b.catalog.ListServicePlans() {
+		if p.Name == planName {
+			plan = p
+			break
+		}
+	}
+	service, ok := b.catalog.FindServiceForPlan(plan.ID)
+	if plan.ID == "" || !ok {
+		return ErrPlanNotFound
+	}
+
+	_, err = b.Update(ctx, instanceID, domain.UpdateDetails{
+		ServiceID: service.ID,
+		PlanID:    plan.ID,
+		PreviousValues: domain.PreviousValues{
+			ServiceID: service.ID,
+			PlanID:    plan.ID,
+			OrgID:     bucketDetails.Tags[brokertags.OrganizationGUIDTagKey],
+			SpaceID:   bucketDetails.Tags[brokertags.SpaceGUIDTagKey],
+		},
+	}, false)
+	if err == apiresponses.ErrInstanceDoesNotExist {
+		return ErrInstanceNotFound
+	}
+	return err
+}
+
+// deleteOrphanedBinding deletes a user binding whose instance no longer
+// exists, which the platform can no longer unbind.
+func (b *S3Broker) deleteOrphanedBinding(ctx context.Context, bindingID string) error {
+	userName := b.userName(bindingID)
+	exists, err := b.user.Exists(userName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrBindingNotFound
+	}
+
+	userDetails, err := b.user.Describe(userName)
+	if err != nil {
+		return err
+	}
+	instanceID := userDetails.Tags[brokertags.ServiceInstanceGUIDTagKey]
+	if instanceID != "" {
+		_, err := b.bucket.Describe(b.bucketName(instanceID), b.awsPartition)
+		if err == nil {
+			return ErrBindingNotOrphaned
+		}
+		if err != awss3.ErrBucketDoesNotExist {
+			return err
+		}
+	}
+
+	b.logger.Info("delete-orphaned-binding", lager.Data{
+		instanceIDLogKey: instanceID,
+		bindingIDLogKey:  bindingID,
+	})
+	_, err = b.Unbind(ctx, instanceID, bindingID, domain.UnbindDetails{}, false)
+	return err
+}
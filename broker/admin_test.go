@@ -0,0 +1,161 @@
+package broker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// noSuchBucketS3Client is an S3 client for which no bucket exists. Calls
+// other than GetBucketLocation panic.
+type noSuchBucketS3Client struct {
+	awss3.S3Client
+}
+
+func (noSuchBucketS3Client) GetBucketLocation(*s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	return nil, awserr.New("NoSuchBucket", "The specified bucket does not exist", nil)
+}
+
+func TestAdminHandler(t *testing.T) {
+	testCases := map[string]struct {
+		method       string
+		path         string
+		password     string
+		bucket       *mockBucket
+		s3Client     awss3.S3Client
+		user         *mockUser
+		expectStatus int
+		expectBody   string
+	}{
+		"wrong password": {
+			method:       http.MethodGet,
+			path:         "/admin/buckets",
+			password:     "wrong",
+			expectStatus: http.StatusUnauthorized,
+		},
+		"list buckets": {
+			method:       http.MethodGet,
+			path:         "/admin/buckets",
+			bucket:       &mockBucket{buckets: []string{"test-instance1", "test-instance2"}},
+			expectStatus: http.StatusOK,
+			expectBody:   `{"buckets":["test-instance1","test-instance2"]}`,
+		},
+		"list binding users": {
+			method:       http.MethodGet,
+			path:         "/admin/users",
+			user:         &mockUser{users: []string{"user-binding1", "someone-else", "user-binding2"}},
+			expectStatus: http.StatusOK,
+			expectBody:   `{"users":["user-binding1","user-binding2"]}`,
+		},
+		"show instance": {
+			method: http.MethodGet,
+			path:   "/admin/instances/instance1",
+			bucket: &mockBucket{inspectDetails: awss3.BucketDetails{BucketName: "test-instance1"}},
+			user: &mockUser{
+				users: []string{"user-binding1", "user-binding2"},
+				userTags: map[string]map[string]string{
+					"user-binding1": {brokertags.ServiceInstanceGUIDTagKey: "instance1"},
+					"user-binding2": {brokertags.ServiceInstanceGUIDTagKey: "instance2"},
+				},
+			},
+			expectStatus: http.StatusOK,
+			expectBody:   `"bindings":["binding1"]`,
+		},
+		"show missing instance": {
+			method:       http.MethodGet,
+			path:         "/admin/instances/instance1",
+			bucket:       &mockBucket{inspectErr: awss3.ErrBucketDoesNotExist},
+			expectStatus: http.StatusNotFound,
+		},
+		"reapply plan not in catalog": {
+			method: http.MethodPost,
+			path:   "/admin/instances/instance1/reapply",
+			bucket: &mockBucket{inspectDetails: awss3.BucketDetails{
+				Tags: map[string]string{brokertags.ServicePlanName: "retired"},
+			}},
+			expectStatus: http.StatusNotFound,
+		},
+		"delete orphaned binding": {
+			method: http.MethodDelete,
+			path:   "/admin/bindings/binding1",
+			bucket: &mockBucket{describeErr: awss3.ErrBucketDoesNotExist},
+			user: &mockUser{
+				tags:       map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance1"},
+				accessKeys: map[string][]string{"user-binding1": {"key1"}},
+			},
+			expectStatus: http.StatusNoContent,
+		},
+		"delete orphaned binding of deleted bucket": {
+			method:   http.MethodDelete,
+			path:     "/admin/bindings/binding1",
+			s3Client: noSuchBucketS3Client{},
+			user: &mockUser{
+				tags:       map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance1"},
+				accessKeys: map[string][]string{"user-binding1": {"key1"}},
+			},
+			expectStatus: http.StatusNoContent,
+		},
+		"delete binding of existing instance": {
+			method: http.MethodDelete,
+			path:   "/admin/bindings/binding1",
+			bucket: &mockBucket{},
+			user: &mockUser{
+				tags: map[string]string{brokertags.ServiceInstanceGUIDTagKey: "instance1"},
+			},
+			expectStatus: http.StatusConflict,
+		},
+		"delete missing binding": {
+			method:       http.MethodDelete,
+			path:         "/admin/bindings/binding1",
+			user:         &mockUser{notFound: true},
+			expectStatus: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if tc.bucket == nil {
+				tc.bucket = &mockBucket{}
+			}
+			if tc.user == nil {
+				tc.user = &mockUser{}
+			}
+			if tc.password == "" {
+				tc.password = "secret"
+			}
+			logger := lager.NewLogger("broker-unit-test-TestAdminHandler")
+			var bucket awss3.Bucket = tc.bucket
+			if tc.s3Client != nil {
+				bucket = awss3.NewS3Bucket(tc.s3Client, logger)
+			}
+			broker := &S3Broker{
+				logger:       logger,
+				bucketPrefix: "test",
+				userPrefix:   "user",
+				bucket:       bucket,
+				user:         tc.user,
+				catalog:      &mockCatalog{},
+			}
+
+			request := httptest.NewRequest(tc.method, tc.path, nil)
+			request.SetBasicAuth("admin", tc.password)
+			recorder := httptest.NewRecorder()
+			NewAdminHandler(broker, "admin", "secret").ServeHTTP(recorder, request)
+
+			if recorder.Code != tc.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectStatus, recorder.Code, recorder.Body)
+			}
+			if !strings.Contains(recorder.Body.String(), tc.expectBody) {
+				t.Errorf("expected body to contain %s, got %s", tc.expectBody, recorder.Body)
+			}
+		})
+	}
+}
@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -17,6 +22,7 @@ import (
 	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
 
 	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awskms"
 	"github.com/cloud-gov/s3-broker/awss3"
 
 	brokertags "github.com/cloud-gov/go-broker-tags"
@@ -29,6 +35,15 @@ const acceptsIncompleteLogKey = "acceptsIncomplete"
 
 var (
 	ErrNoClientConfigured = errors.New("This broker is not configured to support binding to additional instances. Contact your Cloud Foundry operator for details.")
+	ErrInstanceNotFound   = apiresponses.NewFailureResponseBuilder(
+		errors.New("instance does not exist"), http.StatusNotFound, "instance-not-found",
+	).WithEmptyResponse().Build()
+	ErrNoReplicaRegionConfigured = errors.New("This broker is not configured to support replication. Contact your Cloud Foundry operator for details.")
+	ErrNoKMSConfigured           = errors.New("This broker is not configured to support dedicated KMS keys. Contact your Cloud Foundry operator for details.")
+	ErrInstanceHasLockedObjects  = apiresponses.NewFailureResponse(
+		errors.New("The bucket still holds objects under object lock retention or legal hold. Delete the instance after their retention periods end."),
+		http.StatusUnprocessableEntity, "object-lock",
+	)
 )
 
 type S3Broker struct {
@@ -43,10 +58,19 @@ type S3Broker struct {
 	allowUserBindParameters      bool
 	catalog                      Catalog
 	bucket                       awss3.Bucket
+	replicaBucket                awss3.Bucket
+	replicaRegion                string
+	accessLogBucket              string
+	accessLogPrefix              string
+	replicaAccessLogBucket       string
 	user                         awsiam.User
+	role                         awsiam.Role
+	key                          awskms.Key
+	bindingStrategy              string
 	cf                           *cf.Client
 	logger                       lager.Logger
 	tagManager                   brokertags.TagManager
+	operations                   operationStore
 }
 
 type CatalogExternal struct {
@@ -63,12 +87,26 @@ type Credentials struct {
 	Endpoint           string   `json:"endpoint"`
 	FIPSEndpoint       string   `json:"fips_endpoint"`
 	AdditionalBuckets  []string `json:"additional_buckets"`
+	Prefix             string   `json:"prefix,omitempty"`
+	ExpiresAt          string   `json:"expires_at,omitempty"`
+	WebsiteURL         string   `json:"website_url,omitempty"`
+	ReplicaBucket      string   `json:"replica_bucket,omitempty"`
+	ReplicaRegion      string   `json:"replica_region,omitempty"`
+
+	// Set for role bindings, whose credentials are temporary. Apps renew
+	// them by assuming RoleARN with the current credentials.
+	SessionToken string `json:"session_token,omitempty"`
+	RoleARN      string `json:"role_arn,omitempty"`
+	Expiration   string `json:"expiration,omitempty"`
 }
 
 func New(
 	config Config,
 	bucket awss3.Bucket,
+	replicaBucket awss3.Bucket,
 	user awsiam.User,
+	role awsiam.Role,
+	key awskms.Key,
 	cfClient *cf.Client,
 	logger lager.Logger,
 	tagManager brokertags.TagManager,
@@ -84,7 +122,15 @@ func New(
 		allowUserUpdateParameters:    config.AllowUserUpdateParameters,
 		catalog:                      config.Catalog,
 		bucket:                       bucket,
+		replicaBucket:                replicaBucket,
+		replicaRegion:                config.ReplicaRegion,
+		accessLogBucket:              config.AccessLogBucket,
+		accessLogPrefix:              config.AccessLogPrefix,
+		replicaAccessLogBucket:       config.ReplicaAccessLogBucket,
 		user:                         user,
+		role:                         role,
+		key:                          key,
+		bindingStrategy:              config.BindingStrategy,
 		cf:                           cfClient,
 		logger:                       logger.Session("broker"),
 		tagManager:                   tagManager,
@@ -104,6 +150,33 @@ func (b *S3Broker) Services(context context.Context) ([]brokerapi.Service, error
 		return []brokerapi.Service{}, err
 	}
 
+	// Instance and binding parameters are read back from AWS, so every
+	// service supports fetching instances and bindings.
+	for idx := range apiCatalog.Services {
+		apiCatalog.Services[idx].InstancesRetrievable = true
+		apiCatalog.Services[idx].BindingsRetrievable = true
+
+		// Publish storage quotas so that the reconcile-quotas task can find
+		// them through the Cloud Foundry API.
+		for planIdx, apiPlan := range apiCatalog.Services[idx].Plans {
+			plan, _ := b.catalog.FindServicePlan(apiPlan.ID)
+			if plan.S3Properties.MaxSizeBytes == 0 && plan.S3Properties.MaxObjects == 0 {
+				continue
+			}
+			if apiPlan.Metadata == nil {
+				apiPlan.Metadata = &brokerapi.ServicePlanMetadata{}
+			}
+			if apiPlan.Metadata.AdditionalMetadata == nil {
+				apiPlan.Metadata.AdditionalMetadata = map[string]interface{}{}
+			}
+			apiPlan.Metadata.AdditionalMetadata["quota"] = map[string]int64{
+				"max_size_bytes": plan.S3Properties.MaxSizeBytes,
+				"max_objects":    plan.S3Properties.MaxObjects,
+			}
+			apiCatalog.Services[idx].Plans[planIdx] = apiPlan
+		}
+	}
+
 	return apiCatalog.Services, nil
 }
 
@@ -140,13 +213,47 @@ func (b *S3Broker) Provision(
 	if err != nil {
 		return domain.ProvisionedServiceSpec{}, err
 	}
-	if _, err = b.bucket.Create(b.bucketName(instanceID), *instance); err != nil {
+
+	if asyncAllowed {
+		// Creating a public bucket polls AWS until the public access block is
+		// gone, which can outlast the platform's request timeout.
+		b.operations.start(instanceID, provisionOperation, "Creating bucket")
+		go func() {
+			err := b.provisionBucket(instanceID, servicePlan, instance)
+			if err != nil {
+				b.logger.Error("provision: error creating bucket", err, lager.Data{
+					instanceIDLogKey: instanceID,
+				})
+			}
+			b.operations.finish(instanceID, provisionOperation, "Bucket created", err)
+		}()
+		return domain.ProvisionedServiceSpec{IsAsync: true, OperationData: provisionOperation}, nil
+	}
+
+	if err = b.provisionBucket(instanceID, servicePlan, instance); err != nil {
 		return domain.ProvisionedServiceSpec{}, err
 	}
 
 	return domain.ProvisionedServiceSpec{IsAsync: false}, nil
 }
 
+// provisionBucket creates the instance's bucket, along with its replica if the
+// plan replicates and its KMS key if the plan has dedicated keys.
+func (b *S3Broker) provisionBucket(instanceID string, servicePlan ServicePlan, instance *awss3.BucketDetails) error {
+	if servicePlan.S3Properties.DedicatedKMSKey {
+		if err := b.createKey(instanceID, instance); err != nil {
+			return err
+		}
+	}
+	if servicePlan.S3Properties.Replication != nil {
+		if err := b.createReplica(instanceID, servicePlan, instance); err != nil {
+			return err
+		}
+	}
+	_, err := b.bucket.Create(b.bucketName(instanceID), *instance)
+	return err
+}
+
 func (b *S3Broker) Update(
 	context context.Context,
 	instanceID string,
@@ -171,7 +278,13 @@ func (b *S3Broker) Update(
 		return domain.UpdateServiceSpec{}, fmt.Errorf("Service Plan '%s' not found", details.PlanID)
 	}
 
-	instance := b.modifyBucket(instanceID, servicePlan, updateParameters, details)
+	instance, err := b.modifyBucket(instanceID, servicePlan, updateParameters, details)
+	if err != nil {
+		if err == awss3.ErrBucketDoesNotExist {
+			return domain.UpdateServiceSpec{}, apiresponses.ErrInstanceDoesNotExist
+		}
+		return domain.UpdateServiceSpec{}, err
+	}
 	if err := b.bucket.Modify(b.bucketName(instanceID), *instance); err != nil {
 		if err == awss3.ErrBucketDoesNotExist {
 			return domain.UpdateServiceSpec{}, apiresponses.ErrInstanceDoesNotExist
@@ -198,16 +311,92 @@ func (b *S3Broker) Deprovision(
 	if !ok {
 		return domain.DeprovisionServiceSpec{}, fmt.Errorf("Service Plan '%s' not found", details.PlanID)
 	}
+
+	// Emptying a large bucket can take far longer than a single request, so
+	// do it in the background when the platform allows.
+	if servicePlan.PlanDeletable && asyncAllowed {
+		if err := b.emptyAndDeleteBucket(instanceID, servicePlan); err != nil {
+			return domain.DeprovisionServiceSpec{}, err
+		}
+		return domain.DeprovisionServiceSpec{IsAsync: true, OperationData: deprovisionOperation}, nil
+	}
+
+	// The bucket of an instance that is still being provisioned may not
+	// exist yet, so the platform must retry once provisioning finishes.
+	if operation, ok := b.operations.get(instanceID, provisionOperation); ok && operation.State == domain.InProgress {
+		return domain.DeprovisionServiceSpec{}, apiresponses.ErrConcurrentInstanceAccess
+	}
+
 	if err := b.bucket.Delete(b.bucketName(instanceID), servicePlan.PlanDeletable); err != nil {
 		if err == awss3.ErrBucketDoesNotExist {
 			return domain.DeprovisionServiceSpec{}, brokerapi.ErrInstanceDoesNotExist
 		}
+		if err == awss3.ErrBucketHasLockedObjects {
+			return domain.DeprovisionServiceSpec{}, ErrInstanceHasLockedObjects
+		}
 		return domain.DeprovisionServiceSpec{}, err
 	}
 
+	if servicePlan.S3Properties.Replication != nil && b.replicaBucket != nil {
+		if err := b.deleteReplica(instanceID, servicePlan.PlanDeletable); err != nil {
+			return domain.DeprovisionServiceSpec{}, err
+		}
+	}
+
+	if servicePlan.S3Properties.DedicatedKMSKey && b.key != nil {
+		if err := b.deleteKey(instanceID); err != nil {
+			return domain.DeprovisionServiceSpec{}, err
+		}
+	}
+
 	return domain.DeprovisionServiceSpec{IsAsync: false}, nil
 }
 
+// emptyAndDeleteBucket deletes the contents of the instance's bucket and then
+// the bucket itself in the background, recording progress in b.operations.
+// The replica bucket of replicated plans is deleted the same way afterwards,
+// and then any dedicated KMS key is scheduled for deletion. Nothing is started
+// if the instance is already being deprovisioned, and a concurrency error is
+// returned if any other operation is in progress.
+func (b *S3Broker) emptyAndDeleteBucket(instanceID string, servicePlan ServicePlan) error {
+	bucketName := b.bucketName(instanceID)
+	if running, ok := b.operations.startUnlessRunning(instanceID, deprovisionOperation, "Deleting bucket contents"); !ok {
+		if running == deprovisionOperation {
+			return nil
+		}
+		return apiresponses.ErrConcurrentInstanceAccess
+	}
+	go func() {
+		err := b.bucket.Empty(bucketName, func(deleted int) {
+			b.operations.start(instanceID, deprovisionOperation, fmt.Sprintf("Deleted %d objects", deleted))
+		})
+		if err == nil {
+			err = b.bucket.Delete(bucketName, false)
+		}
+		if err == nil && servicePlan.S3Properties.Replication != nil && b.replicaBucket != nil {
+			err = b.replicaBucket.Empty(b.replicaBucketName(instanceID), func(deleted int) {
+				b.operations.start(instanceID, deprovisionOperation, fmt.Sprintf("Deleted %d replica objects", deleted))
+			})
+			if err == nil {
+				err = b.deleteReplica(instanceID, false)
+			}
+		}
+		if err == nil && servicePlan.S3Properties.DedicatedKMSKey && b.key != nil {
+			err = b.deleteKey(instanceID)
+		}
+		if err == awss3.ErrBucketHasLockedObjects {
+			err = ErrInstanceHasLockedObjects
+		}
+		if err != nil {
+			b.logger.Error("deprovision: error deleting bucket", err, lager.Data{
+				instanceIDLogKey: instanceID,
+			})
+		}
+		b.operations.finish(instanceID, deprovisionOperation, "Bucket deleted", err)
+	}()
+	return nil
+}
+
 func (b *S3Broker) GetBucketURI(credentials Credentials) string {
 	return fmt.Sprintf(
 		"s3://%s:%s@%s/%s",
@@ -319,6 +508,21 @@ func (b *S3Broker) Bind(
 		return binding, fmt.Errorf("Service '%s' not found", details.ServiceID)
 	}
 
+	iamPolicy, err := servicePlan.S3Properties.IamPolicyFor(bindParameters.Permissions)
+	if err != nil {
+		return binding, err
+	}
+
+	prefix, err := normalizePrefix(bindParameters.Prefix)
+	if err != nil {
+		return binding, err
+	}
+
+	expiresAt, err := expiresAtFor(bindParameters.ExpiresIn)
+	if err != nil {
+		return binding, err
+	}
+
 	tags, err := b.tagManager.GenerateTags(
 		brokertags.Create,
 		service.Name,
@@ -328,6 +532,13 @@ func (b *S3Broker) Bind(
 		},
 		true,
 	)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	if expiresAt != "" {
+		tags[awsiam.ExpiresAtTagKey] = expiresAt
+	}
+	tags[awsiam.PermissionsTagKey] = permissionsTagValue(bindParameters.Permissions)
 	iamTags := awsiam.ConvertTagsMapToIAMTags(tags)
 
 	bucketNames := []string{b.bucketName(instanceID)}
@@ -343,7 +554,7 @@ func (b *S3Broker) Bind(
 		bucketNames = append(bucketNames, additionalNames...)
 	}
 
-	credentials := Credentials{AdditionalBuckets: []string{}}
+	credentials := Credentials{AdditionalBuckets: []string{}, Prefix: prefix, ExpiresAt: expiresAt}
 	bucketARNs := make([]string, len(bucketNames))
 	detailc, errc := make(chan awss3.BucketDetails), make(chan error)
 	for _, bucketName := range bucketNames {
@@ -376,6 +587,13 @@ func (b *S3Broker) Bind(
 				credentials.FIPSEndpoint = bucketDetails.FIPSEndpoint
 				credentials.Endpoint = bucketDetails.FIPSEndpoint
 				credentials.InsecureSkipVerify = b.insecureSkipVerify
+				if servicePlan.S3Properties.Website != nil {
+					credentials.WebsiteURL = awss3.WebsiteEndpoint(bucketDetails.BucketName, bucketDetails.Region)
+				}
+				if servicePlan.S3Properties.Replication != nil {
+					credentials.ReplicaBucket = b.replicaBucketName(instanceID)
+					credentials.ReplicaRegion = b.replicaRegion
+				}
 			} else {
 				credentials.AdditionalBuckets = append(credentials.AdditionalBuckets, bucketDetails.BucketName)
 			}
@@ -384,6 +602,18 @@ func (b *S3Broker) Bind(
 		}
 	}
 
+	var keyARN string
+	if servicePlan.S3Properties.DedicatedKMSKey && b.key != nil {
+		keyARN, err = b.key.Describe(b.kmsKeyAlias(instanceID))
+		if err != nil {
+			return binding, err
+		}
+	}
+
+	if b.bindingStrategyFor(servicePlan) == BindingStrategyRole {
+		return b.bindRole(instanceID, bindingID, details, iamPolicy, bucketARNs, prefix, keyARN, iamTags, credentials)
+	}
+
 	if _, err = b.user.Create(b.userName(bindingID), b.iamPath, iamTags); err != nil {
 		b.logger.Error("bind: error creating user", err, lager.Data{
 			instanceIDLogKey: instanceID,
@@ -449,8 +679,9 @@ func (b *S3Broker) Bind(
 	policyARN, err = b.user.CreatePolicy(
 		b.policyName(bindingID),
 		b.iamPath,
-		string(servicePlan.S3Properties.IamPolicy),
+		iamPolicy,
 		bucketARNs,
+		prefix,
 		iamTags,
 	)
 	if err != nil {
@@ -487,6 +718,56 @@ func (b *S3Broker) Bind(
 		return binding, err
 	}
 
+	if credentials.ReplicaBucket != "" || keyARN != "" {
+		defer func() {
+			// A user cannot be deleted while policies are attached to it.
+			if err != nil {
+				if derr := b.user.DetachUserPolicy(b.userName(bindingID), policyARN); derr != nil {
+					b.logger.Error("bind: defer: error detaching policy", derr, lager.Data{
+						instanceIDLogKey: instanceID,
+						bindingIDLogKey:  bindingID,
+						detailsLogKey:    details,
+						"user":           b.userName(bindingID),
+					})
+				}
+			}
+		}()
+	}
+
+	detachUserPolicy := func(policyARN string) error {
+		return b.user.DetachUserPolicy(b.userName(bindingID), policyARN)
+	}
+
+	if credentials.ReplicaBucket != "" {
+		var replicaPolicyARN string
+		replicaPolicyARN, err = b.attachReplicaPolicy(instanceID, bindingID, prefix, iamTags, func(policyARN string) error {
+			return b.user.AttachUserPolicy(b.userName(bindingID), policyARN)
+		})
+		if err != nil {
+			return binding, err
+		}
+		defer func() {
+			if err != nil {
+				b.detachPolicy(instanceID, bindingID, replicaPolicyARN, detachUserPolicy)
+			}
+		}()
+	}
+
+	if keyARN != "" {
+		var keyPolicyARN string
+		keyPolicyARN, err = b.attachKeyPolicy(instanceID, bindingID, keyARN, iamTags, func(policyARN string) error {
+			return b.user.AttachUserPolicy(b.userName(bindingID), policyARN)
+		})
+		if err != nil {
+			return binding, err
+		}
+		defer func() {
+			if err != nil {
+				b.detachPolicy(instanceID, bindingID, keyPolicyARN, detachUserPolicy)
+			}
+		}()
+	}
+
 	credentials.AccessKeyID = accessKeyID
 	credentials.SecretAccessKey = secretAccessKey
 	credentials.URI = b.GetBucketURI(credentials)
@@ -496,6 +777,286 @@ func (b *S3Broker) Bind(
 	return binding, nil
 }
 
+// bindRole completes a binding by creating an IAM role with the binding's
+// policy attached and starting a session for it, instead of creating an IAM
+// user with a long-lived access key.
+func (b *S3Broker) bindRole(
+	instanceID string,
+	bindingID string,
+	details domain.BindDetails,
+	iamPolicy string,
+	bucketARNs []string,
+	prefix string,
+	keyARN string,
+	iamTags []*iam.Tag,
+	credentials Credentials,
+) (domain.Binding, error) {
+	binding := domain.Binding{}
+	roleName := b.userName(bindingID)
+
+	if b.role == nil {
+		return binding, errors.New("This broker is not configured to support role bindings. Contact your Cloud Foundry operator for details.")
+	}
+
+	roleARN, err := b.role.Create(roleName, b.iamPath, iamTags)
+	if err != nil {
+		b.logger.Error("bind: error creating role", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			detailsLogKey:    details,
+			"role":           roleName,
+		})
+		return binding, err
+	}
+	defer func() {
+		// If the function returns an error, Bind did not complete and resources must be cleaned up.
+		if err != nil {
+			b.logger.Info("bind: defer: err was not nil on return; deleting role", lager.Data{
+				instanceIDLogKey: instanceID,
+				bindingIDLogKey:  bindingID,
+				detailsLogKey:    details,
+			})
+
+			// Careful: Do not shadow err, or future defers will not work.
+			if derr := b.role.Delete(roleName); derr != nil {
+				b.logger.Error("bind: defer: error deleting role", derr, lager.Data{
+					instanceIDLogKey: instanceID,
+					bindingIDLogKey:  bindingID,
+					detailsLogKey:    details,
+					"role":           roleName,
+				})
+			}
+		}
+	}()
+
+	policyARN, err := b.user.CreatePolicy(
+		b.policyName(bindingID),
+		b.iamPath,
+		iamPolicy,
+		bucketARNs,
+		prefix,
+		iamTags,
+	)
+	if err != nil {
+		b.logger.Error("bind: error creating policy", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			detailsLogKey:    details,
+			"role":           roleName,
+		})
+		return binding, err
+	}
+	defer func() {
+		// If the function returns an error, Bind did not complete and resources must be cleaned up.
+		if err != nil {
+			b.logger.Info("bind: defer: err was not nil on return; deleting policy", lager.Data{
+				instanceIDLogKey: instanceID,
+				bindingIDLogKey:  bindingID,
+				detailsLogKey:    details,
+			})
+
+			// Careful: Do not shadow err, or future defers will not work.
+			if derr := b.user.DeletePolicy(policyARN); derr != nil {
+				b.logger.Error("bind: defer: error deleting policy", derr, lager.Data{
+					instanceIDLogKey: instanceID,
+					bindingIDLogKey:  bindingID,
+					detailsLogKey:    details,
+					"role":           roleName,
+				})
+			}
+		}
+	}()
+
+	if err = b.role.AttachRolePolicy(roleName, policyARN); err != nil {
+		return binding, err
+	}
+	defer func() {
+		// A role cannot be deleted while policies are attached to it.
+		if err != nil {
+			if derr := b.role.DetachRolePolicy(roleName, policyARN); derr != nil {
+				b.logger.Error("bind: defer: error detaching policy", derr, lager.Data{
+					instanceIDLogKey: instanceID,
+					bindingIDLogKey:  bindingID,
+					detailsLogKey:    details,
+					"role":           roleName,
+				})
+			}
+		}
+	}()
+
+	roleCredentials, err := b.role.AssumeRole(roleARN, bindingID)
+	if err != nil {
+		b.logger.Error("bind: error assuming role", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			detailsLogKey:    details,
+			"role":           roleName,
+		})
+		return binding, err
+	}
+
+	// Role permissions are evaluated on each request, so the replica and key
+	// policies can be attached after the session has started.
+	detachRolePolicy := func(policyARN string) error {
+		return b.role.DetachRolePolicy(roleName, policyARN)
+	}
+
+	if credentials.ReplicaBucket != "" {
+		var replicaPolicyARN string
+		replicaPolicyARN, err = b.attachReplicaPolicy(instanceID, bindingID, prefix, iamTags, func(policyARN string) error {
+			return b.role.AttachRolePolicy(roleName, policyARN)
+		})
+		if err != nil {
+			return binding, err
+		}
+		defer func() {
+			if err != nil {
+				b.detachPolicy(instanceID, bindingID, replicaPolicyARN, detachRolePolicy)
+			}
+		}()
+	}
+
+	if keyARN != "" {
+		var keyPolicyARN string
+		keyPolicyARN, err = b.attachKeyPolicy(instanceID, bindingID, keyARN, iamTags, func(policyARN string) error {
+			return b.role.AttachRolePolicy(roleName, policyARN)
+		})
+		if err != nil {
+			return binding, err
+		}
+		defer func() {
+			if err != nil {
+				b.detachPolicy(instanceID, bindingID, keyPolicyARN, detachRolePolicy)
+			}
+		}()
+	}
+
+	credentials.AccessKeyID = roleCredentials.AccessKeyID
+	credentials.SecretAccessKey = roleCredentials.SecretAccessKey
+	credentials.SessionToken = roleCredentials.SessionToken
+	credentials.RoleARN = roleARN
+	credentials.Expiration = roleCredentials.Expiration.UTC().Format(time.RFC3339)
+	credentials.URI = b.GetBucketURI(credentials)
+
+	binding.Credentials = credentials
+
+	return binding, nil
+}
+
+// bindingStrategyFor returns the binding strategy for bindings to instances
+// of servicePlan.
+func (b *S3Broker) bindingStrategyFor(servicePlan ServicePlan) string {
+	if servicePlan.S3Properties.BindingStrategy != "" {
+		return servicePlan.S3Properties.BindingStrategy
+	}
+	if b.bindingStrategy != "" {
+		return b.bindingStrategy
+	}
+	return BindingStrategyUser
+}
+
+// attachPolicy creates a policy for a binding in addition to its main policy
+// and attaches it with attach, deleting the policy again if that fails. It
+// returns the policy's ARN.
+func (b *S3Broker) attachPolicy(
+	instanceID string,
+	bindingID string,
+	policyName string,
+	policyTemplate string,
+	resources []string,
+	prefix string,
+	iamTags []*iam.Tag,
+	attach func(policyARN string) error,
+) (string, error) {
+	policyARN, err := b.user.CreatePolicy(
+		policyName,
+		b.iamPath,
+		policyTemplate,
+		resources,
+		prefix,
+		iamTags,
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := attach(policyARN); err != nil {
+		if derr := b.user.DeletePolicy(policyARN); derr != nil {
+			b.logger.Error("bind: error deleting policy", derr, lager.Data{
+				instanceIDLogKey: instanceID,
+				bindingIDLogKey:  bindingID,
+				"policy":         policyName,
+			})
+		}
+		return "", err
+	}
+	return policyARN, nil
+}
+
+// detachPolicy undoes attachPolicy when a later step of the binding fails,
+// detaching the policy with detach and deleting it. Errors are logged, since
+// the binding's own error is what is returned to the platform.
+func (b *S3Broker) detachPolicy(
+	instanceID string,
+	bindingID string,
+	policyARN string,
+	detach func(policyARN string) error,
+) {
+	if err := detach(policyARN); err != nil {
+		b.logger.Error("bind: defer: error detaching policy", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			"policy":         policyARN,
+		})
+		return
+	}
+	if err := b.user.DeletePolicy(policyARN); err != nil {
+		b.logger.Error("bind: defer: error deleting policy", err, lager.Data{
+			instanceIDLogKey: instanceID,
+			bindingIDLogKey:  bindingID,
+			"policy":         policyARN,
+		})
+	}
+}
+
+// expiresAtFor returns the RFC 3339 time at which a binding requested with the
+// expires_in parameter expires, or "" if expiresIn is empty.
+func expiresAtFor(expiresIn string) (string, error) {
+	if expiresIn == "" {
+		return "", nil
+	}
+	duration, err := time.ParseDuration(expiresIn)
+	if err != nil || duration <= 0 {
+		return "", fmt.Errorf("expires_in '%s' must be a positive duration, like \"72h\"", expiresIn)
+	}
+	return time.Now().Add(duration).UTC().Format(time.RFC3339), nil
+}
+
+// permissionsTagValue is the value of a binding's permissions tag. Bindings
+// without requested permissions use the plan's iam_policy.
+func permissionsTagValue(permissions string) string {
+	if permissions == "" {
+		return "default"
+	}
+	return permissions
+}
+
+// normalizePrefix checks that prefix can be used to scope an IAM policy and
+// ensures that it ends with a slash, so that "tenant-x" does not also grant
+// access to "tenant-xyz/".
+func normalizePrefix(prefix string) (string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(prefix, "*?$") {
+		return "", fmt.Errorf("Prefix '%s' must not contain '*', '?' or '$'", prefix)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix, nil
+}
+
 func (b *S3Broker) Unbind(
 	context context.Context,
 	instanceID,
@@ -516,6 +1077,11 @@ func (b *S3Broker) Unbind(
 		return domain.UnbindSpec{}, err
 	}
 	if !exists {
+		// The binding may have been created with the role strategy. Check
+		// regardless of the current configuration, which may have changed.
+		if b.role != nil {
+			return domain.UnbindSpec{}, b.deleteRole(userName)
+		}
 		return domain.UnbindSpec{}, nil
 	}
 
@@ -552,6 +1118,39 @@ func (b *S3Broker) Unbind(
 	return domain.UnbindSpec{}, nil
 }
 
+// deleteRole deletes a role, such as the role for a role binding, along with
+// its policies. It does nothing if the role does not exist.
+func (b *S3Broker) deleteRole(roleName string) error {
+	exists, err := b.role.Exists(roleName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	rolePolicies, err := b.role.ListAttachedRolePolicies(roleName, b.iamPath)
+	if b.handleUnbindError(err) != nil {
+		return err
+	}
+
+	for _, rolePolicy := range rolePolicies {
+		if err := b.role.DetachRolePolicy(roleName, rolePolicy); err != nil {
+			return err
+		}
+
+		if err := b.user.DeletePolicy(rolePolicy); err != nil {
+			return err
+		}
+	}
+
+	if err := b.role.Delete(roleName); b.handleUnbindError(err) != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (b *S3Broker) LastOperation(
 	ctx context.Context,
 	instanceID string,
@@ -559,8 +1158,48 @@ func (b *S3Broker) LastOperation(
 ) (domain.LastOperation, error) {
 	b.logger.Debug("last-operation", lager.Data{
 		instanceIDLogKey: instanceID,
+		detailsLogKey:    details,
 	})
-	return domain.LastOperation{}, errors.New("this broker does not support LastOperation")
+
+	operation, ok := b.operations.get(instanceID, details.OperationData)
+	if !ok && details.OperationData == deprovisionOperation {
+		// Deleting objects is idempotent, so a deprovision interrupted by a
+		// restart picks up where it left off.
+		b.logger.Info("last-operation: resuming deprovision", lager.Data{
+			instanceIDLogKey: instanceID,
+		})
+		servicePlan, found := b.catalog.FindServicePlan(details.PlanID)
+		if !found {
+			return domain.LastOperation{}, fmt.Errorf("Service Plan '%s' not found", details.PlanID)
+		}
+		if err := b.emptyAndDeleteBucket(instanceID, servicePlan); err != nil {
+			return domain.LastOperation{}, err
+		}
+		operation, ok = b.operations.get(instanceID, deprovisionOperation)
+	}
+	if !ok && details.OperationData == provisionOperation {
+		// Operations are lost on restart. Creating the bucket tags it as
+		// configured once every setting is applied, so only a bucket with that
+		// tag finished provisioning; a missing or half configured bucket means
+		// the provision was interrupted.
+		bucketDetails, err := b.bucket.Inspect(b.bucketName(instanceID), b.awsPartition)
+		if err != nil && err != awss3.ErrBucketDoesNotExist {
+			return domain.LastOperation{}, err
+		}
+		if err == nil && bucketDetails.Tags[awss3.ConfiguredTagKey] != "" {
+			return domain.LastOperation{State: domain.Succeeded, Description: "Bucket created"}, nil
+		}
+	}
+	if !ok {
+		// Operations are only tracked in memory, so a restart while an
+		// operation was running loses its state.
+		return domain.LastOperation{
+			State:       domain.Failed,
+			Description: fmt.Sprintf("No record of an operation for instance %s; the broker may have restarted", instanceID),
+		}, nil
+	}
+
+	return operation, nil
 }
 
 func (b *S3Broker) GetBinding(
@@ -571,8 +1210,137 @@ func (b *S3Broker) GetBinding(
 ) (domain.GetBindingSpec, error) {
 	b.logger.Debug("get-binding", lager.Data{
 		instanceIDLogKey: instanceID,
+		bindingIDLogKey:  bindingID,
+		detailsLogKey:    details,
 	})
-	return domain.GetBindingSpec{}, errors.New("this broker does not support GetBinding")
+
+	userName := b.userName(bindingID)
+	exists, err := b.user.Exists(userName)
+	if err != nil {
+		return domain.GetBindingSpec{}, err
+	}
+
+	var accessKeys, policies []string
+	var bindingStrategy, expiresAt string
+	if exists {
+		bindingStrategy = BindingStrategyUser
+		userDetails, err := b.user.Describe(userName)
+		if err != nil {
+			return domain.GetBindingSpec{}, err
+		}
+		expiresAt = userDetails.Tags[awsiam.ExpiresAtTagKey]
+		if accessKeys, err = b.user.ListAccessKeys(userName); err != nil {
+			return domain.GetBindingSpec{}, err
+		}
+		if policies, err = b.user.ListAttachedUserPolicies(userName, b.iamPath); err != nil {
+			return domain.GetBindingSpec{}, err
+		}
+	} else if b.role != nil {
+		if exists, err = b.role.Exists(userName); err != nil {
+			return domain.GetBindingSpec{}, err
+		}
+		bindingStrategy = BindingStrategyRole
+		if exists {
+			if policies, err = b.role.ListAttachedRolePolicies(userName, b.iamPath); err != nil {
+				return domain.GetBindingSpec{}, err
+			}
+		}
+	}
+	if !exists {
+		return domain.GetBindingSpec{}, apiresponses.ErrBindingNotFound
+	}
+
+	bucketDetails, err := b.bucket.Describe(b.bucketName(instanceID), b.awsPartition)
+	if err != nil {
+		return domain.GetBindingSpec{}, err
+	}
+
+	additionalBuckets := []string{}
+	var replicaBucket string
+	for _, policy := range policies {
+		document, err := b.user.GetPolicyDocument(policy)
+		if err != nil {
+			return domain.GetBindingSpec{}, err
+		}
+		bucketNames, err := policyBucketNames(document)
+		if err != nil {
+			return domain.GetBindingSpec{}, err
+		}
+		for _, bucketName := range bucketNames {
+			if bucketName == b.replicaBucketName(instanceID) {
+				replicaBucket = bucketName
+				continue
+			}
+			if bucketName != bucketDetails.BucketName && !slices.Contains(additionalBuckets, bucketName) {
+				additionalBuckets = append(additionalBuckets, bucketName)
+			}
+		}
+	}
+
+	return domain.GetBindingSpec{
+		Parameters: BindingParameters{
+			Bucket:            bucketDetails.BucketName,
+			Region:            bucketDetails.Region,
+			Endpoint:          bucketDetails.FIPSEndpoint,
+			FIPSEndpoint:      bucketDetails.FIPSEndpoint,
+			AdditionalBuckets: additionalBuckets,
+			AccessKeyExists:   len(accessKeys) > 0,
+			BindingStrategy:   bindingStrategy,
+			ExpiresAt:         expiresAt,
+			ReplicaBucket:     replicaBucket,
+		},
+	}, nil
+}
+
+// policyBucketNames returns the names of the buckets referenced by the
+// resources of an IAM policy document, in the order they first appear.
+func policyBucketNames(document string) ([]string, error) {
+	var policy struct {
+		Statement json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return nil, err
+	}
+
+	// Statement and Resource may each be a single value or a list.
+	var statements []struct {
+		Resource json.RawMessage
+	}
+	if err := unmarshalOneOrMany(policy.Statement, &statements); err != nil {
+		return nil, err
+	}
+
+	var bucketNames []string
+	for _, statement := range statements {
+		var resources []string
+		if err := unmarshalOneOrMany(statement.Resource, &resources); err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			// Bucket ARNs have the form arn:partition:s3:::bucket[/key].
+			parts := strings.SplitN(resource, ":::", 2)
+			if len(parts) != 2 || !strings.HasSuffix(parts[0], ":s3") {
+				continue
+			}
+			bucketName, _, _ := strings.Cut(parts[1], "/")
+			if !slices.Contains(bucketNames, bucketName) {
+				bucketNames = append(bucketNames, bucketName)
+			}
+		}
+	}
+	return bucketNames, nil
+}
+
+// unmarshalOneOrMany unmarshals data, which is either a JSON list or a single
+// value, into the slice pointed to by v.
+func unmarshalOneOrMany(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if data[0] == '[' {
+		return json.Unmarshal(data, v)
+	}
+	return json.Unmarshal(append(append([]byte{'['}, data...), ']'), v)
 }
 
 func (b *S3Broker) GetInstance(
@@ -582,8 +1350,71 @@ func (b *S3Broker) GetInstance(
 ) (domain.GetInstanceDetailsSpec, error) {
 	b.logger.Debug("get-instance", lager.Data{
 		instanceIDLogKey: instanceID,
+		detailsLogKey:    details,
 	})
-	return domain.GetInstanceDetailsSpec{}, errors.New("this broker does not support GetInstance")
+
+	bucketDetails, err := b.bucket.Inspect(b.bucketName(instanceID), b.awsPartition)
+	if err != nil {
+		if err == awss3.ErrBucketDoesNotExist {
+			return domain.GetInstanceDetailsSpec{}, ErrInstanceNotFound
+		}
+		return domain.GetInstanceDetailsSpec{}, err
+	}
+
+	parameters := InstanceParameters{
+		Bucket:           bucketDetails.BucketName,
+		Region:           bucketDetails.Region,
+		ObjectOwnership:  bucketDetails.ObjectOwnership,
+		Versioning:       strings.ToLower(bucketDetails.Versioning),
+		Public:           bucketDetails.Public,
+		BucketPolicy:     bucketDetails.Policy,
+		Plan:             bucketDetails.Tags[brokertags.ServicePlanName],
+		OrganizationGUID: bucketDetails.Tags[brokertags.OrganizationGUIDTagKey],
+		SpaceGUID:        bucketDetails.Tags[brokertags.SpaceGUIDTagKey],
+	}
+	if len(bucketDetails.Lifecycle) > 0 {
+		parameters.Lifecycle = json.RawMessage(bucketDetails.Lifecycle)
+	}
+	if len(bucketDetails.CORS) > 0 {
+		parameters.CORS = json.RawMessage(bucketDetails.CORS)
+	}
+	if len(bucketDetails.Website) > 0 {
+		parameters.Website = json.RawMessage(bucketDetails.Website)
+	}
+	if len(bucketDetails.ObjectLock) > 0 {
+		parameters.ObjectLock = json.RawMessage(bucketDetails.ObjectLock)
+	}
+	if len(bucketDetails.Replication) > 0 {
+		parameters.Replication = json.RawMessage(bucketDetails.Replication)
+	}
+	if len(bucketDetails.Encryption) > 0 {
+		var encryption s3.ServerSideEncryptionConfiguration
+		if err := json.Unmarshal([]byte(bucketDetails.Encryption), &encryption); err != nil {
+			return domain.GetInstanceDetailsSpec{}, err
+		}
+		for _, rule := range encryption.Rules {
+			if rule.ApplyServerSideEncryptionByDefault != nil {
+				parameters.Encryption = aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm)
+				parameters.KMSKeyID = aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID)
+			}
+		}
+	}
+
+	// The plan is recorded by name in the bucket tags; fall back to the plan
+	// the platform sent if it cannot be matched to the catalog.
+	planID := details.PlanID
+	for _, plan := range b.catalog.ListServicePlans() {
+		if plan.Name == parameters.Plan {
+			planID = plan.ID
+			break
+		}
+	}
+
+	return domain.GetInstanceDetailsSpec{
+		ServiceID:  details.ServiceID,
+		PlanID:     planID,
+		Parameters: parameters,
+	}, nil
 }
 
 func (b *S3Broker) LastBindingOperation(
@@ -618,20 +1449,29 @@ func (b *S3Broker) createBucket(
 ) (*awss3.BucketDetails, error) {
 	bucketDetails := b.bucketFromPlan(servicePlan)
 
+	if servicePlan.S3Properties.Replication != nil && b.replicaBucket == nil {
+		return nil, ErrNoReplicaRegionConfigured
+	}
+
+	if servicePlan.S3Properties.DedicatedKMSKey && b.key == nil {
+		return nil, ErrNoKMSConfigured
+	}
+
 	service, ok := b.catalog.FindService(details.ServiceID)
 	if !ok {
 		return nil, fmt.Errorf("Service '%s' not found", details.ServiceID)
 	}
 
+	guids := brokertags.ResourceGUIDs{
+		OrganizationGUID: details.OrganizationGUID,
+		SpaceGUID:        details.SpaceGUID,
+		InstanceGUID:     instanceID,
+	}
 	tags, err := b.tagManager.GenerateTags(
 		brokertags.Create,
 		service.Name,
 		servicePlan.Name,
-		brokertags.ResourceGUIDs{
-			OrganizationGUID: details.OrganizationGUID,
-			SpaceGUID:        details.SpaceGUID,
-			InstanceGUID:     instanceID,
-		},
+		guids,
 		false,
 	)
 	if err != nil {
@@ -639,20 +1479,156 @@ func (b *S3Broker) createBucket(
 	}
 	bucketDetails.Tags = tags
 
-	bucketDetails.Policy = string(servicePlan.S3Properties.BucketPolicy)
-	bucketDetails.Encryption = string(servicePlan.S3Properties.Encryption)
-	bucketDetails.AwsPartition = b.awsPartition
+	logging, err := b.accessLogging(guids)
+	if err != nil {
+		return nil, err
+	}
+	bucketDetails.Logging = logging
+
 	bucketDetails.ObjectOwnership = provisionParameters.ObjectOwnership
+
+	versioning, err := servicePlan.S3Properties.VersioningFor(provisionParameters.Versioning)
+	if err != nil {
+		return nil, err
+	}
+	bucketDetails.Versioning = versioning
+
+	lifecycle, err := lifecycleConfiguration(servicePlan.S3Properties, provisionParameters.Lifecycle)
+	if err != nil {
+		return nil, err
+	}
+	bucketDetails.Lifecycle = lifecycle
+
+	if len(provisionParameters.CORS) > 0 {
+		cors, err := corsConfiguration(servicePlan.S3Properties, provisionParameters.CORS)
+		if err != nil {
+			return nil, err
+		}
+		bucketDetails.CORS = cors
+	}
+
 	return bucketDetails, nil
 }
 
-func (b *S3Broker) modifyBucket(instanceID string, servicePlan ServicePlan, updateParameters UpdateParameters, details brokerapi.UpdateDetails) *awss3.BucketDetails {
+func (b *S3Broker) modifyBucket(
+	instanceID string,
+	servicePlan ServicePlan,
+	updateParameters UpdateParameters,
+	details brokerapi.UpdateDetails,
+) (*awss3.BucketDetails, error) {
 	bucketDetails := b.bucketFromPlan(servicePlan)
-	return bucketDetails
+
+	service, ok := b.catalog.FindService(details.ServiceID)
+	if !ok {
+		return nil, fmt.Errorf("Service '%s' not found", details.ServiceID)
+	}
+
+	guids := brokertags.ResourceGUIDs{
+		OrganizationGUID: details.PreviousValues.OrgID,
+		SpaceGUID:        details.PreviousValues.SpaceID,
+		InstanceGUID:     instanceID,
+	}
+	tags, err := b.tagManager.GenerateTags(
+		brokertags.Update,
+		service.Name,
+		servicePlan.Name,
+		guids,
+		true,
+	)
+	if err != nil {
+		return nil, err
+	}
+	bucketDetails.Tags = tags
+
+	logging, err := b.accessLogging(guids)
+	if err != nil {
+		return nil, err
+	}
+	bucketDetails.Logging = logging
+
+	if previousPlan, ok := b.catalog.FindServicePlan(details.PreviousValues.PlanID); ok {
+		// S3 cannot turn object lock off once a bucket has it.
+		if previousPlan.S3Properties.ObjectLock != nil && servicePlan.S3Properties.ObjectLock == nil {
+			return nil, errors.New("Object lock cannot be turned off. Choose a plan with object lock")
+		}
+		if (previousPlan.S3Properties.Replication != nil) != (servicePlan.S3Properties.Replication != nil) {
+			return nil, errors.New("Replication cannot be added or removed by changing plans")
+		}
+		if previousPlan.S3Properties.DedicatedKMSKey != servicePlan.S3Properties.DedicatedKMSKey {
+			return nil, errors.New("Dedicated KMS keys cannot be added or removed by changing plans")
+		}
+	}
+
+	versioning, err := servicePlan.S3Properties.VersioningFor(updateParameters.Versioning)
+	if err != nil {
+		return nil, err
+	}
+	bucketDetails.Versioning = versioning
+
+	// The user's lifecycle and CORS rules are kept unless they replace them.
+	// Modify removes configuration that is left out, so kept rules are carried
+	// over from the bucket. Lifecycle rules are combined again with the plan's
+	// default rules when the plan changes.
+	var current awss3.BucketDetails
+	if updateParameters.Lifecycle == nil || updateParameters.CORS == nil {
+		current, err = b.bucket.Inspect(b.bucketName(instanceID), b.awsPartition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if updateParameters.Lifecycle == nil && details.PlanID == details.PreviousValues.PlanID {
+		bucketDetails.Lifecycle = current.Lifecycle
+	} else {
+		var rules []LifecycleRule
+		if updateParameters.Lifecycle != nil {
+			rules = *updateParameters.Lifecycle
+		} else {
+			rules, err = userLifecycleRules(current.Lifecycle)
+			if err != nil {
+				return nil, err
+			}
+		}
+		lifecycle, err := lifecycleConfiguration(servicePlan.S3Properties, rules)
+		if err != nil {
+			if updateParameters.Lifecycle == nil {
+				return nil, fmt.Errorf("Your lifecycle rules cannot be kept on this plan: %s. Pass the lifecycle parameter with rules this plan allows", err)
+			}
+			return nil, err
+		}
+		if lifecycle == "" {
+			// A configuration without rules removes the bucket's lifecycle.
+			lifecycle = `{"Rules":[]}`
+		}
+		bucketDetails.Lifecycle = lifecycle
+	}
+
+	if updateParameters.CORS == nil {
+		bucketDetails.CORS = current.CORS
+	}
+	if updateParameters.CORS != nil {
+		cors, err := corsConfiguration(servicePlan.S3Properties, *updateParameters.CORS)
+		if err != nil {
+			return nil, err
+		}
+		bucketDetails.CORS = cors
+	}
+
+	return bucketDetails, nil
 }
 
 func (b *S3Broker) bucketFromPlan(servicePlan ServicePlan) *awss3.BucketDetails {
-	bucketDetails := &awss3.BucketDetails{}
+	bucketDetails := &awss3.BucketDetails{
+		Policy:       string(servicePlan.S3Properties.BucketPolicy),
+		Encryption:   string(servicePlan.S3Properties.Encryption),
+		AwsPartition: b.awsPartition,
+	}
+	if servicePlan.S3Properties.Website != nil {
+		bucketDetails.Website = servicePlan.S3Properties.Website.Configuration()
+	}
+	if servicePlan.S3Properties.ObjectLock != nil {
+		bucketDetails.ObjectLock = servicePlan.S3Properties.ObjectLock.Configuration()
+	}
 	return bucketDetails
 }
 
@@ -7,17 +7,22 @@ import (
 	"fmt"
 	"slices"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
 	brokertags "github.com/cloud-gov/go-broker-tags"
 	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awskms"
 	"github.com/cloud-gov/s3-broker/awss3"
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/pivotal-cf/brokerapi/v10"
 	"github.com/pivotal-cf/brokerapi/v10/domain"
+	"github.com/pivotal-cf/brokerapi/v10/domain/apiresponses"
 )
 
 type mockTagGenerator struct {
@@ -51,6 +56,17 @@ type mockBucket struct {
 
 	describeDetails awss3.BucketDetails
 	describeErr     error
+	createErr       error
+	deleteErr       error
+	emptyErr        error
+	modifyErr       error
+	emptyObjects    int
+	inspectDetails  awss3.BucketDetails
+	inspectErr      error
+	buckets         []string
+
+	// createdDetails, if set, receives the details of the bucket created.
+	createdDetails *awss3.BucketDetails
 }
 
 func (b mockBucket) Describe(bucketname, partition string) (awss3.BucketDetails, error) {
@@ -60,19 +76,43 @@ func (b mockBucket) Describe(bucketname, partition string) (awss3.BucketDetails,
 	return b.describeDetails, nil
 }
 
+func (b mockBucket) Inspect(bucketname, partition string) (awss3.BucketDetails, error) {
+	if b.inspectErr != nil {
+		return awss3.BucketDetails{}, b.inspectErr
+	}
+	return b.inspectDetails, nil
+}
+
 func (b mockBucket) Create(bucketName string, details awss3.BucketDetails) (string, error) {
-	return "", errors.New("not implemented")
-	// b.name = bucketName
-	// b.arn = "aws:" + bucketName
-	// return
+	if b.createErr != nil {
+		return "", b.createErr
+	}
+	if b.createdDetails != nil {
+		*b.createdDetails = details
+	}
+	return "/" + bucketName, nil
 }
 
 func (b mockBucket) Modify(bucketName string, details awss3.BucketDetails) error {
-	return errors.New("not implemented")
+	return b.modifyErr
 }
 
 func (b mockBucket) Delete(bucketName string, deleteObjects bool) error {
-	return errors.New("not implemented")
+	return b.deleteErr
+}
+
+func (b mockBucket) List(prefix string) ([]string, error) {
+	return b.buckets, nil
+}
+
+func (b mockBucket) Empty(bucketName string, progress func(deleted int)) error {
+	if b.emptyErr != nil {
+		return b.emptyErr
+	}
+	for deleted := 1; deleted <= b.emptyObjects; deleted++ {
+		progress(deleted)
+	}
+	return nil
 }
 
 type mockCatalog struct {
@@ -102,10 +142,28 @@ func (c mockCatalog) FindServicePlan(planID string) (plan ServicePlan, found boo
 	}, true
 }
 
+func (c mockCatalog) FindServiceForPlan(planID string) (service Service, found bool) {
+	return c.FindService("")
+}
+
 func (c mockCatalog) ListServicePlans() []ServicePlan {
 	return nil
 }
 
+// mockPlanCatalog returns plan for every plan ID.
+type mockPlanCatalog struct {
+	mockCatalog
+	plan ServicePlan
+}
+
+func (c mockPlanCatalog) FindServicePlan(planID string) (plan ServicePlan, found bool) {
+	return c.plan, true
+}
+
+func (c mockPlanCatalog) ListServicePlans() []ServicePlan {
+	return []ServicePlan{c.plan}
+}
+
 type mockUser struct {
 	// In-memory state for tests.
 
@@ -117,10 +175,15 @@ type mockUser struct {
 	detachedPolicyArns   []string
 	exists               bool
 	policies             []string // ARNs
+	policyDocuments      map[string]string
 	users                []string
+	notFound             bool // Exists reports false when set
+	tags                 map[string]string
+	userTags             map[string]map[string]string // overrides tags per user
 
 	// Methods return these errors when set.
 	attachUserPolicyErr         error
+	attachUserPolicyErrs        map[string]error // by policy ARN
 	createAccessKeyErr          error
 	createPolicyErr             error
 	createUserErr               error
@@ -165,6 +228,9 @@ func (u *mockUser) AttachUserPolicy(userName, policyARN string) error {
 	if u.attachUserPolicyErr != nil {
 		return u.attachUserPolicyErr
 	}
+	if err := u.attachUserPolicyErrs[policyARN]; err != nil {
+		return err
+	}
 	if u.attachedUserPolicies == nil {
 		u.attachedUserPolicies = []string{}
 	}
@@ -173,11 +239,26 @@ func (u *mockUser) AttachUserPolicy(userName, policyARN string) error {
 }
 
 func (u *mockUser) Exists(userName string) (bool, error) {
-	return true, nil
+	return !u.notFound, nil
+}
+
+func (u *mockUser) GetPolicyDocument(policyARN string) (string, error) {
+	document, ok := u.policyDocuments[policyARN]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return document, nil
 }
 
 func (u *mockUser) Describe(userName string) (awsiam.UserDetails, error) {
-	return awsiam.UserDetails{}, nil
+	if tags, ok := u.userTags[userName]; ok {
+		return awsiam.UserDetails{UserName: userName, Tags: tags}, nil
+	}
+	return awsiam.UserDetails{Tags: u.tags}, nil
+}
+
+func (u *mockUser) List(iamPath string) ([]string, error) {
+	return u.users, nil
 }
 
 func (u *mockUser) Create(userName, iamPath string, iamTags []*iam.Tag) (string, error) {
@@ -217,10 +298,13 @@ func (u *mockUser) DeleteAccessKey(userName, accessKeyID string) error {
 	return nil
 }
 
-func (u *mockUser) CreatePolicy(policyName, iamPath, policyTemplate string, resources []string, iamTags []*iam.Tag) (string, error) {
+func (u *mockUser) CreatePolicy(policyName, iamPath, policyTemplate string, resources []string, prefix string, iamTags []*iam.Tag) (string, error) {
 	if u.createPolicyErr != nil {
 		return "", u.createPolicyErr
 	}
+	if slices.Contains(u.policies, policyName) {
+		return "", awsiam.ErrEntityAlreadyExists
+	}
 	if u.policies == nil {
 		u.policies = []string{}
 	}
@@ -252,6 +336,100 @@ func (u *mockUser) DetachUserPolicy(userName, policyARN string) error {
 	return nil
 }
 
+type mockRole struct {
+	// In-memory state for tests.
+	exists           bool
+	attachedPolicies []string
+
+	// Methods return these errors when set.
+	assumeRoleErr        error
+	attachRolePolicyErrs map[string]error // by policy ARN
+}
+
+func (r *mockRole) Exists(roleName string) (bool, error) {
+	return r.exists, nil
+}
+
+func (r *mockRole) Create(roleName, iamPath string, iamTags []*iam.Tag) (string, error) {
+	r.exists = true
+	return "arn:aws:iam::123456789012:role/" + roleName, nil
+}
+
+func (r *mockRole) CreateServiceRole(roleName, iamPath, service string, iamTags []*iam.Tag) (string, error) {
+	return r.Create(roleName, iamPath, iamTags)
+}
+
+func (r *mockRole) Delete(roleName string) error {
+	if len(r.attachedPolicies) > 0 {
+		return errors.New("role has attached policies")
+	}
+	r.exists = false
+	return nil
+}
+
+func (r *mockRole) ListAttachedRolePolicies(roleName, iamPath string) ([]string, error) {
+	return slices.Clone(r.attachedPolicies), nil
+}
+
+func (r *mockRole) AttachRolePolicy(roleName, policyARN string) error {
+	if err := r.attachRolePolicyErrs[policyARN]; err != nil {
+		return err
+	}
+	r.attachedPolicies = append(r.attachedPolicies, policyARN)
+	return nil
+}
+
+func (r *mockRole) DetachRolePolicy(roleName, policyARN string) error {
+	idx := slices.Index(r.attachedPolicies, policyARN)
+	if idx == -1 {
+		return errors.New("not found")
+	}
+	r.attachedPolicies = slices.Delete(r.attachedPolicies, idx, idx+1)
+	return nil
+}
+
+// mockKey stores key ARNs by alias.
+type mockKey struct {
+	keys      map[string]string
+	created   []string
+	scheduled []string
+}
+
+func (k *mockKey) Create(alias, description string, tags map[string]string) (string, error) {
+	if k.keys == nil {
+		k.keys = make(map[string]string)
+	}
+	k.keys[alias] = "arn:aws:kms:us-east-1:123456789012:key/" + alias
+	k.created = append(k.created, alias)
+	return k.keys[alias], nil
+}
+
+func (k *mockKey) Describe(alias string) (string, error) {
+	keyARN, ok := k.keys[alias]
+	if !ok {
+		return "", awskms.ErrKeyDoesNotExist
+	}
+	return keyARN, nil
+}
+
+func (k *mockKey) ScheduleDeletion(alias string, pendingWindowInDays int64) error {
+	delete(k.keys, alias)
+	k.scheduled = append(k.scheduled, alias)
+	return nil
+}
+
+func (r *mockRole) AssumeRole(roleARN, sessionName string) (awsiam.RoleCredentials, error) {
+	if r.assumeRoleErr != nil {
+		return awsiam.RoleCredentials{}, r.assumeRoleErr
+	}
+	return awsiam.RoleCredentials{
+		AccessKeyID:     "ASIAEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+	}, nil
+}
+
 func TestCreateBucket(t *testing.T) {
 	testCases := map[string]struct {
 		broker              *S3Broker
@@ -298,6 +476,71 @@ func TestCreateBucket(t *testing.T) {
 				},
 			},
 		},
+		"access logging": {
+			broker: &S3Broker{
+				awsPartition:    "gov",
+				accessLogBucket: "audit-logs",
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			instanceID: "instance-1",
+			provisionDetails: brokerapi.ProvisionDetails{
+				OrganizationGUID: "org-1",
+				SpaceGUID:        "space-1",
+			},
+			expectedDetails: &awss3.BucketDetails{
+				AwsPartition: "gov",
+				Logging:      `{"TargetBucket":"audit-logs","TargetGrants":null,"TargetObjectKeyFormat":null,"TargetPrefix":"org-1/space-1/instance-1/"}`,
+			},
+		},
+		"versioning": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			provisionParameters: ProvisionParameters{
+				Versioning: "enabled",
+			},
+			provisionDetails: brokerapi.ProvisionDetails{},
+			expectedDetails: &awss3.BucketDetails{
+				AwsPartition: "gov",
+				Versioning:   "Enabled",
+			},
+		},
+		"versioning required by plan": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+				S3Properties: S3Properties{
+					RequireVersioning: true,
+				},
+			},
+			provisionParameters: ProvisionParameters{
+				Versioning: "suspended",
+			},
+			provisionDetails: brokerapi.ProvisionDetails{},
+			expectErr:        true,
+		},
 		"service not found": {
 			broker: &S3Broker{
 				awsPartition: "gov",
@@ -373,128 +616,864 @@ func TestCreateBucket(t *testing.T) {
 	}
 }
 
-func TestUnbind(t *testing.T) {
-	logger := lager.NewLogger("broker-unit-test-TestUnbind")
-	listAccessKeysErr := errors.New("list access keys error")
-	deleteAccessKeyErr := errors.New("delete access key error")
-	listAttachedUserPoliciesErr := errors.New("listing user policies error")
-	detachUserPolicyErr := errors.New("detach user policy error")
-	deleteUserPolicyErr := errors.New("delete user policy error")
-	deleteUserErr := errors.New("delete user error")
-	noSuchEntityErr := awserr.New("NoSuchEntity", "user does not exist", errors.New("original error"))
+func TestProvision(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestProvision")
 
 	testCases := map[string]struct {
-		instanceId               string
-		bindingId                string
-		unbindDetails            domain.UnbindDetails
-		broker                   *S3Broker
-		expectedErr              error
-		expectAccessKeys         map[string][]string
-		expectDetachedPolicyArns []string
-		expectPolicyARNs         []string
-		expectUnbindSpec         domain.UnbindSpec
+		broker              *S3Broker
+		asyncAllowed        bool
+		expectSpec          domain.ProvisionedServiceSpec
+		expectErr           error
+		expectLastOperation domain.LastOperation
 	}{
-		"success": {
-			instanceId:    "fake-instance-id",
-			bindingId:     "fake-binding-id",
-			unbindDetails: domain.UnbindDetails{},
+		"synchronous success": {
 			broker: &S3Broker{
-				logger: logger,
-				user:   &mockUser{},
+				logger:     logger,
+				bucket:     &mockBucket{},
+				catalog:    &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager: &mockTagGenerator{},
+			},
+			expectSpec: domain.ProvisionedServiceSpec{IsAsync: false},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
 			},
-			expectUnbindSpec: domain.UnbindSpec{},
 		},
-		"NoSuchEntity error when deleting user": {
-			instanceId:    "fake-instance-id",
-			bindingId:     "deleted-1",
-			unbindDetails: domain.UnbindDetails{},
+		"synchronous failure": {
+			broker: &S3Broker{
+				logger:     logger,
+				bucket:     &mockBucket{createErr: NewTestErr("BucketAlreadyExists: taken")},
+				catalog:    &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager: &mockTagGenerator{},
+			},
+			expectSpec: domain.ProvisionedServiceSpec{},
+			expectErr:  NewTestErr("BucketAlreadyExists: taken"),
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
+			},
+		},
+		"asynchronous success": {
+			broker: &S3Broker{
+				logger:     logger,
+				bucket:     &mockBucket{},
+				catalog:    &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager: &mockTagGenerator{},
+			},
+			asyncAllowed: true,
+			expectSpec:   domain.ProvisionedServiceSpec{IsAsync: true, OperationData: provisionOperation},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Succeeded,
+				Description: "Bucket created",
+			},
+		},
+		"asynchronous failure": {
+			broker: &S3Broker{
+				logger:     logger,
+				bucket:     &mockBucket{createErr: NewTestErr("BucketAlreadyExists: taken")},
+				catalog:    &mockCatalog{planName: "plan1", serviceName: "service1"},
+				tagManager: &mockTagGenerator{},
+			},
+			asyncAllowed: true,
+			expectSpec:   domain.ProvisionedServiceSpec{IsAsync: true, OperationData: provisionOperation},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "BucketAlreadyExists: taken",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			spec, err := tc.broker.Provision(context.Background(), "instance1", domain.ProvisionDetails{}, tc.asyncAllowed)
+			if !cmp.Equal(tc.expectSpec, spec) {
+				t.Fatal(cmp.Diff(spec, tc.expectSpec))
+			}
+			if !errors.Is(tc.expectErr, err) {
+				t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+			}
+
+			lastOperation := waitForLastOperation(t, tc.broker, "instance1", domain.PollDetails{OperationData: spec.OperationData})
+			if !cmp.Equal(tc.expectLastOperation, lastOperation) {
+				t.Fatal(cmp.Diff(lastOperation, tc.expectLastOperation))
+			}
+		})
+	}
+}
+
+// waitForLastOperation polls LastOperation until the operation for instanceID
+// leaves the in-progress state or a second passes.
+func waitForLastOperation(t *testing.T, b *S3Broker, instanceID string, details domain.PollDetails) domain.LastOperation {
+	var lastOperation domain.LastOperation
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		lastOperation, err = b.LastOperation(context.Background(), instanceID, details)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastOperation.State != domain.InProgress {
+			break
+		}
+	}
+	return lastOperation
+}
+
+func TestDeprovision(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestDeprovision")
+
+	testCases := map[string]struct {
+		broker              *S3Broker
+		asyncAllowed        bool
+		deletable           bool
+		provisioning        bool
+		expectSpec          domain.DeprovisionServiceSpec
+		expectErr           error
+		expectLastOperation domain.LastOperation
+	}{
+		"synchronous when the plan is not deletable": {
 			broker: &S3Broker{
 				logger: logger,
-				user: &mockUser{
-					deleteUserErr: noSuchEntityErr,
-				},
-				userPrefix: "test-user",
+				bucket: &mockBucket{},
+			},
+			asyncAllowed: true,
+			expectSpec:   domain.DeprovisionServiceSpec{IsAsync: false},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
 			},
-			expectUnbindSpec: domain.UnbindSpec{},
 		},
-		"unexpected error deleting user": {
-			instanceId:    "fake-instance-id",
-			bindingId:     "deleted-1",
-			unbindDetails: domain.UnbindDetails{},
+		"synchronous failure": {
 			broker: &S3Broker{
 				logger: logger,
-				user: &mockUser{
-					deleteUserErr: deleteUserErr,
-				},
+				bucket: &mockBucket{deleteErr: NewTestErr("BucketNotEmpty: not empty")},
+			},
+			deletable:  true,
+			expectSpec: domain.DeprovisionServiceSpec{},
+			expectErr:  NewTestErr("BucketNotEmpty: not empty"),
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
 			},
-			expectUnbindSpec: domain.UnbindSpec{},
-			expectedErr:      deleteUserErr,
 		},
-		"error listing access keys": {
-			instanceId:    "fake-instance-id",
-			bindingId:     "fake-binding-id",
-			unbindDetails: domain.UnbindDetails{},
+		"asynchronous success": {
 			broker: &S3Broker{
 				logger: logger,
-				user: &mockUser{
-					listAccessKeysErr: listAccessKeysErr,
-				},
+				bucket: &mockBucket{emptyObjects: 3},
+			},
+			asyncAllowed: true,
+			deletable:    true,
+			expectSpec:   domain.DeprovisionServiceSpec{IsAsync: true, OperationData: deprovisionOperation},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Succeeded,
+				Description: "Bucket deleted",
 			},
-			expectedErr:      listAccessKeysErr,
-			expectUnbindSpec: domain.UnbindSpec{},
 		},
-		"NoSuchEntity error listing access keys": {
-			instanceId:    "fake-instance-id",
-			bindingId:     "fake-binding-id",
-			unbindDetails: domain.UnbindDetails{},
+		"synchronous refusal with locked objects": {
 			broker: &S3Broker{
 				logger: logger,
-				user: &mockUser{
-					listAccessKeysErr: noSuchEntityErr,
-				},
+				bucket: &mockBucket{deleteErr: awss3.ErrBucketHasLockedObjects},
+			},
+			expectSpec: domain.DeprovisionServiceSpec{},
+			expectErr:  ErrInstanceHasLockedObjects,
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
 			},
-			expectUnbindSpec: domain.UnbindSpec{},
 		},
-		"deletes access keys": {
-			instanceId:    "fake-instance-id",
-			bindingId:     "binding-1",
-			unbindDetails: domain.UnbindDetails{},
+		"asynchronous refusal with locked objects": {
 			broker: &S3Broker{
 				logger: logger,
-				user: &mockUser{
-					accessKeys: map[string][]string{
-						"prefix-binding-1": {"key1", "key2"},
-					},
-				},
-				userPrefix: "prefix",
+				bucket: &mockBucket{emptyErr: awss3.ErrBucketHasLockedObjects},
+			},
+			asyncAllowed: true,
+			deletable:    true,
+			expectSpec:   domain.DeprovisionServiceSpec{IsAsync: true, OperationData: deprovisionOperation},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: ErrInstanceHasLockedObjects.Error(),
 			},
-			expectAccessKeys: map[string][]string{"prefix-binding-1": {}},
-			expectUnbindSpec: domain.UnbindSpec{},
 		},
-		"error deleting access key": {
-			instanceId:    "fake-instance-id",
-			bindingId:     "binding-1",
-			unbindDetails: domain.UnbindDetails{},
+		"synchronous refusal while provisioning": {
 			broker: &S3Broker{
 				logger: logger,
-				user: &mockUser{
-					accessKeys: map[string][]string{
-						"prefix-binding-1": {"key1"},
-					},
-					deleteAccessKeyErr: deleteAccessKeyErr,
-				},
-				userPrefix: "prefix",
+				bucket: &mockBucket{},
 			},
-			expectedErr: deleteAccessKeyErr,
-			expectAccessKeys: map[string][]string{
-				"prefix-binding-1": {"key1"},
+			provisioning: true,
+			expectSpec:   domain.DeprovisionServiceSpec{},
+			expectErr:    apiresponses.ErrConcurrentInstanceAccess,
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
 			},
-			expectUnbindSpec: domain.UnbindSpec{},
 		},
-		"error listing user policies": {
-			instanceId:    "fake-instance-id",
-			bindingId:     "binding-1",
-			unbindDetails: domain.UnbindDetails{},
+		"asynchronous refusal while provisioning": {
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{},
+			},
+			asyncAllowed: true,
+			deletable:    true,
+			provisioning: true,
+			expectSpec:   domain.DeprovisionServiceSpec{},
+			expectErr:    apiresponses.ErrConcurrentInstanceAccess,
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
+			},
+		},
+		"asynchronous failure emptying bucket": {
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{emptyErr: NewTestErr("AccessDenied: denied")},
+			},
+			asyncAllowed: true,
+			deletable:    true,
+			expectSpec:   domain.DeprovisionServiceSpec{IsAsync: true, OperationData: deprovisionOperation},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "AccessDenied: denied",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tc.broker.catalog = &mockPlanCatalog{plan: ServicePlan{Name: "plan1", PlanDeletable: tc.deletable}}
+			if tc.provisioning {
+				tc.broker.operations.start("instance1", provisionOperation, "Creating bucket")
+			}
+
+			spec, err := tc.broker.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{}, tc.asyncAllowed)
+			if !cmp.Equal(tc.expectSpec, spec) {
+				t.Fatal(cmp.Diff(spec, tc.expectSpec))
+			}
+			if !errors.Is(tc.expectErr, err) {
+				t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+			}
+
+			lastOperation := waitForLastOperation(t, tc.broker, "instance1", domain.PollDetails{OperationData: spec.OperationData})
+			if !cmp.Equal(tc.expectLastOperation, lastOperation) {
+				t.Fatal(cmp.Diff(lastOperation, tc.expectLastOperation))
+			}
+		})
+	}
+}
+
+func TestLastOperationResumesDeprovision(t *testing.T) {
+	b := &S3Broker{
+		logger:  lager.NewLogger("broker-unit-test-TestLastOperationResumesDeprovision"),
+		bucket:  &mockBucket{emptyObjects: 3},
+		catalog: &mockPlanCatalog{},
+	}
+
+	lastOperation := waitForLastOperation(t, b, "instance1", domain.PollDetails{OperationData: deprovisionOperation})
+	expected := domain.LastOperation{State: domain.Succeeded, Description: "Bucket deleted"}
+	if !cmp.Equal(expected, lastOperation) {
+		t.Fatal(cmp.Diff(lastOperation, expected))
+	}
+}
+
+func TestLastOperationResumingDeprovisionOfUnknownPlan(t *testing.T) {
+	b := &S3Broker{
+		logger:  lager.NewLogger("broker-unit-test-TestLastOperationResumingDeprovisionOfUnknownPlan"),
+		bucket:  &mockBucket{},
+		catalog: &mockCatalog{},
+	}
+
+	_, err := b.LastOperation(context.Background(), "instance1", domain.PollDetails{PlanID: "plan1", OperationData: deprovisionOperation})
+	if err == nil || err.Error() != "Service Plan 'plan1' not found" {
+		t.Fatalf("expected plan not found error, got %v", err)
+	}
+	if _, ok := b.operations.get("instance1", deprovisionOperation); ok {
+		t.Fatal("expected no deprovision to be started")
+	}
+}
+
+func TestOperationStore(t *testing.T) {
+	var operations operationStore
+
+	if _, ok := operations.startUnlessRunning("instance1", provisionOperation, "Creating bucket"); !ok {
+		t.Fatal("expected provision to start")
+	}
+	if running, ok := operations.startUnlessRunning("instance1", deprovisionOperation, "Deleting bucket contents"); ok || running != provisionOperation {
+		t.Fatalf("expected deprovision to be refused while provisioning, got %s, %t", running, ok)
+	}
+
+	operations.finish("instance1", provisionOperation, "Bucket created", nil)
+	if _, ok := operations.get("instance1", deprovisionOperation); ok {
+		t.Fatal("expected a provision not to be reported as a deprovision")
+	}
+	if operation, ok := operations.get("instance1", provisionOperation); !ok || operation.State != domain.Succeeded {
+		t.Fatalf("expected succeeded provision, got %v, %t", operation, ok)
+	}
+	if _, ok := operations.startUnlessRunning("instance1", deprovisionOperation, "Deleting bucket contents"); !ok {
+		t.Fatal("expected deprovision to start once provisioning finished")
+	}
+}
+
+func TestLastOperationChecksProvisionedBucket(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestLastOperationChecksProvisionedBucket")
+
+	testCases := map[string]struct {
+		bucket              *mockBucket
+		expectLastOperation domain.LastOperation
+		expectErr           error
+	}{
+		"bucket configured": {
+			bucket: &mockBucket{inspectDetails: awss3.BucketDetails{
+				Tags: map[string]string{awss3.ConfiguredTagKey: "2024-01-01T00:00:00Z"},
+			}},
+			expectLastOperation: domain.LastOperation{State: domain.Succeeded, Description: "Bucket created"},
+		},
+		"bucket exists but is not configured": {
+			bucket: &mockBucket{inspectDetails: awss3.BucketDetails{
+				Tags: map[string]string{"Created at": "2024-01-01T00:00:00Z"},
+			}},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
+			},
+		},
+		"bucket does not exist": {
+			bucket: &mockBucket{inspectErr: awss3.ErrBucketDoesNotExist},
+			expectLastOperation: domain.LastOperation{
+				State:       domain.Failed,
+				Description: "No record of an operation for instance instance1; the broker may have restarted",
+			},
+		},
+		"error inspecting bucket": {
+			bucket:    &mockBucket{inspectErr: NewTestErr("AccessDenied: denied")},
+			expectErr: NewTestErr("AccessDenied: denied"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b := &S3Broker{logger: logger, bucket: tc.bucket}
+			lastOperation, err := b.LastOperation(context.Background(), "instance1", domain.PollDetails{OperationData: provisionOperation})
+			if !errors.Is(tc.expectErr, err) {
+				t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+			}
+			if !cmp.Equal(tc.expectLastOperation, lastOperation) {
+				t.Fatal(cmp.Diff(lastOperation, tc.expectLastOperation))
+			}
+		})
+	}
+}
+
+func TestGetInstance(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestGetInstance")
+	catalog := &mockPlanCatalog{plan: ServicePlan{ID: "public-id", Name: "public"}}
+
+	testCases := map[string]struct {
+		broker     *S3Broker
+		details    domain.FetchInstanceDetails
+		expectSpec domain.GetInstanceDetailsSpec
+		expectErr  error
+	}{
+		"bucket does not exist": {
+			broker: &S3Broker{
+				logger:  logger,
+				bucket:  &mockBucket{inspectErr: awss3.ErrBucketDoesNotExist},
+				catalog: catalog,
+			},
+			expectErr: ErrInstanceNotFound,
+		},
+		"error inspecting bucket": {
+			broker: &S3Broker{
+				logger:  logger,
+				bucket:  &mockBucket{inspectErr: NewTestErr("AccessDenied: denied")},
+				catalog: catalog,
+			},
+			expectErr: NewTestErr("AccessDenied: denied"),
+		},
+		"success": {
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{inspectDetails: awss3.BucketDetails{
+					BucketName:      "prefix-instance1",
+					Region:          "us-gov-west-1",
+					ObjectOwnership: "ObjectWriter",
+					Encryption:      `{"Rules":[{"ApplyServerSideEncryptionByDefault":{"SSEAlgorithm":"AES256"}}]}`,
+					Versioning:      "Enabled",
+					Policy:          "policy",
+					Public:          true,
+					Tags: map[string]string{
+						brokertags.ServicePlanName:        "public",
+						brokertags.OrganizationGUIDTagKey: "org1",
+						brokertags.SpaceGUIDTagKey:        "space1",
+					},
+				}},
+				catalog: catalog,
+			},
+			details: domain.FetchInstanceDetails{ServiceID: "service1"},
+			expectSpec: domain.GetInstanceDetailsSpec{
+				ServiceID: "service1",
+				PlanID:    "public-id",
+				Parameters: InstanceParameters{
+					Bucket:           "prefix-instance1",
+					Region:           "us-gov-west-1",
+					ObjectOwnership:  "ObjectWriter",
+					Encryption:       "AES256",
+					Versioning:       "enabled",
+					Public:           true,
+					BucketPolicy:     "policy",
+					Plan:             "public",
+					OrganizationGUID: "org1",
+					SpaceGUID:        "space1",
+				},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			spec, err := tc.broker.GetInstance(context.Background(), "instance1", tc.details)
+			if !cmp.Equal(tc.expectSpec, spec) {
+				t.Fatal(cmp.Diff(spec, tc.expectSpec))
+			}
+			if !errors.Is(tc.expectErr, err) {
+				t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestModifyBucket(t *testing.T) {
+	testCases := map[string]struct {
+		broker           *S3Broker
+		expectedDetails  *awss3.BucketDetails
+		servicePlan      ServicePlan
+		instanceID       string
+		updateParameters UpdateParameters
+		updateDetails    brokerapi.UpdateDetails
+		expectErr        bool
+	}{
+		"success": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket:       mockBucket{},
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{
+					serviceName: "service-1",
+					tags: map[string]string{
+						"Updated at": "now",
+					},
+				},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+				S3Properties: S3Properties{
+					BucketPolicy: "fake-policy",
+					Encryption:   "fake-encryption",
+				},
+			},
+			updateDetails: brokerapi.UpdateDetails{},
+			expectedDetails: &awss3.BucketDetails{
+				Policy:       "fake-policy",
+				Encryption:   "fake-encryption",
+				AwsPartition: "gov",
+				Tags: map[string]string{
+					"Updated at":   "now",
+					"service name": "service-1",
+				},
+			},
+		},
+		"turning off object lock": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket:       mockBucket{},
+				catalog: &mockPlanCatalog{
+					mockCatalog: mockCatalog{serviceName: "service-1"},
+					plan: ServicePlan{
+						ID: "plan-locked",
+						S3Properties: S3Properties{
+							ObjectLock: &ObjectLockProperties{Mode: ObjectLockCompliance, Years: 7},
+						},
+					},
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			updateDetails: brokerapi.UpdateDetails{
+				PlanID:         "plan-1",
+				PreviousValues: brokerapi.PreviousValues{PlanID: "plan-locked"},
+			},
+			expectErr: true,
+		},
+		"access logging with custom prefix": {
+			broker: &S3Broker{
+				awsPartition:    "gov",
+				bucket:          mockBucket{},
+				accessLogBucket: "audit-logs",
+				accessLogPrefix: "s3/{{.InstanceGUID}}/",
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			instanceID:    "instance-1",
+			updateDetails: brokerapi.UpdateDetails{},
+			expectedDetails: &awss3.BucketDetails{
+				AwsPartition: "gov",
+				Logging:      `{"TargetBucket":"audit-logs","TargetGrants":null,"TargetObjectKeyFormat":null,"TargetPrefix":"s3/instance-1/"}`,
+			},
+		},
+		"adding a dedicated kms key": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket:       mockBucket{},
+				catalog: &mockPlanCatalog{
+					mockCatalog: mockCatalog{serviceName: "service-1"},
+					plan:        ServicePlan{ID: "plan-shared-key"},
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:           "plan-1",
+				Name:         "plan",
+				S3Properties: S3Properties{DedicatedKMSKey: true},
+			},
+			updateDetails: brokerapi.UpdateDetails{
+				PlanID:         "plan-1",
+				PreviousValues: brokerapi.PreviousValues{PlanID: "plan-shared-key"},
+			},
+			expectErr: true,
+		},
+		"remove lifecycle rules": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket:       mockBucket{},
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			updateParameters: UpdateParameters{
+				Lifecycle: &[]LifecycleRule{},
+			},
+			updateDetails: brokerapi.UpdateDetails{},
+			expectedDetails: &awss3.BucketDetails{
+				AwsPartition: "gov",
+				Lifecycle:    `{"Rules":[]}`,
+			},
+		},
+		"keep the user's rules": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket: mockBucket{
+					inspectDetails: awss3.BucketDetails{
+						Lifecycle: `{"Rules":[{"ID":"tmp"}]}`,
+						CORS:      `{"CORSRules":[{"AllowedMethods":["PUT"]}]}`,
+					},
+				},
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			updateDetails: brokerapi.UpdateDetails{},
+			expectedDetails: &awss3.BucketDetails{
+				AwsPartition: "gov",
+				Lifecycle:    `{"Rules":[{"ID":"tmp"}]}`,
+				CORS:         `{"CORSRules":[{"AllowedMethods":["PUT"]}]}`,
+			},
+		},
+		"plan change drops the old plan's lifecycle rules and keeps cors rules": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket: mockBucket{
+					inspectDetails: awss3.BucketDetails{
+						Lifecycle: `{"Rules":[{"ID":"tmp"}]}`,
+						CORS:      `{"CORSRules":[{"AllowedMethods":["PUT"]}]}`,
+					},
+				},
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			updateDetails: brokerapi.UpdateDetails{
+				PlanID:         "plan-1",
+				PreviousValues: brokerapi.PreviousValues{PlanID: "plan-2"},
+			},
+			expectedDetails: &awss3.BucketDetails{
+				AwsPartition: "gov",
+				Lifecycle:    `{"Rules":[]}`,
+				CORS:         `{"CORSRules":[{"AllowedMethods":["PUT"]}]}`,
+			},
+		},
+		"plan change without lifecycle parameter keeps the user's rules": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket: mockBucket{
+					inspectDetails: awss3.BucketDetails{
+						Lifecycle: `{"Rules":[{"ID":"old-plan-rule","Status":"Enabled","Expiration":{"Days":30}},{"ID":"user-rule-1","Status":"Enabled","Filter":{"Prefix":"tmp/"},"Expiration":{"Days":7}}]}`,
+					},
+				},
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+				S3Properties: S3Properties{
+					Lifecycle:       `{"Rules":[{"ID":"abort-uploads","Status":"Enabled","Filter":{"Prefix":""},"AbortIncompleteMultipartUpload":{"DaysAfterInitiation":7}}]}`,
+					LifecycleLimits: &LifecycleLimits{},
+				},
+			},
+			updateDetails: brokerapi.UpdateDetails{
+				PlanID:         "plan-1",
+				PreviousValues: brokerapi.PreviousValues{PlanID: "plan-2"},
+			},
+			expectedDetails: &awss3.BucketDetails{
+				AwsPartition: "gov",
+				Lifecycle:    `{"Rules":[{"AbortIncompleteMultipartUpload":{"DaysAfterInitiation":7},"Expiration":null,"Filter":{"And":null,"ObjectSizeGreaterThan":null,"ObjectSizeLessThan":null,"Prefix":"","Tag":null},"ID":"abort-uploads","NoncurrentVersionExpiration":null,"NoncurrentVersionTransitions":null,"Prefix":null,"Status":"Enabled","Transitions":null},{"AbortIncompleteMultipartUpload":null,"Expiration":{"Date":null,"Days":7,"ExpiredObjectDeleteMarker":null},"Filter":{"And":null,"ObjectSizeGreaterThan":null,"ObjectSizeLessThan":null,"Prefix":"tmp/","Tag":null},"ID":"user-rule-1","NoncurrentVersionExpiration":null,"NoncurrentVersionTransitions":null,"Prefix":null,"Status":"Enabled","Transitions":null}]}`,
+			},
+		},
+		"plan change to a plan that does not allow the user's lifecycle rules": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket: mockBucket{
+					inspectDetails: awss3.BucketDetails{
+						Lifecycle: `{"Rules":[{"ID":"user-rule-1","Status":"Enabled","Filter":{"Prefix":"tmp/"},"Expiration":{"Days":7}}]}`,
+					},
+				},
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			updateDetails: brokerapi.UpdateDetails{
+				PlanID:         "plan-1",
+				PreviousValues: brokerapi.PreviousValues{PlanID: "plan-2"},
+			},
+			expectErr: true,
+		},
+		"bucket does not exist": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket:       mockBucket{inspectErr: awss3.ErrBucketDoesNotExist},
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			updateDetails: brokerapi.UpdateDetails{},
+			expectErr:     true,
+		},
+		"service not found": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket:       mockBucket{},
+				catalog:      &mockCatalog{},
+				tagManager:   &mockTagGenerator{},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			updateDetails: brokerapi.UpdateDetails{},
+			expectErr:     true,
+		},
+		"generate tags error": {
+			broker: &S3Broker{
+				awsPartition: "gov",
+				bucket:       mockBucket{},
+				catalog: &mockCatalog{
+					serviceName: "service-1",
+				},
+				tagManager: &mockTagGenerator{
+					generateErr: errors.New("generate tags error"),
+				},
+			},
+			servicePlan: ServicePlan{
+				ID:   "plan-1",
+				Name: "plan",
+			},
+			updateDetails: brokerapi.UpdateDetails{},
+			expectErr:     true,
+		},
+	}
+
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			details, err := test.broker.modifyBucket(
+				test.instanceID,
+				test.servicePlan,
+				test.updateParameters,
+				test.updateDetails,
+			)
+
+			if err != nil && !test.expectErr {
+				t.Fatal(err)
+			}
+
+			if test.expectErr && err == nil {
+				t.Fatalf("expected error, received nil")
+			}
+
+			if !cmp.Equal(details, test.expectedDetails) {
+				t.Error(cmp.Diff(details, test.expectedDetails))
+			}
+		})
+	}
+}
+
+func TestUnbind(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestUnbind")
+	listAccessKeysErr := errors.New("list access keys error")
+	deleteAccessKeyErr := errors.New("delete access key error")
+	listAttachedUserPoliciesErr := errors.New("listing user policies error")
+	detachUserPolicyErr := errors.New("detach user policy error")
+	deleteUserPolicyErr := errors.New("delete user policy error")
+	deleteUserErr := errors.New("delete user error")
+	noSuchEntityErr := awserr.New("NoSuchEntity", "user does not exist", errors.New("original error"))
+
+	testCases := map[string]struct {
+		instanceId               string
+		bindingId                string
+		unbindDetails            domain.UnbindDetails
+		broker                   *S3Broker
+		expectedErr              error
+		expectAccessKeys         map[string][]string
+		expectDetachedPolicyArns []string
+		expectPolicyARNs         []string
+		expectUnbindSpec         domain.UnbindSpec
+	}{
+		"success": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "fake-binding-id",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user:   &mockUser{},
+			},
+			expectUnbindSpec: domain.UnbindSpec{},
+		},
+		"NoSuchEntity error when deleting user": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "deleted-1",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					deleteUserErr: noSuchEntityErr,
+				},
+				userPrefix: "test-user",
+			},
+			expectUnbindSpec: domain.UnbindSpec{},
+		},
+		"unexpected error deleting user": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "deleted-1",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					deleteUserErr: deleteUserErr,
+				},
+			},
+			expectUnbindSpec: domain.UnbindSpec{},
+			expectedErr:      deleteUserErr,
+		},
+		"error listing access keys": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "fake-binding-id",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					listAccessKeysErr: listAccessKeysErr,
+				},
+			},
+			expectedErr:      listAccessKeysErr,
+			expectUnbindSpec: domain.UnbindSpec{},
+		},
+		"NoSuchEntity error listing access keys": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "fake-binding-id",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					listAccessKeysErr: noSuchEntityErr,
+				},
+			},
+			expectUnbindSpec: domain.UnbindSpec{},
+		},
+		"deletes access keys": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "binding-1",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					accessKeys: map[string][]string{
+						"prefix-binding-1": {"key1", "key2"},
+					},
+				},
+				userPrefix: "prefix",
+			},
+			expectAccessKeys: map[string][]string{"prefix-binding-1": {}},
+			expectUnbindSpec: domain.UnbindSpec{},
+		},
+		"error deleting access key": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "binding-1",
+			unbindDetails: domain.UnbindDetails{},
+			broker: &S3Broker{
+				logger: logger,
+				user: &mockUser{
+					accessKeys: map[string][]string{
+						"prefix-binding-1": {"key1"},
+					},
+					deleteAccessKeyErr: deleteAccessKeyErr,
+				},
+				userPrefix: "prefix",
+			},
+			expectedErr: deleteAccessKeyErr,
+			expectAccessKeys: map[string][]string{
+				"prefix-binding-1": {"key1"},
+			},
+			expectUnbindSpec: domain.UnbindSpec{},
+		},
+		"error listing user policies": {
+			instanceId:    "fake-instance-id",
+			bindingId:     "binding-1",
+			unbindDetails: domain.UnbindDetails{},
 			broker: &S3Broker{
 				logger: logger,
 				user: &mockUser{
@@ -683,6 +1662,48 @@ func TestBind(t *testing.T) {
 			expectBinding: domain.Binding{},
 			expectErr:     NewTestErr("Service 'service1' not found"),
 		},
+		"unsupported permissions": {
+			instanceId: "instance1",
+			bindingId:  "binding1",
+			bindDetails: domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(`{"permissions": "write-only"}`),
+			},
+			broker: &S3Broker{
+				logger: logger,
+				catalog: &mockPlanCatalog{
+					mockCatalog: mockCatalog{serviceName: "service1"},
+					plan: ServicePlan{
+						Name: "plan1",
+						S3Properties: S3Properties{
+							IamPolicy:   "read-write-policy",
+							IamPolicies: map[string]string{PermissionsReadOnly: "read-only-policy"},
+						},
+					},
+				},
+			},
+			expectBinding: domain.Binding{},
+			expectErr:     NewTestErr("This plan does not offer 'write-only' permissions"),
+		},
+		"invalid prefix": {
+			instanceId: "instance1",
+			bindingId:  "binding1",
+			bindDetails: domain.BindDetails{
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(`{"prefix": "tenant-*"}`),
+			},
+			broker: &S3Broker{
+				logger: logger,
+				catalog: &mockCatalog{
+					planName:    "plan1",
+					serviceName: "service1",
+				},
+			},
+			expectBinding: domain.Binding{},
+			expectErr:     NewTestErr("Prefix 'tenant-*' must not contain '*', '?' or '$'"),
+		},
 		"failed to create user": {
 			instanceId: "instance1",
 			bindingId:  "binding1",
@@ -733,7 +1754,136 @@ func TestBind(t *testing.T) {
 			expectErr:        NewTestErr("error creating access key"),
 			expectUserExists: false,
 		},
-		"failed to create policy": {
+		"failed to create policy": {
+			instanceId: "instance1",
+			bindingId:  "binding1",
+			bindDetails: domain.BindDetails{
+				PlanID:    "planid1",
+				ServiceID: "serviceid1",
+			},
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{
+					describeDetails: awss3.BucketDetails{},
+				},
+				bucketPrefix: "test",
+				catalog: &mockCatalog{
+					planName:    "plan1",
+					serviceName: "service1",
+				},
+				tagManager: &mockTagGenerator{},
+				user: &mockUser{
+					createPolicyErr: NewTestErr("error creating policy"),
+				},
+			},
+			expectAccessKeys: map[string][]string{"-binding1": {}},
+			expectBinding:    domain.Binding{},
+			expectErr:        NewTestErr("error creating policy"),
+			expectUserExists: false,
+			expectPolicies:   nil,
+		},
+		"failed to attach policy": {
+			instanceId: "instance1",
+			bindingId:  "binding1",
+			bindDetails: domain.BindDetails{
+				PlanID:    "planid1",
+				ServiceID: "serviceid1",
+			},
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{
+					describeDetails: awss3.BucketDetails{},
+				},
+				bucketPrefix: "test",
+				catalog: &mockCatalog{
+					planName:    "plan1",
+					serviceName: "service1",
+				},
+				tagManager: &mockTagGenerator{},
+				user: &mockUser{
+					attachUserPolicyErr: NewTestErr("error attaching policy"),
+				},
+			},
+			expectAccessKeys: map[string][]string{"-binding1": {}},
+			expectBinding:    domain.Binding{},
+			expectErr:        NewTestErr("error attaching policy"),
+			expectUserExists: false,
+			expectPolicies:   []string{},
+		},
+		"success": {
+			instanceId: "instance1",
+			bindingId:  "binding1",
+			bindDetails: domain.BindDetails{
+				PlanID:    "planid1",
+				ServiceID: "serviceid1",
+			},
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{
+					describeDetails: awss3.BucketDetails{},
+				},
+				bucketPrefix: "test",
+				catalog: &mockCatalog{
+					planName:    "plan1",
+					serviceName: "service1",
+				},
+				tagManager: &mockTagGenerator{},
+				user:       &mockUser{},
+			},
+			expectAccessKeys: map[string][]string{"-binding1": {"-binding1-0"}},
+			expectBinding: domain.Binding{
+				Credentials: Credentials{
+					URI:               "s3://-binding1-0:@/",
+					AccessKeyID:       "-binding1-0",
+					AdditionalBuckets: []string{""},
+				},
+			},
+			expectUserExists: true,
+			expectPolicies:   []string{"-binding1"},
+		},
+		"website plan": {
+			instanceId: "instance1",
+			bindingId:  "binding1",
+			bindDetails: domain.BindDetails{
+				PlanID:    "planid1",
+				ServiceID: "serviceid1",
+			},
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{
+					describeDetails: awss3.BucketDetails{
+						BucketName: "test-instance1",
+						Region:     "us-east-2",
+					},
+				},
+				bucketPrefix: "test",
+				catalog: &mockPlanCatalog{
+					mockCatalog: mockCatalog{serviceName: "service1"},
+					plan: ServicePlan{
+						Name: "plan1",
+						S3Properties: S3Properties{
+							Website: &WebsiteProperties{IndexDocument: "index.html"},
+						},
+					},
+				},
+				tagManager: &mockTagGenerator{},
+				user:       &mockUser{},
+			},
+			expectAccessKeys: map[string][]string{"-binding1": {"-binding1-0"}},
+			expectBinding: domain.Binding{
+				Credentials: Credentials{
+					URI:               "s3://-binding1-0:@/test-instance1",
+					AccessKeyID:       "-binding1-0",
+					Bucket:            "test-instance1",
+					Region:            "us-east-2",
+					AdditionalBuckets: []string{},
+					WebsiteURL:        "http://test-instance1.s3-website.us-east-2.amazonaws.com",
+				},
+			},
+			expectUserExists: true,
+			expectPolicies:   []string{"-binding1"},
+		},
+		"replicated plan": {
 			instanceId: "instance1",
 			bindingId:  "binding1",
 			bindDetails: domain.BindDetails{
@@ -743,25 +1893,41 @@ func TestBind(t *testing.T) {
 			broker: &S3Broker{
 				logger: logger,
 				bucket: &mockBucket{
-					describeDetails: awss3.BucketDetails{},
+					describeDetails: awss3.BucketDetails{
+						BucketName: "test-instance1",
+						Region:     "us-east-1",
+					},
 				},
-				bucketPrefix: "test",
-				catalog: &mockCatalog{
-					planName:    "plan1",
-					serviceName: "service1",
+				bucketPrefix:  "test",
+				replicaRegion: "us-west-2",
+				catalog: &mockPlanCatalog{
+					mockCatalog: mockCatalog{serviceName: "service1"},
+					plan: ServicePlan{
+						Name: "plan1",
+						S3Properties: S3Properties{
+							Replication: &ReplicationProperties{},
+						},
+					},
 				},
 				tagManager: &mockTagGenerator{},
-				user: &mockUser{
-					createPolicyErr: NewTestErr("error creating policy"),
+				user:       &mockUser{},
+			},
+			expectAccessKeys: map[string][]string{"-binding1": {"-binding1-0"}},
+			expectBinding: domain.Binding{
+				Credentials: Credentials{
+					URI:               "s3://-binding1-0:@/test-instance1",
+					AccessKeyID:       "-binding1-0",
+					Bucket:            "test-instance1",
+					Region:            "us-east-1",
+					AdditionalBuckets: []string{},
+					ReplicaBucket:     "test-instance1-replica",
+					ReplicaRegion:     "us-west-2",
 				},
 			},
-			expectAccessKeys: map[string][]string{"-binding1": {}},
-			expectBinding:    domain.Binding{},
-			expectErr:        NewTestErr("error creating policy"),
-			expectUserExists: false,
-			expectPolicies:   nil,
+			expectUserExists: true,
+			expectPolicies:   []string{"-binding1", "-binding1-replica"},
 		},
-		"failed to attach policy": {
+		"plan with dedicated kms key": {
 			instanceId: "instance1",
 			bindingId:  "binding1",
 			bindDetails: domain.BindDetails{
@@ -771,30 +1937,45 @@ func TestBind(t *testing.T) {
 			broker: &S3Broker{
 				logger: logger,
 				bucket: &mockBucket{
-					describeDetails: awss3.BucketDetails{},
+					describeDetails: awss3.BucketDetails{
+						BucketName: "test-instance1",
+						Region:     "us-east-1",
+					},
 				},
 				bucketPrefix: "test",
-				catalog: &mockCatalog{
-					planName:    "plan1",
-					serviceName: "service1",
+				catalog: &mockPlanCatalog{
+					mockCatalog: mockCatalog{serviceName: "service1"},
+					plan: ServicePlan{
+						Name:         "plan1",
+						S3Properties: S3Properties{DedicatedKMSKey: true},
+					},
 				},
+				key: &mockKey{keys: map[string]string{
+					"alias/test-instance1": "arn:aws:kms:us-east-1:123456789012:key/1234",
+				}},
 				tagManager: &mockTagGenerator{},
-				user: &mockUser{
-					attachUserPolicyErr: NewTestErr("error attaching policy"),
+				user:       &mockUser{},
+			},
+			expectAccessKeys: map[string][]string{"-binding1": {"-binding1-0"}},
+			expectBinding: domain.Binding{
+				Credentials: Credentials{
+					URI:               "s3://-binding1-0:@/test-instance1",
+					AccessKeyID:       "-binding1-0",
+					Bucket:            "test-instance1",
+					Region:            "us-east-1",
+					AdditionalBuckets: []string{},
 				},
 			},
-			expectAccessKeys: map[string][]string{"-binding1": {}},
-			expectBinding:    domain.Binding{},
-			expectErr:        NewTestErr("error attaching policy"),
-			expectUserExists: false,
-			expectPolicies:   []string{},
+			expectUserExists: true,
+			expectPolicies:   []string{"-binding1", "-binding1-kms"},
 		},
-		"success": {
+		"success with prefix": {
 			instanceId: "instance1",
 			bindingId:  "binding1",
 			bindDetails: domain.BindDetails{
-				PlanID:    "planid1",
-				ServiceID: "serviceid1",
+				PlanID:        "planid1",
+				ServiceID:     "serviceid1",
+				RawParameters: json.RawMessage(`{"prefix": "tenant-x"}`),
 			},
 			broker: &S3Broker{
 				logger: logger,
@@ -815,6 +1996,7 @@ func TestBind(t *testing.T) {
 					URI:               "s3://-binding1-0:@/",
 					AccessKeyID:       "-binding1-0",
 					AdditionalBuckets: []string{""},
+					Prefix:            "tenant-x/",
 				},
 			},
 			expectUserExists: true,
@@ -849,3 +2031,565 @@ func TestBind(t *testing.T) {
 		})
 	}
 }
+
+func TestExpiresAtFor(t *testing.T) {
+	if expiresAt, err := expiresAtFor(""); err != nil || expiresAt != "" {
+		t.Fatalf("expected no expiry, got %q, %v", expiresAt, err)
+	}
+
+	for _, expiresIn := range []string{"soon", "0s", "-1h"} {
+		if _, err := expiresAtFor(expiresIn); err == nil {
+			t.Fatalf("expected error for expires_in %q", expiresIn)
+		}
+	}
+
+	before := time.Now().Add(72 * time.Hour).Truncate(time.Second)
+	expiresAt, err := expiresAtFor("72h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Before(before) || parsed.After(before.Add(time.Minute)) {
+		t.Fatalf("expected expiry about 72h from now, got %s", expiresAt)
+	}
+}
+
+func TestPermissionsTagValue(t *testing.T) {
+	if value := permissionsTagValue(""); value != "default" {
+		t.Fatalf("expected default permissions, got %q", value)
+	}
+	if value := permissionsTagValue(PermissionsReadOnly); value != PermissionsReadOnly {
+		t.Fatalf("expected %q, got %q", PermissionsReadOnly, value)
+	}
+}
+
+func TestGetBinding(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestGetBinding")
+	policyDocument := `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:ListBucket"], "Resource": ["arn:aws:s3:::prefix-instance1", "arn:aws:s3:::prefix-instance2"]},
+			{"Effect": "Allow", "Action": ["s3:GetObject"], "Resource": "arn:aws:s3:::prefix-instance2/*"}
+		]
+	}`
+
+	testCases := map[string]struct {
+		broker     *S3Broker
+		expectSpec domain.GetBindingSpec
+		expectErr  error
+	}{
+		"binding does not exist": {
+			broker: &S3Broker{
+				logger: logger,
+				user:   &mockUser{notFound: true},
+			},
+			expectErr: apiresponses.ErrBindingNotFound,
+		},
+		"error reading policy": {
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{},
+				user: &mockUser{
+					attachedUserPolicies: []string{"policy1"},
+				},
+			},
+			expectErr: NewTestErr("not found"),
+		},
+		"success": {
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{describeDetails: awss3.BucketDetails{
+					BucketName:   "prefix-instance1",
+					Region:       "us-gov-west-1",
+					FIPSEndpoint: "s3-fips.us-gov-west-1.amazonaws.com",
+				}},
+				user: &mockUser{
+					accessKeys:           map[string][]string{"-binding1": {"key1"}},
+					attachedUserPolicies: []string{"policy1"},
+					policyDocuments:      map[string]string{"policy1": policyDocument},
+					tags:                 map[string]string{awsiam.ExpiresAtTagKey: "2024-01-01T00:00:00Z"},
+				},
+			},
+			expectSpec: domain.GetBindingSpec{
+				Parameters: BindingParameters{
+					Bucket:            "prefix-instance1",
+					Region:            "us-gov-west-1",
+					Endpoint:          "s3-fips.us-gov-west-1.amazonaws.com",
+					FIPSEndpoint:      "s3-fips.us-gov-west-1.amazonaws.com",
+					AdditionalBuckets: []string{"prefix-instance2"},
+					AccessKeyExists:   true,
+					BindingStrategy:   BindingStrategyUser,
+					ExpiresAt:         "2024-01-01T00:00:00Z",
+				},
+			},
+		},
+		"role binding": {
+			broker: &S3Broker{
+				logger: logger,
+				bucket: &mockBucket{describeDetails: awss3.BucketDetails{
+					BucketName: "prefix-instance1",
+				}},
+				user: &mockUser{
+					notFound:        true,
+					policyDocuments: map[string]string{"policy1": policyDocument},
+				},
+				role: &mockRole{
+					exists:           true,
+					attachedPolicies: []string{"policy1"},
+				},
+			},
+			expectSpec: domain.GetBindingSpec{
+				Parameters: BindingParameters{
+					Bucket:            "prefix-instance1",
+					AdditionalBuckets: []string{"prefix-instance2"},
+					BindingStrategy:   BindingStrategyRole,
+				},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			spec, err := tc.broker.GetBinding(context.Background(), "instance1", "binding1", domain.FetchBindingDetails{})
+			if !cmp.Equal(tc.expectSpec, spec) {
+				t.Fatal(cmp.Diff(spec, tc.expectSpec))
+			}
+			if !errors.Is(tc.expectErr, err) {
+				t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestBindRole(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestBindRole")
+
+	testCases := map[string]struct {
+		role           *mockRole
+		expectBinding  domain.Binding
+		expectErr      error
+		expectExists   bool
+		expectPolicies []string
+	}{
+		"success": {
+			role: &mockRole{},
+			expectBinding: domain.Binding{
+				Credentials: Credentials{
+					URI:               "s3://ASIAEXAMPLE:secret@/",
+					AccessKeyID:       "ASIAEXAMPLE",
+					SecretAccessKey:   "secret",
+					SessionToken:      "token",
+					RoleARN:           "arn:aws:iam::123456789012:role/-binding1",
+					Expiration:        "2024-01-01T01:00:00Z",
+					AdditionalBuckets: []string{""},
+				},
+			},
+			expectExists:   true,
+			expectPolicies: []string{"-binding1"},
+		},
+		"error assuming role": {
+			role: &mockRole{
+				assumeRoleErr: errors.New("assume role error"),
+			},
+			expectErr:      NewTestErr("assume role error"),
+			expectPolicies: []string{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			user := &mockUser{}
+			broker := &S3Broker{
+				logger:          logger,
+				bucket:          &mockBucket{},
+				bucketPrefix:    "test",
+				bindingStrategy: BindingStrategyRole,
+				catalog: &mockCatalog{
+					planName:    "plan1",
+					serviceName: "service1",
+				},
+				tagManager: &mockTagGenerator{},
+				user:       user,
+				role:       tc.role,
+			}
+
+			binding, err := broker.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{}, false)
+			if !cmp.Equal(tc.expectBinding, binding) {
+				t.Fatal(cmp.Diff(binding, tc.expectBinding))
+			}
+			if !errors.Is(tc.expectErr, err) {
+				t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+			}
+			if tc.expectExists != tc.role.exists {
+				t.Fatalf("expected role exists %t, got %t", tc.expectExists, tc.role.exists)
+			}
+			if user.exists {
+				t.Fatal("expected no user to be created")
+			}
+			if !cmp.Equal(tc.expectPolicies, user.policies) {
+				t.Fatal(cmp.Diff(user.policies, tc.expectPolicies))
+			}
+		})
+	}
+}
+
+func TestUnbindRole(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestUnbindRole")
+	user := &mockUser{
+		notFound: true,
+		policies: []string{"policy1"},
+	}
+	role := &mockRole{
+		exists:           true,
+		attachedPolicies: []string{"policy1"},
+	}
+	broker := &S3Broker{
+		logger: logger,
+		user:   user,
+		role:   role,
+	}
+
+	if _, err := broker.Unbind(context.Background(), "instance1", "binding1", domain.UnbindDetails{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if role.exists {
+		t.Fatal("expected role to be deleted")
+	}
+	if len(user.policies) > 0 {
+		t.Fatalf("expected policies to be deleted, got %v", user.policies)
+	}
+}
+
+func TestCreateReplica(t *testing.T) {
+	role := &mockRole{}
+	user := &mockUser{}
+	var replicaDetails awss3.BucketDetails
+	broker := &S3Broker{
+		logger:                 lager.NewLogger("broker-unit-test-TestCreateReplica"),
+		awsPartition:           "aws",
+		bucketPrefix:           "test",
+		userPrefix:             "user",
+		policyPrefix:           "policy",
+		replicaBucket:          &mockBucket{createdDetails: &replicaDetails},
+		replicaAccessLogBucket: "replica-audit-logs",
+		role:                   role,
+		user:                   user,
+	}
+	servicePlan := ServicePlan{
+		S3Properties: S3Properties{
+			Replication: &ReplicationProperties{StorageClass: "STANDARD_IA"},
+		},
+	}
+
+	bucketDetails := &awss3.BucketDetails{
+		Logging: `{"TargetBucket":"audit-logs","TargetPrefix":"org1/space1/instance1/"}`,
+	}
+	if err := broker.createReplica("instance1", servicePlan, bucketDetails); err != nil {
+		t.Fatal(err)
+	}
+
+	if bucketDetails.Versioning != "Enabled" {
+		t.Errorf("expected versioning to be enabled, got %q", bucketDetails.Versioning)
+	}
+	var replication s3.ReplicationConfiguration
+	if err := json.Unmarshal([]byte(bucketDetails.Replication), &replication); err != nil {
+		t.Fatal(err)
+	}
+	if role := aws.StringValue(replication.Role); role != "arn:aws:iam::123456789012:role/user-instance1-replication" {
+		t.Errorf("unexpected replication role %s", role)
+	}
+	destination := replication.Rules[0].Destination
+	if bucket := aws.StringValue(destination.Bucket); bucket != "arn:aws:s3:::test-instance1-replica" {
+		t.Errorf("unexpected replica bucket %s", bucket)
+	}
+	if storageClass := aws.StringValue(destination.StorageClass); storageClass != "STANDARD_IA" {
+		t.Errorf("unexpected storage class %s", storageClass)
+	}
+	if !cmp.Equal(role.attachedPolicies, []string{"policy-instance1-replication"}) {
+		t.Error(cmp.Diff(role.attachedPolicies, []string{"policy-instance1-replication"}))
+	}
+	var logging s3.LoggingEnabled
+	if err := json.Unmarshal([]byte(replicaDetails.Logging), &logging); err != nil {
+		t.Fatal(err)
+	}
+	if bucket := aws.StringValue(logging.TargetBucket); bucket != "replica-audit-logs" {
+		t.Errorf("unexpected replica log bucket %s", bucket)
+	}
+	if prefix := aws.StringValue(logging.TargetPrefix); prefix != "replica/org1/space1/instance1/" {
+		t.Errorf("unexpected replica log prefix %s", prefix)
+	}
+}
+
+func TestCreateReplicaWithoutLogging(t *testing.T) {
+	var replicaDetails awss3.BucketDetails
+	broker := &S3Broker{
+		logger:                 lager.NewLogger("broker-unit-test-TestCreateReplicaWithoutLogging"),
+		awsPartition:           "aws",
+		bucketPrefix:           "test",
+		userPrefix:             "user",
+		policyPrefix:           "policy",
+		replicaBucket:          &mockBucket{createdDetails: &replicaDetails},
+		replicaAccessLogBucket: "replica-audit-logs",
+		role:                   &mockRole{},
+		user:                   &mockUser{},
+	}
+	servicePlan := ServicePlan{
+		S3Properties: S3Properties{Replication: &ReplicationProperties{}},
+	}
+
+	if err := broker.createReplica("instance1", servicePlan, &awss3.BucketDetails{}); err != nil {
+		t.Fatal(err)
+	}
+	if replicaDetails.Logging != "" {
+		t.Errorf("expected the replica not to be logged, got %s", replicaDetails.Logging)
+	}
+}
+
+func TestCreateReplicaRetry(t *testing.T) {
+	testCases := map[string]struct {
+		modifyErr error
+		expectErr error
+	}{
+		"reuses resources of the failed attempt": {},
+		"fails to configure the existing replica bucket": {
+			modifyErr: NewTestErr("AccessDenied: denied"),
+			expectErr: NewTestErr("AccessDenied: denied"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// An earlier attempt created the replica bucket, the replication
+			// role and its policy, and failed before attaching the policy.
+			role := &mockRole{exists: true}
+			user := &mockUser{policies: []string{"policy-instance1-replication"}}
+			broker := &S3Broker{
+				logger:       lager.NewLogger("broker-unit-test-TestCreateReplicaRetry"),
+				awsPartition: "aws",
+				bucketPrefix: "test",
+				userPrefix:   "user",
+				policyPrefix: "policy",
+				iamPath:      "/cf/",
+				replicaBucket: &mockBucket{
+					createErr: awss3.ErrBucketAlreadyOwned,
+					modifyErr: tc.modifyErr,
+				},
+				role: role,
+				user: user,
+			}
+			servicePlan := ServicePlan{
+				S3Properties: S3Properties{Replication: &ReplicationProperties{}},
+			}
+
+			err := broker.createReplica("instance1", servicePlan, &awss3.BucketDetails{})
+			if !errors.Is(tc.expectErr, err) {
+				t.Fatalf("expected err %s, got %s", tc.expectErr, err)
+			}
+			if tc.expectErr != nil {
+				return
+			}
+			expected := []string{"arn:aws:iam::123456789012:policy/cf/policy-instance1-replication"}
+			if !cmp.Equal(role.attachedPolicies, expected) {
+				t.Error(cmp.Diff(role.attachedPolicies, expected))
+			}
+		})
+	}
+}
+
+func TestBindPartialFailure(t *testing.T) {
+	logger := lager.NewLogger("broker-unit-test-TestBindPartialFailure")
+	servicePlan := ServicePlan{
+		Name: "plan1",
+		S3Properties: S3Properties{
+			Replication:     &ReplicationProperties{},
+			DedicatedKMSKey: true,
+		},
+	}
+	newBroker := func(bindingStrategy string, user *mockUser, role *mockRole) *S3Broker {
+		return &S3Broker{
+			logger: logger,
+			bucket: &mockBucket{
+				describeDetails: awss3.BucketDetails{BucketName: "test-instance1"},
+			},
+			bucketPrefix:    "test",
+			replicaRegion:   "us-west-2",
+			bindingStrategy: bindingStrategy,
+			catalog: &mockPlanCatalog{
+				mockCatalog: mockCatalog{serviceName: "service1"},
+				plan:        servicePlan,
+			},
+			key: &mockKey{keys: map[string]string{
+				"alias/test-instance1": "arn:aws:kms:us-east-1:123456789012:key/1234",
+			}},
+			tagManager: &mockTagGenerator{},
+			user:       user,
+			role:       role,
+		}
+	}
+
+	t.Run("user", func(t *testing.T) {
+		user := &mockUser{attachUserPolicyErrs: map[string]error{"-binding1-kms": NewTestErr("LimitExceeded: too many")}}
+		broker := newBroker(BindingStrategyUser, user, &mockRole{})
+
+		_, err := broker.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{}, false)
+		if !errors.Is(NewTestErr("LimitExceeded: too many"), err) {
+			t.Fatalf("expected attach error, got %s", err)
+		}
+		if len(user.policies) > 0 {
+			t.Fatalf("expected policies to be deleted, got %v", user.policies)
+		}
+		if !slices.Contains(user.detachedPolicyArns, "-binding1-replica") {
+			t.Fatalf("expected replica policy to be detached, got %v", user.detachedPolicyArns)
+		}
+
+		user.attachUserPolicyErrs = nil
+		if _, err := broker.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{}, false); err != nil {
+			t.Fatalf("expected retry to succeed, got %s", err)
+		}
+		expected := []string{"-binding1", "-binding1-replica", "-binding1-kms"}
+		if !cmp.Equal(user.policies, expected) {
+			t.Fatal(cmp.Diff(user.policies, expected))
+		}
+	})
+
+	t.Run("role", func(t *testing.T) {
+		user := &mockUser{}
+		role := &mockRole{attachRolePolicyErrs: map[string]error{"-binding1-kms": NewTestErr("LimitExceeded: too many")}}
+		broker := newBroker(BindingStrategyRole, user, role)
+
+		_, err := broker.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{}, false)
+		if !errors.Is(NewTestErr("LimitExceeded: too many"), err) {
+			t.Fatalf("expected attach error, got %s", err)
+		}
+		if len(role.attachedPolicies) > 0 {
+			t.Fatalf("expected policies to be detached, got %v", role.attachedPolicies)
+		}
+		if role.exists {
+			t.Fatal("expected role to be deleted")
+		}
+		if len(user.policies) > 0 {
+			t.Fatalf("expected policies to be deleted, got %v", user.policies)
+		}
+
+		role.attachRolePolicyErrs = nil
+		if _, err := broker.Bind(context.Background(), "instance1", "binding1", domain.BindDetails{}, false); err != nil {
+			t.Fatalf("expected retry to succeed, got %s", err)
+		}
+		expected := []string{"-binding1", "-binding1-replica", "-binding1-kms"}
+		if !cmp.Equal(role.attachedPolicies, expected) {
+			t.Fatal(cmp.Diff(role.attachedPolicies, expected))
+		}
+	})
+}
+
+func TestDeprovisionReplica(t *testing.T) {
+	user := &mockUser{policies: []string{"policy-instance1-replication"}}
+	role := &mockRole{exists: true, attachedPolicies: []string{"policy-instance1-replication"}}
+	broker := &S3Broker{
+		logger:        lager.NewLogger("broker-unit-test-TestDeprovisionReplica"),
+		bucket:        &mockBucket{},
+		replicaBucket: &mockBucket{},
+		role:          role,
+		user:          user,
+		catalog: &mockPlanCatalog{plan: ServicePlan{
+			S3Properties: S3Properties{Replication: &ReplicationProperties{}},
+		}},
+	}
+
+	if _, err := broker.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if role.exists {
+		t.Fatal("expected replication role to be deleted")
+	}
+	if len(user.policies) > 0 {
+		t.Fatalf("expected replication policy to be deleted, got %v", user.policies)
+	}
+}
+
+func TestCreateKey(t *testing.T) {
+	key := &mockKey{}
+	broker := &S3Broker{
+		logger:       lager.NewLogger("broker-unit-test-TestCreateKey"),
+		bucketPrefix: "test",
+		key:          key,
+	}
+
+	bucketDetails := &awss3.BucketDetails{}
+	if err := broker.createKey("instance1", bucketDetails); err != nil {
+		t.Fatal(err)
+	}
+	var encryption s3.ServerSideEncryptionConfiguration
+	if err := json.Unmarshal([]byte(bucketDetails.Encryption), &encryption); err != nil {
+		t.Fatal(err)
+	}
+	rule := encryption.Rules[0]
+	if algorithm := aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm); algorithm != "aws:kms" {
+		t.Errorf("unexpected algorithm %s", algorithm)
+	}
+	if keyID := aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID); keyID != "arn:aws:kms:us-east-1:123456789012:key/alias/test-instance1" {
+		t.Errorf("unexpected key %s", keyID)
+	}
+	if !aws.BoolValue(rule.BucketKeyEnabled) {
+		t.Error("expected bucket key to be enabled")
+	}
+
+	// Provisioning again, as after a failure, reuses the key.
+	if err := broker.createKey("instance1", &awss3.BucketDetails{}); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(key.created, []string{"alias/test-instance1"}) {
+		t.Error(cmp.Diff(key.created, []string{"alias/test-instance1"}))
+	}
+}
+
+func TestDeprovisionKey(t *testing.T) {
+	key := &mockKey{keys: map[string]string{"alias/test-instance1": "key-arn"}}
+	broker := &S3Broker{
+		logger:       lager.NewLogger("broker-unit-test-TestDeprovisionKey"),
+		bucketPrefix: "test",
+		bucket:       &mockBucket{},
+		key:          key,
+		catalog: &mockPlanCatalog{plan: ServicePlan{
+			S3Properties: S3Properties{DedicatedKMSKey: true},
+		}},
+	}
+
+	if _, err := broker.Deprovision(context.Background(), "instance1", domain.DeprovisionDetails{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(key.scheduled, []string{"alias/test-instance1"}) {
+		t.Error(cmp.Diff(key.scheduled, []string{"alias/test-instance1"}))
+	}
+}
+
+func TestServicesQuota(t *testing.T) {
+	broker := &S3Broker{
+		logger: lager.NewLogger("broker-unit-test-TestServicesQuota"),
+		catalog: &BrokerCatalog{Services: []Service{{
+			ID: "service1",
+			Plans: []ServicePlan{
+				{ID: "plan1"},
+				{ID: "plan2", S3Properties: S3Properties{MaxSizeBytes: 1024, MaxObjects: 10}},
+			},
+		}}},
+	}
+
+	services, err := broker.Services(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans := services[0].Plans
+	if plans[0].Metadata != nil {
+		t.Errorf("unexpected metadata %+v", plans[0].Metadata)
+	}
+	expected := map[string]interface{}{
+		"quota": map[string]int64{"max_size_bytes": 1024, "max_objects": 10},
+	}
+	if !cmp.Equal(plans[1].Metadata.AdditionalMetadata, expected) {
+		t.Error(cmp.Diff(plans[1].Metadata.AdditionalMetadata, expected))
+	}
+}
@@ -1,9 +1,13 @@
 package broker
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pivotal-cf/brokerapi/v10"
 )
 
@@ -11,6 +15,7 @@ type Catalog interface {
 	Validate() error
 	FindService(serviceID string) (service Service, found bool)
 	FindServicePlan(planID string) (plan ServicePlan, found bool)
+	FindServiceForPlan(planID string) (service Service, found bool)
 	ListServicePlans() []ServicePlan
 }
 
@@ -41,10 +46,108 @@ type ServicePlan struct {
 	S3Properties  S3Properties                   `yaml:"s3_properties,omitempty"`
 }
 
+// Binding permission modes that can be requested with the permissions bind
+// parameter.
+const (
+	PermissionsReadOnly  = "read-only"
+	PermissionsWriteOnly = "write-only"
+	PermissionsReadWrite = "read-write"
+)
+
 type S3Properties struct {
 	IamPolicy    string `yaml:"iam_policy,omitempty"`
 	BucketPolicy string `yaml:"bucket_policy,omitempty"`
 	Encryption   string `yaml:"encryption,omitempty"`
+
+	// IamPolicies maps binding permission modes to IAM policy templates.
+	// Bindings that do not request a mode get IamPolicy.
+	IamPolicies map[string]string `yaml:"iam_policies,omitempty"`
+
+	// BindingStrategy overrides the broker's binding strategy for this plan.
+	BindingStrategy string `yaml:"binding_strategy,omitempty"`
+
+	// RequireVersioning enables versioning on every bucket of the plan and
+	// stops users from suspending it.
+	RequireVersioning bool `yaml:"require_versioning,omitempty"`
+
+	// Lifecycle is the JSON form of an S3 BucketLifecycleConfiguration
+	// applied to every bucket of the plan.
+	Lifecycle string `yaml:"lifecycle,omitempty"`
+
+	// LifecycleLimits constrains the lifecycle rules users may request. Users
+	// cannot request lifecycle rules on plans without limits.
+	LifecycleLimits *LifecycleLimits `yaml:"lifecycle_limits,omitempty"`
+
+	// ForbidWildcardCORSOrigins rejects CORS rules that allow any origin.
+	ForbidWildcardCORSOrigins bool `yaml:"forbid_wildcard_cors_origins,omitempty"`
+
+	// Website turns on static website hosting for every bucket of the plan.
+	// The plan's bucket policy must allow public reads for the site to be
+	// reachable.
+	Website *WebsiteProperties `yaml:"website,omitempty"`
+
+	// ObjectLock creates every bucket of the plan with S3 Object Lock and a
+	// default retention period. Object lock implies versioning.
+	ObjectLock *ObjectLockProperties `yaml:"object_lock,omitempty"`
+
+	// Replication creates a replica of every bucket of the plan in the
+	// broker's replica region. Replication implies versioning.
+	Replication *ReplicationProperties `yaml:"replication,omitempty"`
+
+	// DedicatedKMSKey creates a KMS key for every bucket of the plan and uses
+	// it, with S3 Bucket Keys, as the bucket's default encryption. The key is
+	// scheduled for deletion when the instance is deleted.
+	DedicatedKMSKey bool `yaml:"dedicated_kms_key,omitempty"`
+
+	// MaxSizeBytes and MaxObjects limit the storage of every bucket of the
+	// plan. The broker publishes them in the plan metadata; the
+	// reconcile-quotas task enforces them. Zero means unlimited.
+	MaxSizeBytes int64 `yaml:"max_size_bytes,omitempty"`
+	MaxObjects   int64 `yaml:"max_objects,omitempty"`
+}
+
+type ReplicationProperties struct {
+	// StorageClass of the replicas. Defaults to the storage class of the
+	// source objects.
+	StorageClass string `yaml:"storage_class,omitempty"`
+}
+
+// ObjectLockProperties sets the default retention of object-lock buckets.
+// Exactly one of Days and Years must be set.
+type ObjectLockProperties struct {
+	Mode  string `yaml:"mode"`
+	Days  int64  `yaml:"days,omitempty"`
+	Years int64  `yaml:"years,omitempty"`
+}
+
+type WebsiteProperties struct {
+	IndexDocument string               `yaml:"index_document"`
+	ErrorDocument string               `yaml:"error_document,omitempty"`
+	RoutingRules  []WebsiteRoutingRule `yaml:"routing_rules,omitempty"`
+}
+
+// WebsiteRoutingRule redirects requests that match Condition. It mirrors the
+// S3 RoutingRule.
+type WebsiteRoutingRule struct {
+	Condition struct {
+		KeyPrefixEquals             string `yaml:"key_prefix_equals,omitempty"`
+		HttpErrorCodeReturnedEquals string `yaml:"http_error_code_returned_equals,omitempty"`
+	} `yaml:"condition,omitempty"`
+	Redirect struct {
+		HostName             string `yaml:"host_name,omitempty"`
+		HttpRedirectCode     string `yaml:"http_redirect_code,omitempty"`
+		Protocol             string `yaml:"protocol,omitempty"`
+		ReplaceKeyPrefixWith string `yaml:"replace_key_prefix_with,omitempty"`
+		ReplaceKeyWith       string `yaml:"replace_key_with,omitempty"`
+	} `yaml:"redirect"`
+}
+
+// LifecycleLimits constrains user-requested lifecycle rules. Zero values
+// impose no limit.
+type LifecycleLimits struct {
+	MaxRules          int      `yaml:"max_rules,omitempty"`
+	MinExpirationDays int64    `yaml:"min_expiration_days,omitempty"`
+	StorageClasses    []string `yaml:"storage_classes,omitempty"`
 }
 
 func (c BrokerCatalog) Validate() error {
@@ -79,6 +182,19 @@ func (c BrokerCatalog) FindServicePlan(planID string) (plan ServicePlan, found b
 	return plan, false
 }
 
+// FindServiceForPlan returns the service that offers the plan with planID.
+func (c BrokerCatalog) FindServiceForPlan(planID string) (service Service, found bool) {
+	for _, service := range c.Services {
+		for _, plan := range service.Plans {
+			if plan.ID == planID {
+				return service, true
+			}
+		}
+	}
+
+	return service, false
+}
+
 func (c BrokerCatalog) ListServicePlans() []ServicePlan {
 	var plans []ServicePlan
 	for _, service := range c.Services {
@@ -136,5 +252,169 @@ func (eq S3Properties) Validate() error {
 		return errors.New("Must provide a non-empty IAM Policy")
 	}
 
+	for permissions, iamPolicy := range eq.IamPolicies {
+		switch permissions {
+		case PermissionsReadOnly, PermissionsWriteOnly, PermissionsReadWrite:
+		default:
+			return fmt.Errorf("Unknown binding permissions '%s'", permissions)
+		}
+		if len(iamPolicy) == 0 {
+			return fmt.Errorf("Must provide a non-empty IAM Policy for '%s' permissions", permissions)
+		}
+	}
+
+	if err := validateBindingStrategy(eq.BindingStrategy); err != nil {
+		return err
+	}
+
+	if eq.Website != nil && len(eq.Website.IndexDocument) == 0 {
+		return errors.New("Must provide a non-empty website index document")
+	}
+
+	if eq.ObjectLock != nil {
+		if err := eq.ObjectLock.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if eq.DedicatedKMSKey {
+		if len(eq.Encryption) > 0 {
+			return errors.New("Must not provide both encryption and a dedicated KMS key")
+		}
+		// KMS keys are regional, so the replica could not use the key.
+		if eq.Replication != nil {
+			return errors.New("Replication is not supported with a dedicated KMS key")
+		}
+	}
+
+	if eq.MaxSizeBytes < 0 || eq.MaxObjects < 0 {
+		return errors.New("Storage quota must not be negative")
+	}
+
+	if len(eq.Lifecycle) > 0 {
+		var lifecycle s3.BucketLifecycleConfiguration
+		if err := json.Unmarshal([]byte(eq.Lifecycle), &lifecycle); err != nil {
+			return fmt.Errorf("Invalid lifecycle configuration: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Configuration returns the JSON form of the S3 WebsiteConfiguration for w.
+func (w WebsiteProperties) Configuration() string {
+	website := s3.WebsiteConfiguration{
+		IndexDocument: &s3.IndexDocument{Suffix: aws.String(w.IndexDocument)},
+	}
+	if w.ErrorDocument != "" {
+		website.ErrorDocument = &s3.ErrorDocument{Key: aws.String(w.ErrorDocument)}
+	}
+	for _, rule := range w.RoutingRules {
+		routingRule := &s3.RoutingRule{
+			Redirect: &s3.Redirect{
+				HostName:             stringOrNil(rule.Redirect.HostName),
+				HttpRedirectCode:     stringOrNil(rule.Redirect.HttpRedirectCode),
+				Protocol:             stringOrNil(rule.Redirect.Protocol),
+				ReplaceKeyPrefixWith: stringOrNil(rule.Redirect.ReplaceKeyPrefixWith),
+				ReplaceKeyWith:       stringOrNil(rule.Redirect.ReplaceKeyWith),
+			},
+		}
+		if rule.Condition.KeyPrefixEquals != "" || rule.Condition.HttpErrorCodeReturnedEquals != "" {
+			routingRule.Condition = &s3.Condition{
+				KeyPrefixEquals:             stringOrNil(rule.Condition.KeyPrefixEquals),
+				HttpErrorCodeReturnedEquals: stringOrNil(rule.Condition.HttpErrorCodeReturnedEquals),
+			}
+		}
+		website.RoutingRules = append(website.RoutingRules, routingRule)
+	}
+
+	// Marshalling a struct of strings and pointers cannot fail.
+	encoded, _ := json.Marshal(website)
+	return string(encoded)
+}
+
+func stringOrNil(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return aws.String(value)
+}
+
+// Values of the versioning provision and update parameter.
+const (
+	VersioningEnabled   = "enabled"
+	VersioningSuspended = "suspended"
+)
+
+// Object lock retention modes accepted in plan configuration.
+const (
+	ObjectLockGovernance = "governance"
+	ObjectLockCompliance = "compliance"
+)
+
+func (o ObjectLockProperties) Validate() error {
+	switch strings.ToUpper(o.Mode) {
+	case s3.ObjectLockRetentionModeGovernance, s3.ObjectLockRetentionModeCompliance:
+	default:
+		return fmt.Errorf("Object lock mode must be '%s' or '%s'", ObjectLockGovernance, ObjectLockCompliance)
+	}
+	if (o.Days > 0) == (o.Years > 0) {
+		return errors.New("Must provide exactly one of object lock days or years")
+	}
+	if o.Days < 0 || o.Years < 0 {
+		return errors.New("Object lock retention must not be negative")
+	}
 	return nil
 }
+
+// Configuration returns the JSON form of the S3 ObjectLockConfiguration for o.
+func (o ObjectLockProperties) Configuration() string {
+	retention := &s3.DefaultRetention{Mode: aws.String(strings.ToUpper(o.Mode))}
+	if o.Days > 0 {
+		retention.Days = aws.Int64(o.Days)
+	} else {
+		retention.Years = aws.Int64(o.Years)
+	}
+
+	encoded, _ := json.Marshal(s3.ObjectLockConfiguration{
+		ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled),
+		Rule:              &s3.ObjectLockRule{DefaultRetention: retention},
+	})
+	return string(encoded)
+}
+
+// VersioningFor returns the S3 versioning status for a bucket whose user
+// requested versioning, or "" to leave the bucket's versioning unchanged.
+func (eq S3Properties) VersioningFor(versioning string) (string, error) {
+	// S3 refuses to suspend versioning on object-lock and replicated buckets.
+	requireVersioning := eq.RequireVersioning || eq.ObjectLock != nil || eq.Replication != nil
+
+	switch versioning {
+	case "":
+		if requireVersioning {
+			return s3.BucketVersioningStatusEnabled, nil
+		}
+		return "", nil
+	case VersioningEnabled:
+		return s3.BucketVersioningStatusEnabled, nil
+	case VersioningSuspended:
+		if requireVersioning {
+			return "", errors.New("This plan requires versioning to be enabled")
+		}
+		return s3.BucketVersioningStatusSuspended, nil
+	}
+	return "", fmt.Errorf("Versioning must be '%s' or '%s'", VersioningEnabled, VersioningSuspended)
+}
+
+// IamPolicyFor returns the IAM policy template for bindings that request
+// permissions. An empty permissions selects the default IamPolicy.
+func (eq S3Properties) IamPolicyFor(permissions string) (string, error) {
+	if permissions == "" {
+		return eq.IamPolicy, nil
+	}
+	iamPolicy, ok := eq.IamPolicies[permissions]
+	if !ok {
+		return "", fmt.Errorf("This plan does not offer '%s' permissions", permissions)
+	}
+	return iamPolicy, nil
+}
@@ -78,6 +78,25 @@ var _ = Describe("Catalog", func() {
 			Expect(found).To(BeFalse())
 		})
 	})
+
+	Describe("FindServiceForPlan", func() {
+		BeforeEach(func() {
+			catalog = BrokerCatalog{
+				Services: []Service{service1, service2},
+			}
+		})
+
+		It("returns true and the Service offering the Plan if it is found", func() {
+			service, found := catalog.FindServiceForPlan("Plan-2")
+			Expect(service).To(Equal(service2))
+			Expect(found).To(BeTrue())
+		})
+
+		It("returns false if it is not found", func() {
+			_, found := catalog.FindServiceForPlan("Plan-?")
+			Expect(found).To(BeFalse())
+		})
+	})
 })
 
 var _ = Describe("Service", func() {
@@ -195,3 +214,213 @@ var _ = Describe("ServicePlan", func() {
 		})
 	})
 })
+
+var _ = Describe("S3Properties", func() {
+	var (
+		s3Properties S3Properties
+	)
+
+	BeforeEach(func() {
+		s3Properties = S3Properties{
+			IamPolicy: "read-write-policy",
+			IamPolicies: map[string]string{
+				PermissionsReadOnly: "read-only-policy",
+			},
+		}
+	})
+
+	Describe("Validate", func() {
+		It("does not return error if all fields are valid", func() {
+			err := s3Properties.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error if IamPolicy is empty", func() {
+			s3Properties.IamPolicy = ""
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty IAM Policy"))
+		})
+
+		It("returns error if IamPolicies has an unknown mode", func() {
+			s3Properties.IamPolicies["delete-only"] = "policy"
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Unknown binding permissions 'delete-only'"))
+		})
+
+		It("returns error if an IamPolicies template is empty", func() {
+			s3Properties.IamPolicies[PermissionsWriteOnly] = ""
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty IAM Policy for 'write-only' permissions"))
+		})
+	})
+
+	Describe("Website", func() {
+		It("returns error if the index document is empty", func() {
+			s3Properties.Website = &WebsiteProperties{}
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty website index document"))
+		})
+
+		It("returns the website configuration", func() {
+			website := WebsiteProperties{
+				IndexDocument: "index.html",
+				ErrorDocument: "404.html",
+				RoutingRules:  []WebsiteRoutingRule{{}},
+			}
+			website.RoutingRules[0].Condition.KeyPrefixEquals = "docs/"
+			website.RoutingRules[0].Redirect.ReplaceKeyPrefixWith = "documents/"
+
+			Expect(website.Configuration()).To(MatchJSON(`{
+				"ErrorDocument": {"Key": "404.html"},
+				"IndexDocument": {"Suffix": "index.html"},
+				"RedirectAllRequestsTo": null,
+				"RoutingRules": [{
+					"Condition": {"KeyPrefixEquals": "docs/", "HttpErrorCodeReturnedEquals": null},
+					"Redirect": {"HostName": null, "HttpRedirectCode": null, "Protocol": null, "ReplaceKeyPrefixWith": "documents/", "ReplaceKeyWith": null}
+				}]
+			}`))
+		})
+	})
+
+	Describe("ObjectLock", func() {
+		It("returns error for an unknown retention mode", func() {
+			s3Properties.ObjectLock = &ObjectLockProperties{Mode: "forever", Days: 1}
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Object lock mode must be 'governance' or 'compliance'"))
+		})
+
+		It("returns error unless exactly one of days or years is set", func() {
+			s3Properties.ObjectLock = &ObjectLockProperties{Mode: ObjectLockGovernance, Days: 30, Years: 1}
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide exactly one of object lock days or years"))
+		})
+
+		It("returns the object lock configuration", func() {
+			objectLock := ObjectLockProperties{Mode: ObjectLockCompliance, Years: 7}
+
+			Expect(objectLock.Configuration()).To(MatchJSON(`{
+				"ObjectLockEnabled": "Enabled",
+				"Rule": {"DefaultRetention": {"Days": null, "Mode": "COMPLIANCE", "Years": 7}}
+			}`))
+		})
+
+		It("requires versioning", func() {
+			s3Properties.ObjectLock = &ObjectLockProperties{Mode: ObjectLockCompliance, Years: 7}
+
+			versioning, err := s3Properties.VersioningFor("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versioning).To(Equal("Enabled"))
+
+			_, err = s3Properties.VersioningFor(VersioningSuspended)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("DedicatedKMSKey", func() {
+		It("returns error if the plan also sets encryption", func() {
+			s3Properties.DedicatedKMSKey = true
+			s3Properties.Encryption = `{"Rules":[]}`
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must not provide both encryption and a dedicated KMS key"))
+		})
+
+		It("returns error if the plan replicates", func() {
+			s3Properties.DedicatedKMSKey = true
+			s3Properties.Replication = &ReplicationProperties{}
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Replication is not supported with a dedicated KMS key"))
+		})
+	})
+
+	Describe("Storage quota", func() {
+		It("does not return error if the quota is positive", func() {
+			s3Properties.MaxSizeBytes = 1 << 30
+			s3Properties.MaxObjects = 1000
+
+			err := s3Properties.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error if the quota is negative", func() {
+			s3Properties.MaxObjects = -1
+
+			err := s3Properties.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Storage quota must not be negative"))
+		})
+	})
+
+	Describe("VersioningFor", func() {
+		It("leaves versioning unchanged if none is requested", func() {
+			versioning, err := s3Properties.VersioningFor("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versioning).To(BeEmpty())
+		})
+
+		It("returns the requested versioning status", func() {
+			versioning, err := s3Properties.VersioningFor(VersioningSuspended)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versioning).To(Equal("Suspended"))
+		})
+
+		It("returns error for an unknown versioning status", func() {
+			_, err := s3Properties.VersioningFor("on")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Versioning must be 'enabled' or 'suspended'"))
+		})
+
+		Context("when the plan requires versioning", func() {
+			BeforeEach(func() {
+				s3Properties.RequireVersioning = true
+			})
+
+			It("enables versioning if none is requested", func() {
+				versioning, err := s3Properties.VersioningFor("")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(versioning).To(Equal("Enabled"))
+			})
+
+			It("returns error if suspending versioning is requested", func() {
+				_, err := s3Properties.VersioningFor(VersioningSuspended)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("This plan requires versioning to be enabled"))
+			})
+		})
+	})
+
+	Describe("IamPolicyFor", func() {
+		It("returns the default policy if no permissions are requested", func() {
+			policy, err := s3Properties.IamPolicyFor("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy).To(Equal("read-write-policy"))
+		})
+
+		It("returns the policy for the requested permissions", func() {
+			policy, err := s3Properties.IamPolicyFor(PermissionsReadOnly)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy).To(Equal("read-only-policy"))
+		})
+
+		It("returns error if the plan does not offer the requested permissions", func() {
+			_, err := s3Properties.IamPolicyFor(PermissionsWriteOnly)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("This plan does not offer 'write-only' permissions"))
+		})
+	})
+})
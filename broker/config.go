@@ -3,6 +3,16 @@ package broker
 import (
 	"errors"
 	"fmt"
+	"text/template"
+)
+
+// Binding strategies decide which kind of IAM principal backs a binding.
+const (
+	// BindingStrategyUser creates an IAM user with a long-lived access key.
+	BindingStrategyUser = "user"
+	// BindingStrategyRole creates an IAM role and returns temporary
+	// credentials for it.
+	BindingStrategyRole = "role"
 )
 
 type Config struct {
@@ -17,6 +27,11 @@ type Config struct {
 	AwsPartition                 string        `yaml:"aws_partition"`
 	AllowUserProvisionParameters bool          `yaml:"allow_user_provision_parameters"`
 	AllowUserUpdateParameters    bool          `yaml:"allow_user_update_parameters"`
+	BindingStrategy              string        `yaml:"binding_strategy"`
+	ReplicaRegion                string        `yaml:"replica_region"`
+	AccessLogBucket              string        `yaml:"access_log_bucket"`
+	AccessLogPrefix              string        `yaml:"access_log_prefix"`
+	ReplicaAccessLogBucket       string        `yaml:"replica_access_log_bucket"`
 	Catalog                      BrokerCatalog `yaml:"catalog"`
 }
 
@@ -41,9 +56,50 @@ func (c Config) Validate() error {
 		return errors.New("Must provide a non-empty AwsPartition")
 	}
 
+	if err := validateBindingStrategy(c.BindingStrategy); err != nil {
+		return err
+	}
+
 	if err := c.Catalog.Validate(); err != nil {
 		return fmt.Errorf("Validating Catalog configuration: %s", err)
 	}
 
+	if c.ReplicaRegion == c.Region {
+		return errors.New("ReplicaRegion must differ from Region")
+	}
+
+	if c.ReplicaRegion == "" {
+		for _, plan := range c.Catalog.ListServicePlans() {
+			if plan.S3Properties.Replication != nil {
+				return fmt.Errorf("Must provide a non-empty ReplicaRegion for plan '%s', which replicates", plan.Name)
+			}
+		}
+	}
+
+	if c.AccessLogPrefix != "" {
+		if c.AccessLogBucket == "" {
+			return errors.New("Must provide a non-empty AccessLogBucket with AccessLogPrefix")
+		}
+		if _, err := template.New("access-log-prefix").Parse(c.AccessLogPrefix); err != nil {
+			return fmt.Errorf("Invalid AccessLogPrefix: %s", err)
+		}
+	}
+
+	// S3 delivers access logs only to a bucket in the logged bucket's region.
+	if c.AccessLogBucket != "" && c.ReplicaRegion != "" && c.ReplicaAccessLogBucket == "" {
+		return errors.New("Must provide a non-empty ReplicaAccessLogBucket with AccessLogBucket and ReplicaRegion")
+	}
+	if c.ReplicaAccessLogBucket != "" && c.AccessLogBucket == "" {
+		return errors.New("Must provide a non-empty AccessLogBucket with ReplicaAccessLogBucket")
+	}
+
 	return nil
 }
+
+func validateBindingStrategy(bindingStrategy string) error {
+	switch bindingStrategy {
+	case "", BindingStrategyUser, BindingStrategyRole:
+		return nil
+	}
+	return fmt.Errorf("Unknown binding strategy '%s'", bindingStrategy)
+}
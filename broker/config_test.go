@@ -71,6 +71,83 @@ var _ = Describe("Config", func() {
 			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty BucketPrefix"))
 		})
 
+		It("returns error if BindingStrategy is not valid", func() {
+			config.BindingStrategy = "group"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Unknown binding strategy 'group'"))
+		})
+
+		It("returns error if ReplicaRegion is the same as Region", func() {
+			config.ReplicaRegion = config.Region
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("ReplicaRegion must differ from Region"))
+		})
+
+		It("returns error if a plan replicates without a ReplicaRegion", func() {
+			config.Catalog = BrokerCatalog{
+				[]Service{
+					Service{
+						ID:          "service-1",
+						Name:        "Service 1",
+						Description: "Service 1 description",
+						Plans: []ServicePlan{
+							ServicePlan{
+								ID:          "plan-1",
+								Name:        "dr",
+								Description: "Plan 1 description",
+								S3Properties: S3Properties{
+									IamPolicy:   "policy",
+									Replication: &ReplicationProperties{},
+								},
+							},
+						},
+					},
+				},
+			}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty ReplicaRegion for plan 'dr', which replicates"))
+		})
+
+		It("returns error if AccessLogPrefix is set without AccessLogBucket", func() {
+			config.AccessLogPrefix = "{{.InstanceGUID}}/"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty AccessLogBucket with AccessLogPrefix"))
+		})
+
+		It("returns error if AccessLogPrefix is not a valid template", func() {
+			config.AccessLogBucket = "audit-logs"
+			config.AccessLogPrefix = "{{.InstanceGUID/"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Invalid AccessLogPrefix"))
+		})
+
+		It("returns error if ReplicaRegion is set without ReplicaAccessLogBucket", func() {
+			config.AccessLogBucket = "audit-logs"
+			config.ReplicaRegion = "us-west-2"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty ReplicaAccessLogBucket with AccessLogBucket and ReplicaRegion"))
+		})
+
+		It("returns error if ReplicaAccessLogBucket is set without AccessLogBucket", func() {
+			config.ReplicaAccessLogBucket = "replica-audit-logs"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty AccessLogBucket with ReplicaAccessLogBucket"))
+		})
+
 		It("returns error if Catalog is not valid", func() {
 			config.Catalog = BrokerCatalog{
 				[]Service{
@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// maxCORSRules is the most CORS rules S3 accepts on a bucket.
+const maxCORSRules = 100
+
+var corsMethods = []string{"GET", "PUT", "POST", "DELETE", "HEAD"}
+
+// corsConfiguration checks the CORS rules a user requested against the plan
+// and returns the JSON form of the resulting CORSConfiguration.
+func corsConfiguration(s3Properties S3Properties, rules []CORSRule) (string, error) {
+	if len(rules) > maxCORSRules {
+		return "", fmt.Errorf("At most %d CORS rules are allowed", maxCORSRules)
+	}
+
+	cors := s3.CORSConfiguration{CORSRules: []*s3.CORSRule{}}
+	for idx, rule := range rules {
+		corsRule, err := rule.toS3(s3Properties)
+		if err != nil {
+			return "", fmt.Errorf("CORS rule %d: %s", idx+1, err)
+		}
+		cors.CORSRules = append(cors.CORSRules, corsRule)
+	}
+
+	encoded, err := json.Marshal(cors)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func (r CORSRule) toS3(s3Properties S3Properties) (*s3.CORSRule, error) {
+	if len(r.AllowedOrigins) == 0 {
+		return nil, errors.New("must set allowed_origins")
+	}
+	for _, origin := range r.AllowedOrigins {
+		if s3Properties.ForbidWildcardCORSOrigins && strings.Contains(origin, "*") {
+			return nil, fmt.Errorf("origin '%s' is not allowed; this plan does not allow wildcard origins", origin)
+		}
+	}
+
+	if len(r.AllowedMethods) == 0 {
+		return nil, errors.New("must set allowed_methods")
+	}
+	methods := make([]string, len(r.AllowedMethods))
+	for idx, method := range r.AllowedMethods {
+		methods[idx] = strings.ToUpper(method)
+		if !slices.Contains(corsMethods, methods[idx]) {
+			return nil, fmt.Errorf("method '%s' must be one of %s", method, strings.Join(corsMethods, ", "))
+		}
+	}
+
+	if r.MaxAgeSeconds < 0 {
+		return nil, errors.New("max_age_seconds must not be negative")
+	}
+
+	corsRule := &s3.CORSRule{
+		AllowedOrigins: aws.StringSlice(r.AllowedOrigins),
+		AllowedMethods: aws.StringSlice(methods),
+	}
+	if len(r.AllowedHeaders) > 0 {
+		corsRule.AllowedHeaders = aws.StringSlice(r.AllowedHeaders)
+	}
+	if len(r.ExposeHeaders) > 0 {
+		corsRule.ExposeHeaders = aws.StringSlice(r.ExposeHeaders)
+	}
+	if r.MaxAgeSeconds > 0 {
+		corsRule.MaxAgeSeconds = aws.Int64(r.MaxAgeSeconds)
+	}
+	return corsRule, nil
+}
@@ -0,0 +1,71 @@
+package broker
+
+import (
+	"testing"
+)
+
+func TestCORSConfiguration(t *testing.T) {
+	testCases := map[string]struct {
+		s3Properties S3Properties
+		rules        []CORSRule
+		expectCORS   string
+		expectErr    string
+	}{
+		"no rules": {
+			expectCORS: `{"CORSRules":[]}`,
+		},
+		"browser uploads": {
+			rules: []CORSRule{{
+				AllowedOrigins: []string{"https://app.example.gov"},
+				AllowedMethods: []string{"put", "POST"},
+				AllowedHeaders: []string{"*"},
+				MaxAgeSeconds:  3000,
+			}},
+			expectCORS: `{"CORSRules":[{"AllowedHeaders":["*"],"AllowedMethods":["PUT","POST"],"AllowedOrigins":["https://app.example.gov"],"ExposeHeaders":null,"ID":null,"MaxAgeSeconds":3000}]}`,
+		},
+		"wildcard origin": {
+			rules: []CORSRule{{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{"GET"},
+			}},
+			expectCORS: `{"CORSRules":[{"AllowedHeaders":null,"AllowedMethods":["GET"],"AllowedOrigins":["*"],"ExposeHeaders":null,"ID":null,"MaxAgeSeconds":null}]}`,
+		},
+		"wildcard origin forbidden": {
+			s3Properties: S3Properties{ForbidWildcardCORSOrigins: true},
+			rules: []CORSRule{{
+				AllowedOrigins: []string{"https://*.example.gov"},
+				AllowedMethods: []string{"GET"},
+			}},
+			expectErr: "CORS rule 1: origin 'https://*.example.gov' is not allowed; this plan does not allow wildcard origins",
+		},
+		"missing origins": {
+			rules:     []CORSRule{{AllowedMethods: []string{"GET"}}},
+			expectErr: "CORS rule 1: must set allowed_origins",
+		},
+		"unknown method": {
+			rules: []CORSRule{{
+				AllowedOrigins: []string{"https://app.example.gov"},
+				AllowedMethods: []string{"PATCH"},
+			}},
+			expectErr: "CORS rule 1: method 'PATCH' must be one of GET, PUT, POST, DELETE, HEAD",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cors, err := corsConfiguration(tc.s3Properties, tc.rules)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cors != tc.expectCORS {
+				t.Fatalf("expected CORS %s, got %s", tc.expectCORS, cors)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/cloud-gov/s3-broker/awskms"
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// kmsKeyDeletionWindowDays is how long a deleted instance's key can still be
+// recovered, the longest that KMS allows.
+const kmsKeyDeletionWindowDays = 30
+
+// kmsKeyPolicy is the policy template that lets bindings to instances with a
+// dedicated KMS key read and write objects encrypted with the key.
+const kmsKeyPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": ["kms:Encrypt", "kms:Decrypt", "kms:GenerateDataKey"],
+      "Resource": {{resources ""}}
+    }
+  ]
+}`
+
+func (b *S3Broker) kmsKeyAlias(instanceID string) string {
+	return "alias/" + b.bucketName(instanceID)
+}
+
+// createKey creates the dedicated KMS key for an instance, or finds the key
+// left by an earlier attempt to provision it, and configures bucketDetails to
+// encrypt new objects with it by default.
+func (b *S3Broker) createKey(instanceID string, bucketDetails *awss3.BucketDetails) error {
+	alias := b.kmsKeyAlias(instanceID)
+	keyARN, err := b.key.Describe(alias)
+	if err == awskms.ErrKeyDoesNotExist {
+		keyARN, err = b.key.Create(alias, fmt.Sprintf("S3 bucket %s", b.bucketName(instanceID)), bucketDetails.Tags)
+	}
+	if err != nil {
+		b.logger.Error("provision: error creating kms key", err, lager.Data{
+			instanceIDLogKey: instanceID,
+		})
+		return err
+	}
+
+	encryption, err := json.Marshal(s3.ServerSideEncryptionConfiguration{
+		Rules: []*s3.ServerSideEncryptionRule{{
+			ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+				SSEAlgorithm:   aws.String(s3.ServerSideEncryptionAwsKms),
+				KMSMasterKeyID: aws.String(keyARN),
+			},
+			BucketKeyEnabled: aws.Bool(true),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	bucketDetails.Encryption = string(encryption)
+	return nil
+}
+
+// deleteKey schedules an instance's dedicated KMS key for deletion.
+func (b *S3Broker) deleteKey(instanceID string) error {
+	return b.key.ScheduleDeletion(b.kmsKeyAlias(instanceID), kmsKeyDeletionWindowDays)
+}
+
+// attachKeyPolicy gives a binding use of the instance's dedicated KMS key.
+// attach attaches the policy to the binding's user or role. It returns the
+// policy's ARN.
+func (b *S3Broker) attachKeyPolicy(
+	instanceID string,
+	bindingID string,
+	keyARN string,
+	iamTags []*iam.Tag,
+	attach func(policyARN string) error,
+) (string, error) {
+	return b.attachPolicy(instanceID, bindingID, b.policyName(bindingID)+"-kms", kmsKeyPolicy, []string{keyARN}, "", iamTags, attach)
+}
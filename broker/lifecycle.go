@@ -0,0 +1,135 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// userRuleIDPrefix starts the IDs of the lifecycle rules a user requested,
+// which tells them apart from the plan's default rules on the bucket.
+const userRuleIDPrefix = "user-rule-"
+
+// lifecycleConfiguration combines the plan's default lifecycle rules with the
+// rules a user requested, checking the user's rules against the plan's
+// limits. It returns the JSON form of the resulting
+// BucketLifecycleConfiguration, or "" if there are no rules at all.
+func lifecycleConfiguration(s3Properties S3Properties, rules []LifecycleRule) (string, error) {
+	var lifecycle s3.BucketLifecycleConfiguration
+	if len(s3Properties.Lifecycle) > 0 {
+		if err := json.Unmarshal([]byte(s3Properties.Lifecycle), &lifecycle); err != nil {
+			return "", err
+		}
+	}
+
+	if len(rules) > 0 {
+		limits := s3Properties.LifecycleLimits
+		if limits == nil {
+			return "", errors.New("This plan does not allow lifecycle rules")
+		}
+		if limits.MaxRules > 0 && len(rules) > limits.MaxRules {
+			return "", fmt.Errorf("This plan allows at most %d lifecycle rules", limits.MaxRules)
+		}
+		for idx, rule := range rules {
+			lifecycleRule, err := rule.toS3(*limits)
+			if err != nil {
+				return "", fmt.Errorf("Lifecycle rule %d: %s", idx+1, err)
+			}
+			lifecycleRule.ID = aws.String(fmt.Sprintf("%s%d", userRuleIDPrefix, idx+1))
+			lifecycle.Rules = append(lifecycle.Rules, lifecycleRule)
+		}
+	}
+
+	if len(lifecycle.Rules) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(lifecycle)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// userLifecycleRules returns the rules a user requested from the JSON form of
+// a bucket's BucketLifecycleConfiguration, so that they can be checked against
+// another plan and combined with its default rules.
+func userLifecycleRules(lifecycle string) ([]LifecycleRule, error) {
+	if len(lifecycle) == 0 {
+		return nil, nil
+	}
+	var lifecycleConfig s3.BucketLifecycleConfiguration
+	if err := json.Unmarshal([]byte(lifecycle), &lifecycleConfig); err != nil {
+		return nil, err
+	}
+
+	var rules []LifecycleRule
+	for _, lifecycleRule := range lifecycleConfig.Rules {
+		if !strings.HasPrefix(aws.StringValue(lifecycleRule.ID), userRuleIDPrefix) {
+			continue
+		}
+		var rule LifecycleRule
+		if lifecycleRule.Filter != nil {
+			rule.Prefix = aws.StringValue(lifecycleRule.Filter.Prefix)
+		}
+		if lifecycleRule.Expiration != nil {
+			rule.ExpirationDays = aws.Int64Value(lifecycleRule.Expiration.Days)
+		}
+		if len(lifecycleRule.Transitions) > 0 {
+			rule.TransitionDays = aws.Int64Value(lifecycleRule.Transitions[0].Days)
+			rule.StorageClass = aws.StringValue(lifecycleRule.Transitions[0].StorageClass)
+		}
+		if lifecycleRule.AbortIncompleteMultipartUpload != nil {
+			rule.AbortIncompleteMultipartUploadDays = aws.Int64Value(lifecycleRule.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r LifecycleRule) toS3(limits LifecycleLimits) (*s3.LifecycleRule, error) {
+	lifecycleRule := &s3.LifecycleRule{
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(r.Prefix)},
+	}
+
+	if r.ExpirationDays < 0 || r.TransitionDays < 0 || r.AbortIncompleteMultipartUploadDays < 0 {
+		return nil, errors.New("days must not be negative")
+	}
+
+	if r.ExpirationDays > 0 {
+		if r.ExpirationDays < limits.MinExpirationDays {
+			return nil, fmt.Errorf("objects must be kept for at least %d days", limits.MinExpirationDays)
+		}
+		lifecycleRule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(r.ExpirationDays)}
+	}
+
+	if r.TransitionDays > 0 || r.StorageClass != "" {
+		if r.TransitionDays == 0 || r.StorageClass == "" {
+			return nil, errors.New("transition_days and storage_class must be set together")
+		}
+		if len(limits.StorageClasses) > 0 && !slices.Contains(limits.StorageClasses, r.StorageClass) {
+			return nil, fmt.Errorf("storage class '%s' is not allowed on this plan", r.StorageClass)
+		}
+		lifecycleRule.Transitions = []*s3.Transition{{
+			Days:         aws.Int64(r.TransitionDays),
+			StorageClass: aws.String(r.StorageClass),
+		}}
+	}
+
+	if r.AbortIncompleteMultipartUploadDays > 0 {
+		lifecycleRule.AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int64(r.AbortIncompleteMultipartUploadDays),
+		}
+	}
+
+	if lifecycleRule.Expiration == nil && lifecycleRule.Transitions == nil && lifecycleRule.AbortIncompleteMultipartUpload == nil {
+		return nil, errors.New("must set expiration_days, transition_days or abort_incomplete_multipart_upload_days")
+	}
+
+	return lifecycleRule, nil
+}
@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLifecycleConfiguration(t *testing.T) {
+	planLifecycle := `{"Rules":[{"ID":"abort-uploads","Status":"Enabled","Filter":{"Prefix":""},"AbortIncompleteMultipartUpload":{"DaysAfterInitiation":7}}]}`
+	limits := &LifecycleLimits{
+		MaxRules:          1,
+		MinExpirationDays: 7,
+		StorageClasses:    []string{"STANDARD_IA"},
+	}
+
+	testCases := map[string]struct {
+		s3Properties    S3Properties
+		rules           []LifecycleRule
+		expectLifecycle string
+		expectErr       string
+	}{
+		"no rules": {},
+		"plan rules": {
+			s3Properties:    S3Properties{Lifecycle: planLifecycle},
+			expectLifecycle: `{"Rules":[{"AbortIncompleteMultipartUpload":{"DaysAfterInitiation":7},"Expiration":null,"Filter":{"And":null,"ObjectSizeGreaterThan":null,"ObjectSizeLessThan":null,"Prefix":"","Tag":null},"ID":"abort-uploads","NoncurrentVersionExpiration":null,"NoncurrentVersionTransitions":null,"Prefix":null,"Status":"Enabled","Transitions":null}]}`,
+		},
+		"user rules": {
+			s3Properties:    S3Properties{LifecycleLimits: limits},
+			rules:           []LifecycleRule{{Prefix: "tmp/", ExpirationDays: 7}},
+			expectLifecycle: `{"Rules":[{"AbortIncompleteMultipartUpload":null,"Expiration":{"Date":null,"Days":7,"ExpiredObjectDeleteMarker":null},"Filter":{"And":null,"ObjectSizeGreaterThan":null,"ObjectSizeLessThan":null,"Prefix":"tmp/","Tag":null},"ID":"user-rule-1","NoncurrentVersionExpiration":null,"NoncurrentVersionTransitions":null,"Prefix":null,"Status":"Enabled","Transitions":null}]}`,
+		},
+		"user rules not allowed": {
+			rules:     []LifecycleRule{{ExpirationDays: 7}},
+			expectErr: "This plan does not allow lifecycle rules",
+		},
+		"too many rules": {
+			s3Properties: S3Properties{LifecycleLimits: limits},
+			rules:        []LifecycleRule{{ExpirationDays: 7}, {ExpirationDays: 8}},
+			expectErr:    "This plan allows at most 1 lifecycle rules",
+		},
+		"expiration too soon": {
+			s3Properties: S3Properties{LifecycleLimits: limits},
+			rules:        []LifecycleRule{{ExpirationDays: 1}},
+			expectErr:    "Lifecycle rule 1: objects must be kept for at least 7 days",
+		},
+		"storage class not allowed": {
+			s3Properties: S3Properties{LifecycleLimits: limits},
+			rules:        []LifecycleRule{{TransitionDays: 30, StorageClass: "GLACIER"}},
+			expectErr:    "Lifecycle rule 1: storage class 'GLACIER' is not allowed on this plan",
+		},
+		"transition without storage class": {
+			s3Properties: S3Properties{LifecycleLimits: limits},
+			rules:        []LifecycleRule{{TransitionDays: 30}},
+			expectErr:    "Lifecycle rule 1: transition_days and storage_class must be set together",
+		},
+		"rule without actions": {
+			s3Properties: S3Properties{LifecycleLimits: limits},
+			rules:        []LifecycleRule{{Prefix: "tmp/"}},
+			expectErr:    "Lifecycle rule 1: must set expiration_days, transition_days or abort_incomplete_multipart_upload_days",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lifecycle, err := lifecycleConfiguration(tc.s3Properties, tc.rules)
+			if tc.expectErr != "" {
+				if err == nil || err.Error() != tc.expectErr {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lifecycle != tc.expectLifecycle {
+				t.Fatalf("expected lifecycle %s, got %s", tc.expectLifecycle, lifecycle)
+			}
+		})
+	}
+}
+
+func TestUserLifecycleRules(t *testing.T) {
+	s3Properties := S3Properties{
+		Lifecycle:       `{"Rules":[{"ID":"abort-uploads","Status":"Enabled","Filter":{"Prefix":""},"AbortIncompleteMultipartUpload":{"DaysAfterInitiation":7}}]}`,
+		LifecycleLimits: &LifecycleLimits{},
+	}
+	rules := []LifecycleRule{
+		{Prefix: "tmp/", ExpirationDays: 7},
+		{Prefix: "archive/", TransitionDays: 30, StorageClass: "STANDARD_IA", AbortIncompleteMultipartUploadDays: 3},
+	}
+
+	lifecycle, err := lifecycleConfiguration(s3Properties, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRules, err := userLifecycleRules(lifecycle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(userRules, rules) {
+		t.Error(cmp.Diff(userRules, rules))
+	}
+}
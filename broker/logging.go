@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	brokertags "github.com/cloud-gov/go-broker-tags"
+)
+
+// DefaultAccessLogPrefix files each bucket's access logs under its org, space
+// and instance GUIDs.
+const DefaultAccessLogPrefix = "{{.OrganizationGUID}}/{{.SpaceGUID}}/{{.InstanceGUID}}/"
+
+// ReplicaAccessLogPrefix is prepended to the access log prefix of an
+// instance's bucket to file the logs of its replica bucket.
+const ReplicaAccessLogPrefix = "replica/"
+
+// accessLogging returns the JSON encoding of the logging settings for an
+// instance's bucket, or "" if the broker has no access log bucket configured.
+func (b *S3Broker) accessLogging(guids brokertags.ResourceGUIDs) (string, error) {
+	if b.accessLogBucket == "" {
+		return "", nil
+	}
+	prefix, err := AccessLogPrefix(b.accessLogPrefix, guids)
+	if err != nil {
+		return "", err
+	}
+	logging, err := json.Marshal(s3.LoggingEnabled{
+		TargetBucket: aws.String(b.accessLogBucket),
+		TargetPrefix: aws.String(prefix),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(logging), nil
+}
+
+// replicaAccessLogging returns the JSON encoding of the logging settings for
+// the replica of a bucket with the given logging settings, or "" if the bucket
+// is not logged. The replica's logs go to the replica access log bucket, since
+// S3 delivers logs only within a region, under the bucket's prefix with
+// ReplicaAccessLogPrefix in front.
+func (b *S3Broker) replicaAccessLogging(bucketLogging string) (string, error) {
+	if bucketLogging == "" {
+		return "", nil
+	}
+	var loggingEnabled s3.LoggingEnabled
+	if err := json.Unmarshal([]byte(bucketLogging), &loggingEnabled); err != nil {
+		return "", err
+	}
+	logging, err := json.Marshal(s3.LoggingEnabled{
+		TargetBucket: aws.String(b.replicaAccessLogBucket),
+		TargetPrefix: aws.String(ReplicaAccessLogPrefix + aws.StringValue(loggingEnabled.TargetPrefix)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(logging), nil
+}
+
+// AccessLogPrefix renders an access log prefix template for a bucket. An empty
+// template uses DefaultAccessLogPrefix.
+func AccessLogPrefix(prefixTemplate string, guids brokertags.ResourceGUIDs) (string, error) {
+	if prefixTemplate == "" {
+		prefixTemplate = DefaultAccessLogPrefix
+	}
+	tmpl, err := template.New("access-log-prefix").Parse(prefixTemplate)
+	if err != nil {
+		return "", err
+	}
+	var prefix strings.Builder
+	if err := tmpl.Execute(&prefix, guids); err != nil {
+		return "", err
+	}
+	return prefix.String(), nil
+}
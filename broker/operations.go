@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"sync"
+
+	"github.com/pivotal-cf/brokerapi/v10/domain"
+)
+
+// Operation data returned to the platform for asynchronous requests. The
+// platform echoes it back on every LastOperation poll.
+const (
+	provisionOperation   = "provision"
+	deprovisionOperation = "deprovision"
+)
+
+// trackedOperation is the state of an asynchronous operation along with its
+// kind, such as provisionOperation.
+type trackedOperation struct {
+	kind string
+	domain.LastOperation
+}
+
+// operationStore tracks the state of asynchronous operations by instance ID.
+// Each instance has at most one operation, which is only reported for polls
+// of the same kind. The zero value is ready to use. The state is held only in
+// memory, so the broker must run as a single instance.
+type operationStore struct {
+	mu         sync.Mutex
+	operations map[string]trackedOperation
+}
+
+// start records that an operation of the given kind is in progress for
+// instanceID. Calling it again with a new description updates the progress
+// reported to the platform.
+func (o *operationStore) start(instanceID, kind, description string) {
+	o.set(instanceID, kind, domain.LastOperation{
+		State:       domain.InProgress,
+		Description: description,
+	})
+}
+
+// startUnlessRunning starts an operation like start, unless an operation is
+// already in progress for instanceID. It reports whether the operation was
+// started, and otherwise the kind of the operation in progress.
+func (o *operationStore) startUnlessRunning(instanceID, kind, description string) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if operation, ok := o.operations[instanceID]; ok && operation.State == domain.InProgress {
+		return operation.kind, false
+	}
+	o.setLocked(instanceID, kind, domain.LastOperation{
+		State:       domain.InProgress,
+		Description: description,
+	})
+	return kind, true
+}
+
+// finish records the outcome of the operation for instanceID. A nil err marks
+// the operation as succeeded; otherwise the error message is reported to the
+// platform.
+func (o *operationStore) finish(instanceID, kind, description string, err error) {
+	if err != nil {
+		o.set(instanceID, kind, domain.LastOperation{
+			State:       domain.Failed,
+			Description: err.Error(),
+		})
+		return
+	}
+	o.set(instanceID, kind, domain.LastOperation{
+		State:       domain.Succeeded,
+		Description: description,
+	})
+}
+
+func (o *operationStore) set(instanceID, kind string, operation domain.LastOperation) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.setLocked(instanceID, kind, operation)
+}
+
+func (o *operationStore) setLocked(instanceID, kind string, operation domain.LastOperation) {
+	if o.operations == nil {
+		o.operations = make(map[string]trackedOperation)
+	}
+	o.operations[instanceID] = trackedOperation{kind: kind, LastOperation: operation}
+}
+
+// get returns the operation for instanceID if it is of the given kind.
+func (o *operationStore) get(instanceID, kind string) (domain.LastOperation, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	operation, ok := o.operations[instanceID]
+	if !ok || operation.kind != kind {
+		return domain.LastOperation{}, false
+	}
+	return operation.LastOperation, true
+}
@@ -1,7 +1,45 @@
 package broker
 
+import "encoding/json"
+
 type ProvisionParameters struct {
 	ObjectOwnership string `json:"object_ownership"`
+
+	// Set Versioning to "enabled" or "suspended" to control bucket versioning.
+	Versioning string `json:"versioning"`
+
+	// Set Lifecycle to add lifecycle rules to the plan's defaults.
+	Lifecycle []LifecycleRule `json:"lifecycle"`
+
+	// Set CORS to let browsers on other origins access the bucket.
+	CORS []CORSRule `json:"cors"`
+}
+
+// LifecycleRule is a lifecycle rule requested by a user. Each rule applies to
+// the objects under Prefix and must set at least one action.
+type LifecycleRule struct {
+	Prefix string `json:"prefix"`
+
+	// ExpirationDays deletes objects this many days after they are created.
+	ExpirationDays int64 `json:"expiration_days"`
+
+	// TransitionDays moves objects to StorageClass this many days after they
+	// are created.
+	TransitionDays int64  `json:"transition_days"`
+	StorageClass   string `json:"storage_class"`
+
+	// AbortIncompleteMultipartUploadDays cleans up multipart uploads that
+	// are not completed this many days after they start.
+	AbortIncompleteMultipartUploadDays int64 `json:"abort_incomplete_multipart_upload_days"`
+}
+
+// CORSRule is a cross-origin resource sharing rule requested by a user.
+type CORSRule struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods"`
+	AllowedHeaders []string `json:"allowed_headers"`
+	ExposeHeaders  []string `json:"expose_headers"`
+	MaxAgeSeconds  int64    `json:"max_age_seconds"`
 }
 
 type BindParameters struct {
@@ -10,8 +48,67 @@ type BindParameters struct {
 	// files between buckets. The contents should be a list of service
 	// instance names.
 	AdditionalInstances []string `json:"additional_instances"`
+
+	// Set Permissions to limit the binding to one of the access modes the
+	// plan offers, such as "read-only" or "write-only". When empty, the
+	// binding gets the plan's default IAM policy.
+	Permissions string `json:"permissions"`
+
+	// Set Prefix to confine the binding to keys under a prefix, like
+	// "tenant-x/", so that several apps can share one bucket.
+	Prefix string `json:"prefix"`
+
+	// Set ExpiresIn to a duration, like "72h", to have the binding revoked
+	// once it has passed. Useful for service keys made for one-off tasks.
+	ExpiresIn string `json:"expires_in"`
 }
 
 type UpdateParameters struct {
 	ApplyImmediately bool `json:"apply_immediately"`
+
+	// Set Versioning to "enabled" or "suspended" to control bucket versioning.
+	// Versioning cannot be turned off once enabled, only suspended.
+	Versioning string `json:"versioning"`
+
+	// Set Lifecycle to replace the lifecycle rules previously requested. An
+	// empty list removes them, leaving only the plan's defaults.
+	Lifecycle *[]LifecycleRule `json:"lifecycle"`
+
+	// Set CORS to replace the bucket's CORS rules. An empty list removes them.
+	CORS *[]CORSRule `json:"cors"`
+}
+
+// InstanceParameters describes the live configuration of a service instance's
+// bucket. It is returned by GetInstance.
+type InstanceParameters struct {
+	Bucket           string          `json:"bucket"`
+	Region           string          `json:"region"`
+	ObjectOwnership  string          `json:"object_ownership,omitempty"`
+	Encryption       string          `json:"encryption,omitempty"`
+	KMSKeyID         string          `json:"kms_key_id,omitempty"`
+	Versioning       string          `json:"versioning,omitempty"`
+	Lifecycle        json.RawMessage `json:"lifecycle,omitempty"`
+	CORS             json.RawMessage `json:"cors,omitempty"`
+	Website          json.RawMessage `json:"website,omitempty"`
+	ObjectLock       json.RawMessage `json:"object_lock,omitempty"`
+	Replication      json.RawMessage `json:"replication,omitempty"`
+	Public           bool            `json:"public"`
+	BucketPolicy     string          `json:"bucket_policy,omitempty"`
+	Plan             string          `json:"plan,omitempty"`
+	OrganizationGUID string          `json:"organization_guid,omitempty"`
+	SpaceGUID        string          `json:"space_guid,omitempty"`
+}
+
+// BindingParameters describes an existing binding. It is returned by
+// GetBinding and never includes the binding's secret access key.
+type BindingParameters struct {
+	Bucket            string   `json:"bucket"`
+	Region            string   `json:"region"`
+	Endpoint          string   `json:"endpoint"`
+	FIPSEndpoint      string   `json:"fips_endpoint"`
+	AdditionalBuckets []string `json:"additional_buckets"`
+	AccessKeyExists   bool     `json:"access_key_exists"`
+	BindingStrategy   string   `json:"binding_strategy"`
+	ExpiresAt         string   `json:"expires_at,omitempty"`
+	ReplicaBucket     string   `json:"replica_bucket,omitempty"`
 }
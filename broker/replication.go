@@ -0,0 +1,206 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awss3"
+)
+
+// replicaReadPolicy is the policy template that gives bindings to replicated
+// instances read-only access to the replica bucket, for failover.
+const replicaReadPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": ["s3:ListBucket", "s3:ListBucketVersions", "s3:GetBucketLocation"],
+      "Resource": {{resources ""}}
+    },
+    {
+      "Effect": "Allow",
+      "Action": ["s3:GetObject", "s3:GetObjectVersion"],
+      "Resource": {{resources "/*"}}
+    }
+  ]
+}`
+
+func (b *S3Broker) replicaBucketName(instanceID string) string {
+	return b.bucketName(instanceID) + "-replica"
+}
+
+func (b *S3Broker) replicationRoleName(instanceID string) string {
+	return fmt.Sprintf("%s-%s-replication", b.userPrefix, instanceID)
+}
+
+func (b *S3Broker) replicationPolicyName(instanceID string) string {
+	return fmt.Sprintf("%s-%s-replication", b.policyPrefix, instanceID)
+}
+
+func (b *S3Broker) bucketARN(bucketName string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s", b.awsPartition, bucketName)
+}
+
+// createReplica creates the replica bucket for an instance in the replica
+// region, logged like the instance's bucket, along with the role S3 assumes to
+// replicate objects to it, and configures bucketDetails to replicate to it. Resources left behind by an
+// earlier attempt that failed part way through are reused.
+func (b *S3Broker) createReplica(instanceID string, servicePlan ServicePlan, bucketDetails *awss3.BucketDetails) error {
+	replicaName := b.replicaBucketName(instanceID)
+	logging, err := b.replicaAccessLogging(bucketDetails.Logging)
+	if err != nil {
+		return err
+	}
+	replicaDetails := awss3.BucketDetails{
+		Encryption:      bucketDetails.Encryption,
+		AwsPartition:    bucketDetails.AwsPartition,
+		Tags:            bucketDetails.Tags,
+		ObjectOwnership: bucketDetails.ObjectOwnership,
+		Versioning:      s3.BucketVersioningStatusEnabled,
+		Logging:         logging,
+	}
+	_, err = b.replicaBucket.Create(replicaName, replicaDetails)
+	if err == awss3.ErrBucketAlreadyOwned {
+		// The earlier attempt may have failed before configuring the bucket.
+		err = b.replicaBucket.Modify(replicaName, replicaDetails)
+	}
+	if err != nil {
+		b.logger.Error("provision: error creating replica bucket", err, lager.Data{
+			instanceIDLogKey: instanceID,
+		})
+		return err
+	}
+
+	iamTags := awsiam.ConvertTagsMapToIAMTags(bucketDetails.Tags)
+	roleName := b.replicationRoleName(instanceID)
+	roleARN, err := b.role.CreateServiceRole(roleName, b.iamPath, "s3.amazonaws.com", iamTags)
+	if err != nil {
+		return err
+	}
+
+	sourceARN := b.bucketARN(b.bucketName(instanceID))
+	replicaARN := b.bucketARN(replicaName)
+	policy, err := replicationPolicy(sourceARN, replicaARN)
+	if err != nil {
+		return err
+	}
+	policyARN, err := b.user.CreatePolicy(
+		b.replicationPolicyName(instanceID),
+		b.iamPath,
+		policy,
+		[]string{sourceARN, replicaARN},
+		"",
+		iamTags,
+	)
+	if err == awsiam.ErrEntityAlreadyExists {
+		policyARN, err = b.replicationPolicyARN(roleARN, instanceID)
+	}
+	if err != nil {
+		return err
+	}
+	// Attaching a policy that is already attached succeeds.
+	if err := b.role.AttachRolePolicy(roleName, policyARN); err != nil {
+		return err
+	}
+
+	replication := s3.ReplicationConfiguration{
+		Role: aws.String(roleARN),
+		Rules: []*s3.ReplicationRule{{
+			ID:                      aws.String("replica"),
+			Priority:                aws.Int64(1),
+			Status:                  aws.String(s3.ReplicationRuleStatusEnabled),
+			Filter:                  &s3.ReplicationRuleFilter{Prefix: aws.String("")},
+			DeleteMarkerReplication: &s3.DeleteMarkerReplication{Status: aws.String(s3.DeleteMarkerReplicationStatusEnabled)},
+			Destination: &s3.Destination{
+				Bucket:       aws.String(replicaARN),
+				StorageClass: stringOrNil(servicePlan.S3Properties.Replication.StorageClass),
+			},
+		}},
+	}
+	encoded, err := json.Marshal(replication)
+	if err != nil {
+		return err
+	}
+	bucketDetails.Versioning = s3.BucketVersioningStatusEnabled
+	bucketDetails.Replication = string(encoded)
+	return nil
+}
+
+// replicationPolicyARN returns the ARN of an instance's replication policy,
+// which is in the same account as its replication role.
+func (b *S3Broker) replicationPolicyARN(roleARN, instanceID string) (string, error) {
+	parsed, err := arn.Parse(roleARN)
+	if err != nil {
+		return "", err
+	}
+	iamPath := b.iamPath
+	if iamPath == "" {
+		iamPath = "/"
+	}
+	parsed.Resource = "policy" + iamPath + b.replicationPolicyName(instanceID)
+	return parsed.String(), nil
+}
+
+// deleteReplica deletes an instance's replica bucket and replication role.
+// Either may already be gone, as when provisioning failed part way through.
+func (b *S3Broker) deleteReplica(instanceID string, deleteObjects bool) error {
+	if err := b.replicaBucket.Delete(b.replicaBucketName(instanceID), deleteObjects); err != nil && err != awss3.ErrBucketDoesNotExist {
+		return err
+	}
+	return b.deleteRole(b.replicationRoleName(instanceID))
+}
+
+// attachReplicaPolicy gives a binding read-only access to the replica bucket.
+// attach attaches the policy to the binding's user or role. It returns the
+// policy's ARN.
+func (b *S3Broker) attachReplicaPolicy(
+	instanceID string,
+	bindingID string,
+	prefix string,
+	iamTags []*iam.Tag,
+	attach func(policyARN string) error,
+) (string, error) {
+	return b.attachPolicy(
+		instanceID,
+		bindingID,
+		b.policyName(bindingID)+"-replica",
+		replicaReadPolicy,
+		[]string{b.bucketARN(b.replicaBucketName(instanceID))},
+		prefix,
+		iamTags,
+		attach,
+	)
+}
+
+// replicationPolicy returns the policy that lets S3 replicate objects from
+// the source bucket to the replica.
+func replicationPolicy(sourceARN, replicaARN string) (string, error) {
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Effect":   "Allow",
+				"Action":   []string{"s3:GetReplicationConfiguration", "s3:ListBucket"},
+				"Resource": sourceARN,
+			},
+			{
+				"Effect":   "Allow",
+				"Action":   []string{"s3:GetObjectVersionForReplication", "s3:GetObjectVersionAcl", "s3:GetObjectVersionTagging"},
+				"Resource": sourceARN + "/*",
+			},
+			{
+				"Effect":   "Allow",
+				"Action":   []string{"s3:ReplicateObject", "s3:ReplicateDelete", "s3:ReplicateTags"},
+				"Resource": replicaARN + "/*",
+			},
+		},
+	})
+	return string(policy), err
+}
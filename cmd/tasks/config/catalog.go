@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Plan is the part of a broker catalog plan that the tasks use. Its fields
+// must match broker.ServicePlan.
+type Plan struct {
+	ID           string       `yaml:"id"`
+	Name         string       `yaml:"name"`
+	S3Properties S3Properties `yaml:"s3_properties"`
+}
+
+// S3Properties is the part of a plan's s3_properties that the tasks use. Its
+// fields must match broker.S3Properties.
+type S3Properties struct {
+	IamPolicy       string            `yaml:"iam_policy"`
+	IamPolicies     map[string]string `yaml:"iam_policies"`
+	BucketPolicy    string            `yaml:"bucket_policy"`
+	Encryption      string            `yaml:"encryption"`
+	DedicatedKMSKey bool              `yaml:"dedicated_kms_key"`
+}
+
+// Catalog holds the plans of the broker's catalog.
+type Catalog struct {
+	Plans []Plan
+}
+
+// LoadCatalog reads the catalog from the broker's config file.
+func LoadCatalog(configFile string) (*Catalog, error) {
+	if configFile == "" {
+		return nil, errors.New("BROKER_CONFIG_FILE environment variable is required")
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read broker config: %w", err)
+	}
+
+	var config struct {
+		S3Config struct {
+			Catalog struct {
+				Services []struct {
+					Plans []Plan `yaml:"plans"`
+				} `yaml:"services"`
+			} `yaml:"catalog"`
+		} `yaml:"s3_config"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("could not parse broker config: %w", err)
+	}
+
+	catalog := &Catalog{}
+	for _, service := range config.S3Config.Catalog.Services {
+		catalog.Plans = append(catalog.Plans, service.Plans...)
+	}
+	return catalog, nil
+}
+
+// FindPlan returns the plan with the given catalog ID.
+func (c *Catalog) FindPlan(planID string) (Plan, bool) {
+	for _, plan := range c.Plans {
+		if plan.ID == planID {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
@@ -14,6 +14,15 @@ type Settings struct {
 	CfApiUrl          string
 	CfApiClientId     string
 	CfApiClientSecret string
+	IamPath           string
+	UserPrefix        string
+	PolicyPrefix      string
+	AccessLogBucket   string
+	AccessLogPrefix   string
+	S3Endpoint        string
+	QuotaUsageSource  string
+	QuotaEnforce      bool
+	BrokerConfigFile  string
 }
 
 // LoadFromEnv loads settings from environment variables
@@ -31,6 +40,37 @@ func (s *Settings) LoadFromEnv() error {
 
 	s.Region = os.Getenv("AWS_DEFAULT_REGION")
 
+	// IAM_PATH matches the broker's iam_path setting.
+	s.IamPath = os.Getenv("IAM_PATH")
+	if s.IamPath == "" {
+		s.IamPath = "/"
+	}
+
+	// USER_PREFIX and POLICY_PREFIX match the broker's user_prefix and
+	// policy_prefix settings.
+	s.UserPrefix = os.Getenv("USER_PREFIX")
+	s.PolicyPrefix = os.Getenv("POLICY_PREFIX")
+
+	// ACCESS_LOG_BUCKET and ACCESS_LOG_PREFIX match the broker's
+	// access_log_bucket and access_log_prefix settings.
+	s.AccessLogBucket = os.Getenv("ACCESS_LOG_BUCKET")
+	s.AccessLogPrefix = os.Getenv("ACCESS_LOG_PREFIX")
+
+	// S3_ENDPOINT points the S3 client at an S3-compatible endpoint, which
+	// usually needs QUOTA_USAGE_SOURCE=list. The usage report measures usage
+	// the same way as the quota task.
+	s.S3Endpoint = os.Getenv("S3_ENDPOINT")
+
+	s.QuotaUsageSource = os.Getenv("QUOTA_USAGE_SOURCE")
+	if s.QuotaUsageSource == "" {
+		s.QuotaUsageSource = "cloudwatch"
+	}
+	s.QuotaEnforce = os.Getenv("QUOTA_ENFORCE") == "true"
+
+	// BROKER_CONFIG_FILE is the broker's config file, which the tasks that
+	// compare resources with their plans read the catalog from.
+	s.BrokerConfigFile = os.Getenv("BROKER_CONFIG_FILE")
+
 	if cfApiUrl, ok := os.LookupEnv("CF_API_URL"); ok {
 		s.CfApiUrl = cfApiUrl
 	} else {
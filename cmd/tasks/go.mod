@@ -7,6 +7,7 @@ require (
 	github.com/cloud-gov/go-broker-tags v0.0.0-20241218215556-c78c3f147c5a
 	github.com/cloudfoundry/go-cfclient/v3 v3.0.0-alpha.12
 	golang.org/x/exp v0.0.0-20250506013437-ce4c2cf36ca6
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -0,0 +1,201 @@
+package iam
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// expiresAtTagKey must match awsiam.ExpiresAtTagKey in the broker.
+const expiresAtTagKey = "Expires at"
+
+// refreshPolicyName must match the inline policy the broker puts on roles
+// created for role bindings.
+const refreshPolicyName = "refresh-credentials"
+
+// expired reports whether tags carry an expiry that is before now.
+func expired(tags []*iam.Tag, now time.Time) (bool, error) {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) != expiresAtTagKey {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+		if err != nil {
+			return false, fmt.Errorf("could not parse expiry %q: %w", aws.StringValue(tag.Value), err)
+		}
+		return expiresAt.Before(now), nil
+	}
+	return false, nil
+}
+
+func isNoSuchEntity(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == iam.ErrCodeNoSuchEntityException
+}
+
+func revokeUser(iamClient iamiface.IAMAPI, userName, iamPath string) error {
+	keys, err := iamClient.ListAccessKeys(&iam.ListAccessKeysInput{
+		UserName: aws.String(userName),
+	})
+	if err != nil {
+		return fmt.Errorf("could not list access keys for user %s: %w", userName, err)
+	}
+	for _, key := range keys.AccessKeyMetadata {
+		// Deactivate first so the key stops working even if deleting fails.
+		_, err := iamClient.UpdateAccessKey(&iam.UpdateAccessKeyInput{
+			UserName:    aws.String(userName),
+			AccessKeyId: key.AccessKeyId,
+			Status:      aws.String(iam.StatusTypeInactive),
+		})
+		if err != nil {
+			return fmt.Errorf("could not deactivate access key %s: %w", aws.StringValue(key.AccessKeyId), err)
+		}
+		_, err = iamClient.DeleteAccessKey(&iam.DeleteAccessKeyInput{
+			UserName:    aws.String(userName),
+			AccessKeyId: key.AccessKeyId,
+		})
+		if err != nil {
+			return fmt.Errorf("could not delete access key %s: %w", aws.StringValue(key.AccessKeyId), err)
+		}
+	}
+
+	policies, err := iamClient.ListAttachedUserPolicies(&iam.ListAttachedUserPoliciesInput{
+		UserName:   aws.String(userName),
+		PathPrefix: aws.String(iamPath),
+	})
+	if err != nil {
+		return fmt.Errorf("could not list policies for user %s: %w", userName, err)
+	}
+	for _, policy := range policies.AttachedPolicies {
+		_, err := iamClient.DetachUserPolicy(&iam.DetachUserPolicyInput{
+			UserName:  aws.String(userName),
+			PolicyArn: policy.PolicyArn,
+		})
+		if err != nil {
+			return fmt.Errorf("could not detach policy %s: %w", aws.StringValue(policy.PolicyArn), err)
+		}
+		if err := deletePolicy(iamClient, aws.StringValue(policy.PolicyArn)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := iamClient.DeleteUser(&iam.DeleteUserInput{UserName: aws.String(userName)}); err != nil {
+		return fmt.Errorf("could not delete user %s: %w", userName, err)
+	}
+	return nil
+}
+
+func revokeRole(iamClient iamiface.IAMAPI, roleName, iamPath string) error {
+	policies, err := iamClient.ListAttachedRolePolicies(&iam.ListAttachedRolePoliciesInput{
+		RoleName:   aws.String(roleName),
+		PathPrefix: aws.String(iamPath),
+	})
+	if err != nil {
+		return fmt.Errorf("could not list policies for role %s: %w", roleName, err)
+	}
+	for _, policy := range policies.AttachedPolicies {
+		_, err := iamClient.DetachRolePolicy(&iam.DetachRolePolicyInput{
+			RoleName:  aws.String(roleName),
+			PolicyArn: policy.PolicyArn,
+		})
+		if err != nil {
+			return fmt.Errorf("could not detach policy %s: %w", aws.StringValue(policy.PolicyArn), err)
+		}
+		if err := deletePolicy(iamClient, aws.StringValue(policy.PolicyArn)); err != nil {
+			return err
+		}
+	}
+
+	_, err = iamClient.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(refreshPolicyName),
+	})
+	if err != nil && !isNoSuchEntity(err) {
+		return fmt.Errorf("could not delete inline policy for role %s: %w", roleName, err)
+	}
+
+	if _, err := iamClient.DeleteRole(&iam.DeleteRoleInput{RoleName: aws.String(roleName)}); err != nil {
+		return fmt.Errorf("could not delete role %s: %w", roleName, err)
+	}
+	return nil
+}
+
+// deletePolicy deletes a managed policy along with its non-default versions,
+// which must be removed first.
+func deletePolicy(iamClient iamiface.IAMAPI, policyARN string) error {
+	versions, err := iamClient.ListPolicyVersions(&iam.ListPolicyVersionsInput{
+		PolicyArn: aws.String(policyARN),
+	})
+	if err != nil {
+		return fmt.Errorf("could not list versions of policy %s: %w", policyARN, err)
+	}
+	for _, version := range versions.Versions {
+		if aws.BoolValue(version.IsDefaultVersion) {
+			continue
+		}
+		_, err := iamClient.DeletePolicyVersion(&iam.DeletePolicyVersionInput{
+			PolicyArn: aws.String(policyARN),
+			VersionId: version.VersionId,
+		})
+		if err != nil {
+			return fmt.Errorf("could not delete version of policy %s: %w", policyARN, err)
+		}
+	}
+
+	if _, err := iamClient.DeletePolicy(&iam.DeletePolicyInput{PolicyArn: aws.String(policyARN)}); err != nil {
+		return fmt.Errorf("could not delete policy %s: %w", policyARN, err)
+	}
+	return nil
+}
+
+// RevokeExpiredBindings deletes the IAM users and roles under iamPath named
+// with userPrefix whose expiry tag has passed, along with their access keys
+// and policies.
+func RevokeExpiredBindings(iamClient iamiface.IAMAPI, iamPath string, userPrefix string, now time.Time) error {
+	if userPrefix == "" {
+		return fmt.Errorf("a user prefix is required to revoke expired bindings")
+	}
+	log.Println("Revoking expired bindings")
+
+	principals, err := listBindingPrincipals(iamClient, iamPath)
+	if err != nil {
+		return err
+	}
+
+	for _, principal := range principals {
+		if !strings.HasPrefix(principal.name, userPrefix+"-") {
+			continue
+		}
+
+		tags, err := principalTags(iamClient, principal)
+		if err != nil {
+			return err
+		}
+		isExpired, err := expired(tags, now)
+		if err != nil {
+			log.Printf("skipping %s %s: %s", principal.kind, principal.name, err)
+			continue
+		}
+		if !isExpired {
+			continue
+		}
+
+		log.Printf("revoking expired binding %s %s", principal.kind, principal.name)
+		if principal.kind == "role" {
+			err = revokeRole(iamClient, principal.name, iamPath)
+		} else {
+			err = revokeUser(iamClient, principal.name, iamPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package iam
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// fakeIAM holds users and roles with their tags, creation dates and attached
+// policies, and records what is detached and deleted.
+type fakeIAM struct {
+	iamiface.IAMAPI
+
+	userTags      map[string][]*iam.Tag
+	roleTags      map[string][]*iam.Tag
+	roleCreated   map[string]time.Time
+	rolePolicies  map[string][]string
+	policyVersion map[string][]string
+
+	deletedUsers        []string
+	deletedRoles        []string
+	deletedRolePolicies []string
+	detachedPolicies    []string
+	deletedVersions     []string
+	deletedPolicies     []string
+}
+
+func (f *fakeIAM) ListUsersPages(input *iam.ListUsersInput, fn func(*iam.ListUsersOutput, bool) bool) error {
+	page := &iam.ListUsersOutput{}
+	for userName := range f.userTags {
+		page.Users = append(page.Users, &iam.User{UserName: aws.String(userName)})
+	}
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeIAM) ListRolesPages(input *iam.ListRolesInput, fn func(*iam.ListRolesOutput, bool) bool) error {
+	page := &iam.ListRolesOutput{}
+	for roleName := range f.roleTags {
+		page.Roles = append(page.Roles, &iam.Role{
+			RoleName:   aws.String(roleName),
+			CreateDate: aws.Time(f.roleCreated[roleName]),
+		})
+	}
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeIAM) ListUserTags(input *iam.ListUserTagsInput) (*iam.ListUserTagsOutput, error) {
+	return &iam.ListUserTagsOutput{Tags: f.userTags[aws.StringValue(input.UserName)]}, nil
+}
+
+func (f *fakeIAM) ListRoleTags(input *iam.ListRoleTagsInput) (*iam.ListRoleTagsOutput, error) {
+	return &iam.ListRoleTagsOutput{Tags: f.roleTags[aws.StringValue(input.RoleName)]}, nil
+}
+
+func (f *fakeIAM) ListAccessKeys(input *iam.ListAccessKeysInput) (*iam.ListAccessKeysOutput, error) {
+	return &iam.ListAccessKeysOutput{}, nil
+}
+
+func (f *fakeIAM) ListAttachedUserPolicies(input *iam.ListAttachedUserPoliciesInput) (*iam.ListAttachedUserPoliciesOutput, error) {
+	return &iam.ListAttachedUserPoliciesOutput{}, nil
+}
+
+func (f *fakeIAM) ListAttachedRolePolicies(input *iam.ListAttachedRolePoliciesInput) (*iam.ListAttachedRolePoliciesOutput, error) {
+	output := &iam.ListAttachedRolePoliciesOutput{}
+	for _, policyARN := range f.rolePolicies[aws.StringValue(input.RoleName)] {
+		output.AttachedPolicies = append(output.AttachedPolicies, &iam.AttachedPolicy{PolicyArn: aws.String(policyARN)})
+	}
+	return output, nil
+}
+
+func (f *fakeIAM) DetachRolePolicy(input *iam.DetachRolePolicyInput) (*iam.DetachRolePolicyOutput, error) {
+	f.detachedPolicies = append(f.detachedPolicies, aws.StringValue(input.PolicyArn))
+	return &iam.DetachRolePolicyOutput{}, nil
+}
+
+func (f *fakeIAM) ListPolicyVersions(input *iam.ListPolicyVersionsInput) (*iam.ListPolicyVersionsOutput, error) {
+	output := &iam.ListPolicyVersionsOutput{}
+	for i, versionID := range f.policyVersion[aws.StringValue(input.PolicyArn)] {
+		output.Versions = append(output.Versions, &iam.PolicyVersion{
+			VersionId:        aws.String(versionID),
+			IsDefaultVersion: aws.Bool(i == 0),
+		})
+	}
+	return output, nil
+}
+
+func (f *fakeIAM) DeletePolicyVersion(input *iam.DeletePolicyVersionInput) (*iam.DeletePolicyVersionOutput, error) {
+	f.deletedVersions = append(f.deletedVersions, aws.StringValue(input.VersionId))
+	return &iam.DeletePolicyVersionOutput{}, nil
+}
+
+func (f *fakeIAM) DeletePolicy(input *iam.DeletePolicyInput) (*iam.DeletePolicyOutput, error) {
+	f.deletedPolicies = append(f.deletedPolicies, aws.StringValue(input.PolicyArn))
+	return &iam.DeletePolicyOutput{}, nil
+}
+
+func (f *fakeIAM) DeleteUser(input *iam.DeleteUserInput) (*iam.DeleteUserOutput, error) {
+	f.deletedUsers = append(f.deletedUsers, aws.StringValue(input.UserName))
+	return &iam.DeleteUserOutput{}, nil
+}
+
+func (f *fakeIAM) DeleteRolePolicy(input *iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error) {
+	f.deletedRolePolicies = append(f.deletedRolePolicies, aws.StringValue(input.RoleName)+"/"+aws.StringValue(input.PolicyName))
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+func (f *fakeIAM) DeleteRole(input *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	f.deletedRoles = append(f.deletedRoles, aws.StringValue(input.RoleName))
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+func TestRevokeExpiredBindings(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	expiredTags := []*iam.Tag{{Key: aws.String(expiresAtTagKey), Value: aws.String("2024-01-01T00:00:00Z")}}
+	currentTags := []*iam.Tag{{Key: aws.String(expiresAtTagKey), Value: aws.String("2024-01-03T00:00:00Z")}}
+
+	iamClient := &fakeIAM{
+		userTags: map[string][]*iam.Tag{
+			"cf-binding1":  expiredTags,
+			"cf-binding2":  currentTags,
+			"cf-binding3":  nil,
+			"someone-else": expiredTags,
+		},
+		roleTags: map[string][]*iam.Tag{
+			"cf-binding4":  expiredTags,
+			"other-role-1": expiredTags,
+		},
+	}
+
+	if err := RevokeExpiredBindings(iamClient, "/", "cf", now); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(iamClient.deletedUsers, []string{"cf-binding1"}) {
+		t.Errorf("expected only cf-binding1 to be deleted, got %v", iamClient.deletedUsers)
+	}
+	if !slices.Equal(iamClient.deletedRoles, []string{"cf-binding4"}) {
+		t.Errorf("expected only cf-binding4 to be deleted, got %v", iamClient.deletedRoles)
+	}
+}
+
+func TestRevokeExpiredBindingsRequiresUserPrefix(t *testing.T) {
+	iamClient := &fakeIAM{
+		userTags: map[string][]*iam.Tag{
+			"someone-else": {{Key: aws.String(expiresAtTagKey), Value: aws.String("2024-01-01T00:00:00Z")}},
+		},
+	}
+
+	if err := RevokeExpiredBindings(iamClient, "/", "", time.Now()); err == nil {
+		t.Fatal("expected an error without a user prefix")
+	}
+	if len(iamClient.deletedUsers) > 0 {
+		t.Errorf("expected no users to be deleted, got %v", iamClient.deletedUsers)
+	}
+}
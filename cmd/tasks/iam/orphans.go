@@ -0,0 +1,171 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// orphanGracePeriod keeps users, roles and policies that were created
+// recently out of the orphans, since the broker creates them before the
+// platform records the binding, and attaches policies only after creating
+// them.
+const orphanGracePeriod = time.Hour
+
+// FindOrphanedUsers returns the binding users under iamPath whose binding no
+// longer exists in Cloud Foundry. Binding users are named userPrefix-<binding
+// GUID>.
+func FindOrphanedUsers(iamClient iamiface.IAMAPI, cfClient *cf.Client, iamPath, userPrefix string, now time.Time) ([]string, error) {
+	if userPrefix == "" {
+		return nil, fmt.Errorf("a user prefix is required to find orphaned users")
+	}
+
+	created := map[string]time.Time{}
+	err := iamClient.ListUsersPages(&iam.ListUsersInput{
+		PathPrefix: aws.String(iamPath),
+	}, func(page *iam.ListUsersOutput, lastPage bool) bool {
+		for _, user := range page.Users {
+			created[aws.StringValue(user.UserName)] = aws.TimeValue(user.CreateDate)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %w", err)
+	}
+	return findOrphanedPrincipals("user", created, userPrefix, now, cfBindingExists(cfClient))
+}
+
+// FindOrphanedRoles returns the binding roles under iamPath whose binding no
+// longer exists in Cloud Foundry. Binding roles are named like binding users;
+// replication roles, named userPrefix-<instance GUID>-replication, are left
+// alone.
+func FindOrphanedRoles(iamClient iamiface.IAMAPI, cfClient *cf.Client, iamPath, userPrefix string, now time.Time) ([]string, error) {
+	return findOrphanedRoles(iamClient, iamPath, userPrefix, now, cfBindingExists(cfClient))
+}
+
+func findOrphanedRoles(iamClient iamiface.IAMAPI, iamPath, userPrefix string, now time.Time, bindingExists func(string) (bool, error)) ([]string, error) {
+	if userPrefix == "" {
+		return nil, fmt.Errorf("a user prefix is required to find orphaned roles")
+	}
+
+	created := map[string]time.Time{}
+	err := iamClient.ListRolesPages(&iam.ListRolesInput{
+		PathPrefix: aws.String(iamPath),
+	}, func(page *iam.ListRolesOutput, lastPage bool) bool {
+		for _, role := range page.Roles {
+			roleName := aws.StringValue(role.RoleName)
+			if strings.HasSuffix(roleName, "-replication") {
+				continue
+			}
+			created[roleName] = aws.TimeValue(role.CreateDate)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles: %w", err)
+	}
+	return findOrphanedPrincipals("role", created, userPrefix, now, bindingExists)
+}
+
+// findOrphanedPrincipals returns, in name order, the users or roles named
+// userPrefix-<binding GUID> that are older than orphanGracePeriod and whose
+// binding does not exist.
+func findOrphanedPrincipals(kind string, created map[string]time.Time, userPrefix string, now time.Time, bindingExists func(string) (bool, error)) ([]string, error) {
+	var orphans []string
+	for _, name := range slices.Sorted(maps.Keys(created)) {
+		bindingGUID, ok := strings.CutPrefix(name, userPrefix+"-")
+		if !ok || created[name].After(now.Add(-orphanGracePeriod)) {
+			continue
+		}
+
+		exists, err := bindingExists(bindingGUID)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			continue
+		}
+		log.Printf("%s %s is orphaned: binding %s not found", kind, name, bindingGUID)
+		orphans = append(orphans, name)
+	}
+	return orphans, nil
+}
+
+// cfBindingExists returns a function that reports whether Cloud Foundry
+// knows a service credential binding.
+func cfBindingExists(cfClient *cf.Client) func(string) (bool, error) {
+	return func(bindingGUID string) (bool, error) {
+		_, err := cfClient.ServiceCredentialBindings.Get(context.Background(), bindingGUID)
+		if err == nil {
+			return true, nil
+		}
+		if !resource.IsResourceNotFoundError(err) {
+			return false, fmt.Errorf("could not get binding %s: %w", bindingGUID, err)
+		}
+		return false, nil
+	}
+}
+
+// FindOrphanedPolicies returns the ARNs of the policies under iamPath named
+// with policyPrefix that are attached to no user or role.
+func FindOrphanedPolicies(iamClient iamiface.IAMAPI, iamPath, policyPrefix string, now time.Time) ([]string, error) {
+	if policyPrefix == "" {
+		return nil, fmt.Errorf("a policy prefix is required to find orphaned policies")
+	}
+
+	var orphans []string
+	err := iamClient.ListPoliciesPages(&iam.ListPoliciesInput{
+		Scope:      aws.String(iam.PolicyScopeTypeLocal),
+		PathPrefix: aws.String(iamPath),
+	}, func(page *iam.ListPoliciesOutput, lastPage bool) bool {
+		for _, policy := range page.Policies {
+			if !strings.HasPrefix(aws.StringValue(policy.PolicyName), policyPrefix+"-") ||
+				aws.Int64Value(policy.AttachmentCount) > 0 ||
+				aws.TimeValue(policy.CreateDate).After(now.Add(-orphanGracePeriod)) {
+				continue
+			}
+			log.Printf("policy %s is orphaned: not attached", aws.StringValue(policy.PolicyName))
+			orphans = append(orphans, aws.StringValue(policy.Arn))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing policies: %w", err)
+	}
+	return orphans, nil
+}
+
+// DeleteOrphans deletes orphaned users, with their access keys and policies,
+// and orphaned roles, with their inline and attached policies, the way the
+// broker unbinds them, and then orphaned policies.
+func DeleteOrphans(iamClient iamiface.IAMAPI, iamPath string, userNames, roleNames, policyARNs []string) error {
+	for _, userName := range userNames {
+		log.Printf("deleting orphaned user %s", userName)
+		if err := revokeUser(iamClient, userName, iamPath); err != nil {
+			return err
+		}
+	}
+	for _, roleName := range roleNames {
+		log.Printf("deleting orphaned role %s", roleName)
+		if err := revokeRole(iamClient, roleName, iamPath); err != nil {
+			return err
+		}
+	}
+	for _, policyARN := range policyARNs {
+		log.Printf("deleting orphaned policy %s", policyARN)
+		if err := deletePolicy(iamClient, policyARN); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package iam
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestFindOrphanedRoles(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-2 * time.Hour)
+
+	iamClient := &fakeIAM{
+		roleTags: map[string][]*iam.Tag{
+			"cf-gone":                      nil,
+			"cf-bound":                     nil,
+			"cf-recent":                    nil,
+			"cf-instance-guid-replication": nil,
+			"other-gone":                   nil,
+		},
+		roleCreated: map[string]time.Time{
+			"cf-gone":                      old,
+			"cf-bound":                     old,
+			"cf-recent":                    now.Add(-time.Minute),
+			"cf-instance-guid-replication": old,
+			"other-gone":                   old,
+		},
+	}
+	bindingExists := func(bindingGUID string) (bool, error) {
+		return bindingGUID == "bound", nil
+	}
+
+	orphans, err := findOrphanedRoles(iamClient, "/", "cf", now, bindingExists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(orphans, []string{"cf-gone"}) {
+		t.Errorf("expected only cf-gone to be orphaned, got %v", orphans)
+	}
+}
+
+func TestFindOrphanedRolesRequiresUserPrefix(t *testing.T) {
+	bindingExists := func(string) (bool, error) { return false, nil }
+	if _, err := findOrphanedRoles(&fakeIAM{}, "/", "", time.Now(), bindingExists); err == nil {
+		t.Fatal("expected an error without a user prefix")
+	}
+}
+
+func TestDeleteOrphansDeletesRolePolicies(t *testing.T) {
+	policyARN := "arn:aws:iam::123456789012:policy/cf-gone"
+	iamClient := &fakeIAM{
+		rolePolicies:  map[string][]string{"cf-gone": {policyARN}},
+		policyVersion: map[string][]string{policyARN: {"v2", "v1"}},
+	}
+
+	if err := DeleteOrphans(iamClient, "/", nil, []string{"cf-gone"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(iamClient.deletedRolePolicies, []string{"cf-gone/" + refreshPolicyName}) {
+		t.Errorf("expected the inline policy to be deleted, got %v", iamClient.deletedRolePolicies)
+	}
+	if !slices.Equal(iamClient.detachedPolicies, []string{policyARN}) {
+		t.Errorf("expected %s to be detached, got %v", policyARN, iamClient.detachedPolicies)
+	}
+	if !slices.Equal(iamClient.deletedVersions, []string{"v1"}) {
+		t.Errorf("expected the non-default version to be deleted, got %v", iamClient.deletedVersions)
+	}
+	if !slices.Equal(iamClient.deletedPolicies, []string{policyARN}) {
+		t.Errorf("expected %s to be deleted, got %v", policyARN, iamClient.deletedPolicies)
+	}
+	if !slices.Equal(iamClient.deletedRoles, []string{"cf-gone"}) {
+		t.Errorf("expected cf-gone to be deleted, got %v", iamClient.deletedRoles)
+	}
+}
@@ -0,0 +1,405 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+
+	"github.com/cloud-gov/s3-broker/cmd/tasks/config"
+)
+
+// permissionsTagKey must match awsiam.PermissionsTagKey in the broker.
+const permissionsTagKey = "Binding permissions"
+
+// defaultPermissions is the permissions tag of bindings that use the plan's
+// iam_policy.
+const defaultPermissions = "default"
+
+// maxPolicyVersions is the number of versions IAM keeps of a managed policy.
+const maxPolicyVersions = 5
+
+// bindingTemplate returns the plan's policy template for a binding with the
+// given permissions tag. Bindings made before the broker recorded their
+// permissions have no tag, and can only be told apart on plans that offer a
+// single template.
+func bindingTemplate(plan config.Plan, permissions string, tagged bool) (string, error) {
+	if !tagged {
+		if len(plan.S3Properties.IamPolicies) > 0 {
+			return "", fmt.Errorf("binding does not record its permissions and plan %s offers several", plan.Name)
+		}
+		return plan.S3Properties.IamPolicy, nil
+	}
+	if permissions == defaultPermissions {
+		return plan.S3Properties.IamPolicy, nil
+	}
+	policyTemplate, ok := plan.S3Properties.IamPolicies[permissions]
+	if !ok {
+		return "", fmt.Errorf("plan %s no longer offers %s permissions", plan.Name, permissions)
+	}
+	return policyTemplate, nil
+}
+
+// normalizePolicy indents a policy document with its keys sorted, so that
+// documents differing only in layout compare equal.
+func normalizePolicy(document string) (string, error) {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(document), &parsed); err != nil {
+		return "", err
+	}
+	normalized, err := json.MarshalIndent(parsed, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(normalized), nil
+}
+
+// diffLines returns a line diff of before and after, with removed lines
+// marked "-", added lines "+" and common lines " ".
+func diffLines(before, after []string) []string {
+	// common[i][j] is the length of the longest common subsequence of
+	// before[i:] and after[j:].
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(before) && j < len(after) {
+		switch {
+		case before[i] == after[j]:
+			diff = append(diff, " "+before[i])
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			diff = append(diff, "-"+before[i])
+			i++
+		default:
+			diff = append(diff, "+"+after[j])
+			j++
+		}
+	}
+	for ; i < len(before); i++ {
+		diff = append(diff, "-"+before[i])
+	}
+	for ; j < len(after); j++ {
+		diff = append(diff, "+"+after[j])
+	}
+	return diff
+}
+
+// defaultPolicyDocument returns the document of a managed policy's default
+// version.
+func defaultPolicyDocument(iamClient iamiface.IAMAPI, policyARN string) (string, error) {
+	policy, err := iamClient.GetPolicy(&iam.GetPolicyInput{PolicyArn: aws.String(policyARN)})
+	if err != nil {
+		return "", fmt.Errorf("could not get policy %s: %w", policyARN, err)
+	}
+	version, err := iamClient.GetPolicyVersion(&iam.GetPolicyVersionInput{
+		PolicyArn: aws.String(policyARN),
+		VersionId: policy.Policy.DefaultVersionId,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not get default version of policy %s: %w", policyARN, err)
+	}
+	// IAM returns policy documents URL-encoded.
+	document, err := url.QueryUnescape(aws.StringValue(version.PolicyVersion.Document))
+	if err != nil {
+		return "", fmt.Errorf("could not decode policy %s: %w", policyARN, err)
+	}
+	return document, nil
+}
+
+// publishPolicyVersion makes document the default version of a managed
+// policy, first deleting the oldest version if IAM holds no more.
+func publishPolicyVersion(iamClient iamiface.IAMAPI, policyARN, document string) error {
+	versions, err := iamClient.ListPolicyVersions(&iam.ListPolicyVersionsInput{
+		PolicyArn: aws.String(policyARN),
+	})
+	if err != nil {
+		return fmt.Errorf("could not list versions of policy %s: %w", policyARN, err)
+	}
+	if len(versions.Versions) >= maxPolicyVersions {
+		var oldest *iam.PolicyVersion
+		for _, version := range versions.Versions {
+			if aws.BoolValue(version.IsDefaultVersion) {
+				continue
+			}
+			if oldest == nil || aws.TimeValue(version.CreateDate).Before(aws.TimeValue(oldest.CreateDate)) {
+				oldest = version
+			}
+		}
+		_, err := iamClient.DeletePolicyVersion(&iam.DeletePolicyVersionInput{
+			PolicyArn: aws.String(policyARN),
+			VersionId: oldest.VersionId,
+		})
+		if err != nil {
+			return fmt.Errorf("could not delete version of policy %s: %w", policyARN, err)
+		}
+	}
+
+	_, err = iamClient.CreatePolicyVersion(&iam.CreatePolicyVersionInput{
+		PolicyArn:      aws.String(policyARN),
+		PolicyDocument: aws.String(document),
+		SetAsDefault:   aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("could not create version of policy %s: %w", policyARN, err)
+	}
+	return nil
+}
+
+// bindingPlan returns the catalog plan of a binding's service instance, or
+// nil if the binding no longer exists. Plans are cached by instance.
+func bindingPlan(cfClient *cf.Client, catalog *config.Catalog, instancePlans map[string]config.Plan, bindingGUID string) (*config.Plan, error) {
+	ctx := context.Background()
+	binding, err := cfClient.ServiceCredentialBindings.Get(ctx, bindingGUID)
+	if err != nil {
+		if resource.IsResourceNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not get binding %s: %w", bindingGUID, err)
+	}
+	instanceGUID := binding.Relationships.ServiceInstance.Data.GUID
+	if plan, ok := instancePlans[instanceGUID]; ok {
+		return &plan, nil
+	}
+
+	instance, err := cfClient.ServiceInstances.Get(ctx, instanceGUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get service instance %s: %w", instanceGUID, err)
+	}
+	cfPlan, err := cfClient.ServicePlans.Get(ctx, instance.Relationships.ServicePlan.Data.GUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get plan of service instance %s: %w", instanceGUID, err)
+	}
+	plan, ok := catalog.FindPlan(cfPlan.BrokerCatalog.ID)
+	if !ok {
+		return nil, fmt.Errorf("plan %s is not in the broker's catalog", cfPlan.Name)
+	}
+	instancePlans[instanceGUID] = plan
+	return &plan, nil
+}
+
+// bindingPrincipal is the IAM user or role of a binding.
+type bindingPrincipal struct {
+	kind string // "user" or "role"
+	name string
+}
+
+// listBindingPrincipals returns the users and roles under iamPath.
+func listBindingPrincipals(iamClient iamiface.IAMAPI, iamPath string) ([]bindingPrincipal, error) {
+	var principals []bindingPrincipal
+	err := iamClient.ListUsersPages(&iam.ListUsersInput{
+		PathPrefix: aws.String(iamPath),
+	}, func(page *iam.ListUsersOutput, lastPage bool) bool {
+		for _, user := range page.Users {
+			principals = append(principals, bindingPrincipal{kind: "user", name: aws.StringValue(user.UserName)})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %w", err)
+	}
+	err = iamClient.ListRolesPages(&iam.ListRolesInput{
+		PathPrefix: aws.String(iamPath),
+	}, func(page *iam.ListRolesOutput, lastPage bool) bool {
+		for _, role := range page.Roles {
+			principals = append(principals, bindingPrincipal{kind: "role", name: aws.StringValue(role.RoleName)})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles: %w", err)
+	}
+	return principals, nil
+}
+
+// principalTags returns the tags of a binding's user or role.
+func principalTags(iamClient iamiface.IAMAPI, principal bindingPrincipal) ([]*iam.Tag, error) {
+	if principal.kind == "role" {
+		tags, err := iamClient.ListRoleTags(&iam.ListRoleTagsInput{RoleName: aws.String(principal.name)})
+		if err != nil {
+			return nil, fmt.Errorf("could not get tags for role %s: %w", principal.name, err)
+		}
+		return tags.Tags, nil
+	}
+	tags, err := iamClient.ListUserTags(&iam.ListUserTagsInput{UserName: aws.String(principal.name)})
+	if err != nil {
+		return nil, fmt.Errorf("could not get tags for user %s: %w", principal.name, err)
+	}
+	return tags.Tags, nil
+}
+
+// principalPolicies returns the managed policies under iamPath attached to a
+// binding's user or role.
+func principalPolicies(iamClient iamiface.IAMAPI, principal bindingPrincipal, iamPath string) ([]*iam.AttachedPolicy, error) {
+	if principal.kind == "role" {
+		policies, err := iamClient.ListAttachedRolePolicies(&iam.ListAttachedRolePoliciesInput{
+			RoleName:   aws.String(principal.name),
+			PathPrefix: aws.String(iamPath),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list policies for role %s: %w", principal.name, err)
+		}
+		return policies.AttachedPolicies, nil
+	}
+	policies, err := iamClient.ListAttachedUserPolicies(&iam.ListAttachedUserPoliciesInput{
+		UserName:   aws.String(principal.name),
+		PathPrefix: aws.String(iamPath),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list policies for user %s: %w", principal.name, err)
+	}
+	return policies.AttachedPolicies, nil
+}
+
+// ReconcileBindingPolicies renders the policy of every binding user and role
+// under iamPath again from the current template of its instance's plan, with
+// the same bucket ARNs and prefix, and publishes the result as the policy's new
+// default version where it differs. The differences are written to w; with
+// dryRun, nothing is published.
+//
+// The prefix is read from the policy's s3:prefix condition, so bindings with
+// a prefix on plans whose templates allow no listing are rendered without it.
+// Replication roles are named like binding roles but belong to no binding, so
+// they are skipped.
+func ReconcileBindingPolicies(
+	w io.Writer,
+	iamClient iamiface.IAMAPI,
+	cfClient *cf.Client,
+	catalog *config.Catalog,
+	iamPath string,
+	userPrefix string,
+	policyPrefix string,
+	dryRun bool,
+) error {
+	if userPrefix == "" || policyPrefix == "" {
+		return fmt.Errorf("a user prefix and a policy prefix are required to reconcile policies")
+	}
+	log.Println("Reconciling binding policies")
+
+	principals, err := listBindingPrincipals(iamClient, iamPath)
+	if err != nil {
+		return err
+	}
+
+	instancePlans := map[string]config.Plan{}
+	for _, principal := range principals {
+		bindingGUID, ok := strings.CutPrefix(principal.name, userPrefix+"-")
+		if !ok {
+			continue
+		}
+		if principal.kind == "role" && strings.HasSuffix(bindingGUID, "-replication") {
+			continue
+		}
+
+		plan, err := bindingPlan(cfClient, catalog, instancePlans, bindingGUID)
+		if err != nil {
+			return err
+		}
+		if plan == nil {
+			log.Printf("skipping %s %s: binding %s not found", principal.kind, principal.name, bindingGUID)
+			continue
+		}
+
+		tags, err := principalTags(iamClient, principal)
+		if err != nil {
+			return err
+		}
+		var permissions string
+		tagged := false
+		for _, tag := range tags {
+			if aws.StringValue(tag.Key) == permissionsTagKey {
+				permissions = aws.StringValue(tag.Value)
+				tagged = true
+			}
+		}
+		policyTemplate, err := bindingTemplate(*plan, permissions, tagged)
+		if err != nil {
+			log.Printf("skipping %s %s: %s", principal.kind, principal.name, err)
+			continue
+		}
+
+		policies, err := principalPolicies(iamClient, principal, iamPath)
+		if err != nil {
+			return err
+		}
+		// The binding's policy is rendered from the plan; its key and replica
+		// policies are not.
+		var policyARN string
+		for _, policy := range policies {
+			if aws.StringValue(policy.PolicyName) == policyPrefix+"-"+bindingGUID {
+				policyARN = aws.StringValue(policy.PolicyArn)
+			}
+		}
+		if policyARN == "" {
+			log.Printf("skipping %s %s: no binding policy attached", principal.kind, principal.name)
+			continue
+		}
+
+		current, err := defaultPolicyDocument(iamClient, policyARN)
+		if err != nil {
+			return err
+		}
+		var document map[string]interface{}
+		if err := json.Unmarshal([]byte(current), &document); err != nil {
+			return fmt.Errorf("could not parse policy %s: %w", policyARN, err)
+		}
+		bucketARNs := policyBucketARNs(document)
+		if len(bucketARNs) == 0 {
+			log.Printf("skipping %s %s: policy %s grants no buckets", principal.kind, principal.name, policyARN)
+			continue
+		}
+
+		rendered, err := renderPolicy(policyTemplate, bucketARNs, listingPrefix(document))
+		if err != nil {
+			return fmt.Errorf("could not render policy for %s %s: %w", principal.kind, principal.name, err)
+		}
+		before, err := normalizePolicy(current)
+		if err != nil {
+			return fmt.Errorf("could not parse policy %s: %w", policyARN, err)
+		}
+		after, err := normalizePolicy(rendered)
+		if err != nil {
+			return fmt.Errorf("could not parse rendered policy for %s %s: %w", principal.kind, principal.name, err)
+		}
+		if before == after {
+			continue
+		}
+
+		fmt.Fprintf(w, "--- %s (current)\n+++ %s (plan %s)\n", policyARN, policyARN, plan.Name)
+		for _, line := range diffLines(strings.Split(before, "\n"), strings.Split(after, "\n")) {
+			fmt.Fprintln(w, line)
+		}
+		if dryRun {
+			continue
+		}
+
+		log.Printf("publishing new version of policy %s", policyARN)
+		if err := publishPolicyVersion(iamClient, policyARN, rendered); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package iam
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// listBucketActions must match the listing actions that awsiam.RenderPolicy
+// in the broker confines to a binding's prefix.
+var listBucketActions = []string{"s3:ListBucket", "s3:ListBucketVersions"}
+
+// renderPolicy must match awsiam.RenderPolicy in the broker, so that policies
+// rendered again come out the way binding them would. Both are tested against
+// testdata/rendered-policies.json.
+func renderPolicy(policyTemplate string, resources []string, prefix string) (string, error) {
+	tmpl, err := template.New("policy").Funcs(template.FuncMap{
+		"resources": func(suffix string) string {
+			resourcePaths := make([]string, len(resources))
+			for idx, resource := range resources {
+				if prefix != "" && strings.HasPrefix(suffix, "/") {
+					resourcePaths[idx] = resource + "/" + prefix + strings.TrimPrefix(suffix, "/")
+				} else {
+					resourcePaths[idx] = resource + suffix
+				}
+			}
+			marshaled, _ := json.Marshal(resourcePaths)
+			return string(marshaled)
+		},
+	}).Parse(policyTemplate)
+	if err != nil {
+		return "", err
+	}
+	policy := bytes.Buffer{}
+	err = tmpl.Execute(&policy, map[string]interface{}{
+		"Resource":  resources[0],
+		"Resources": resources,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if prefix == "" {
+		return policy.String(), nil
+	}
+	return restrictToPrefix(policy.String(), prefix)
+}
+
+// restrictToPrefix must match the broker's function of the same name.
+func restrictToPrefix(policy, prefix string) (string, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return "", err
+	}
+
+	var scoped []interface{}
+	for _, s := range policyStatements(document) {
+		statement, ok := s.(map[string]interface{})
+		if !ok || statement["Effect"] != "Allow" || !hasBucketResource(statement) {
+			scoped = append(scoped, s)
+			continue
+		}
+
+		var getActions, listActions []string
+		for _, action := range oneOrMany(statement["Action"]) {
+			switch {
+			case slices.Contains(listBucketActions, action):
+				listActions = append(listActions, action)
+			case strings.HasPrefix(action, "s3:Get"):
+				getActions = append(getActions, action)
+			}
+		}
+
+		if len(getActions) > 0 {
+			statement["Action"] = getActions
+			scoped = append(scoped, statement)
+		}
+		if len(listActions) > 0 {
+			scoped = append(scoped, map[string]interface{}{
+				"Effect":   statement["Effect"],
+				"Action":   listActions,
+				"Resource": statement["Resource"],
+				"Condition": map[string]interface{}{
+					"StringLike": map[string]interface{}{
+						"s3:prefix": []string{prefix + "*"},
+					},
+				},
+			})
+		}
+	}
+	document["Statement"] = scoped
+
+	marshaled, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(marshaled), nil
+}
+
+// hasBucketResource reports whether a statement applies to buckets, rather
+// than only to objects.
+func hasBucketResource(statement map[string]interface{}) bool {
+	for _, resource := range oneOrMany(statement["Resource"]) {
+		if _, key, _ := strings.Cut(resource, ":::"); !strings.Contains(key, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// policyStatements returns the statements of a policy document, which may
+// hold a single statement or a list.
+func policyStatements(document map[string]interface{}) []interface{} {
+	switch statement := document["Statement"].(type) {
+	case []interface{}:
+		return statement
+	case map[string]interface{}:
+		return []interface{}{statement}
+	}
+	return nil
+}
+
+// oneOrMany returns the strings of a policy element that may hold a single
+// string or a list.
+func oneOrMany(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if v, ok := v.(string); ok {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// policyBucketARNs returns the ARNs of the buckets a policy document grants
+// access to, in the order they first appear. Bucket ARNs have the form
+// arn:partition:s3:::bucket[/key].
+func policyBucketARNs(document map[string]interface{}) []string {
+	var bucketARNs []string
+	for _, s := range policyStatements(document) {
+		statement, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, resource := range oneOrMany(statement["Resource"]) {
+			parts := strings.SplitN(resource, ":::", 2)
+			if len(parts) != 2 || !strings.HasSuffix(parts[0], ":s3") {
+				continue
+			}
+			bucketName, _, _ := strings.Cut(parts[1], "/")
+			bucketARN := parts[0] + ":::" + bucketName
+			if !slices.Contains(bucketARNs, bucketARN) {
+				bucketARNs = append(bucketARNs, bucketARN)
+			}
+		}
+	}
+	return bucketARNs
+}
+
+// listingPrefix returns the prefix a policy document confines listing to, from
+// the s3:prefix condition restrictToPrefix adds.
+func listingPrefix(document map[string]interface{}) string {
+	for _, s := range policyStatements(document) {
+		statement, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		condition, _ := statement["Condition"].(map[string]interface{})
+		stringLike, _ := condition["StringLike"].(map[string]interface{})
+		for _, prefix := range oneOrMany(stringLike["s3:prefix"]) {
+			return strings.TrimSuffix(prefix, "*")
+		}
+	}
+	return ""
+}
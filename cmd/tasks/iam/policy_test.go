@@ -0,0 +1,67 @@
+package iam
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/cloud-gov/s3-broker/cmd/tasks/config"
+)
+
+// TestRenderPolicyMatchesBroker renders the templates of the broker's sample
+// config and compares them to testdata/rendered-policies.json, which the
+// broker's own tests check awsiam.RenderPolicy against.
+func TestRenderPolicyMatchesBroker(t *testing.T) {
+	catalog, err := config.LoadCatalog("../../../config-sample.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("testdata/rendered-policies.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var renderedPolicies []struct {
+		Plan        string          `json:"plan"`
+		Permissions string          `json:"permissions"`
+		Resources   []string        `json:"resources"`
+		Prefix      string          `json:"prefix"`
+		Policy      json.RawMessage `json:"policy"`
+	}
+	if err := json.Unmarshal(data, &renderedPolicies); err != nil {
+		t.Fatal(err)
+	}
+	if len(renderedPolicies) == 0 {
+		t.Fatal("expected rendered policies")
+	}
+
+	plans := map[string]config.Plan{}
+	for _, plan := range catalog.Plans {
+		plans[plan.Name] = plan
+	}
+	for _, rendered := range renderedPolicies {
+		plan, ok := plans[rendered.Plan]
+		if !ok {
+			t.Fatalf("plan %s is not in the sample config", rendered.Plan)
+		}
+		policyTemplate, err := bindingTemplate(plan, rendered.Permissions, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		policy, err := renderPolicy(policyTemplate, rendered.Resources, rendered.Prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := normalizePolicy(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := normalizePolicy(string(rendered.Policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expected {
+			t.Errorf("plan %s, permissions %s, prefix %q: rendered\n%s\nbroker rendered\n%s", rendered.Plan, rendered.Permissions, rendered.Prefix, actual, expected)
+		}
+	}
+}
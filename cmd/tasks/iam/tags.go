@@ -0,0 +1,187 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+
+	task_tag "github.com/cloud-gov/s3-broker/cmd/tasks/tags"
+)
+
+// instanceTags holds what the tags of an instance's bindings are generated
+// from.
+type instanceTags struct {
+	planName string
+	guids    brokertags.ResourceGUIDs
+}
+
+// bindingInstanceTags returns what the tags of a binding are generated from,
+// or nil if the binding no longer exists. Instances are cached by GUID.
+func bindingInstanceTags(cfClient *cf.Client, instances map[string]*instanceTags, bindingGUID string) (*instanceTags, error) {
+	ctx := context.Background()
+	binding, err := cfClient.ServiceCredentialBindings.Get(ctx, bindingGUID)
+	if err != nil {
+		if resource.IsResourceNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not get binding %s: %w", bindingGUID, err)
+	}
+	instanceGUID := binding.Relationships.ServiceInstance.Data.GUID
+	if instance, ok := instances[instanceGUID]; ok {
+		return instance, nil
+	}
+
+	instance, err := cfClient.ServiceInstances.Get(ctx, instanceGUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get service instance %s: %w", instanceGUID, err)
+	}
+	plan, err := cfClient.ServicePlans.Get(ctx, instance.Relationships.ServicePlan.Data.GUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get plan of service instance %s: %w", instanceGUID, err)
+	}
+	space, err := cfClient.Spaces.Get(ctx, instance.Relationships.Space.Data.GUID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get space of service instance %s: %w", instanceGUID, err)
+	}
+
+	instances[instanceGUID] = &instanceTags{
+		planName: plan.Name,
+		guids: brokertags.ResourceGUIDs{
+			InstanceGUID:     instanceGUID,
+			SpaceGUID:        space.GUID,
+			OrganizationGUID: space.Relationships.Organization.Data.GUID,
+		},
+	}
+	return instances[instanceGUID], nil
+}
+
+// hasTags reports whether existing holds every tag in generated.
+func hasTags(existing []*iam.Tag, generated []*iam.Tag) bool {
+	values := map[string]string{}
+	for _, tag := range existing {
+		values[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for _, tag := range generated {
+		if value, ok := values[aws.StringValue(tag.Key)]; !ok || value != aws.StringValue(tag.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func convertTagsToIAMTags(tags map[string]string) []*iam.Tag {
+	var iamTags []*iam.Tag
+	for key, value := range tags {
+		iamTags = append(iamTags, &iam.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return iamTags
+}
+
+// ReconcileIAMTags regenerates the tags of every binding user under iamPath
+// named with userPrefix, and of its policies named with policyPrefix, from the
+// instance, space and organization of its binding in Cloud Foundry. Tags that
+// are not generated, like a binding's expiry, are left alone.
+func ReconcileIAMTags(
+	iamClient iamiface.IAMAPI,
+	tagManager brokertags.TagManager,
+	cfClient *cf.Client,
+	iamPath string,
+	userPrefix string,
+	policyPrefix string,
+) error {
+	if userPrefix == "" || policyPrefix == "" {
+		return fmt.Errorf("a user prefix and a policy prefix are required to reconcile IAM tags")
+	}
+	log.Println("Reconciling IAM tags")
+
+	var userNames []string
+	err := iamClient.ListUsersPages(&iam.ListUsersInput{
+		PathPrefix: aws.String(iamPath),
+	}, func(page *iam.ListUsersOutput, lastPage bool) bool {
+		for _, user := range page.Users {
+			userNames = append(userNames, aws.StringValue(user.UserName))
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error listing users: %w", err)
+	}
+
+	instances := map[string]*instanceTags{}
+	for _, userName := range userNames {
+		bindingGUID, ok := strings.CutPrefix(userName, userPrefix+"-")
+		if !ok {
+			continue
+		}
+
+		instance, err := bindingInstanceTags(cfClient, instances, bindingGUID)
+		if err != nil {
+			return err
+		}
+		if instance == nil {
+			log.Printf("skipping user %s: binding %s not found", userName, bindingGUID)
+			continue
+		}
+
+		generatedTags, err := task_tag.GenerateTags(tagManager, "S3", instance.planName, instance.guids)
+		if err != nil {
+			return fmt.Errorf("error generating new tags for user %s: %s", userName, err)
+		}
+		iamTags := convertTagsToIAMTags(generatedTags)
+
+		userTags, err := iamClient.ListUserTags(&iam.ListUserTagsInput{UserName: aws.String(userName)})
+		if err != nil {
+			return fmt.Errorf("could not get tags for user %s: %w", userName, err)
+		}
+		if !hasTags(userTags.Tags, iamTags) {
+			log.Printf("tagging user %s", userName)
+			_, err := iamClient.TagUser(&iam.TagUserInput{
+				UserName: aws.String(userName),
+				Tags:     iamTags,
+			})
+			if err != nil {
+				return fmt.Errorf("could not tag user %s: %w", userName, err)
+			}
+		}
+
+		policies, err := iamClient.ListAttachedUserPolicies(&iam.ListAttachedUserPoliciesInput{
+			UserName:   aws.String(userName),
+			PathPrefix: aws.String(iamPath),
+		})
+		if err != nil {
+			return fmt.Errorf("could not list policies for user %s: %w", userName, err)
+		}
+		// Besides the binding's policy, users of some plans have key and
+		// replica policies named after the binding.
+		for _, policy := range policies.AttachedPolicies {
+			if !strings.HasPrefix(aws.StringValue(policy.PolicyName), policyPrefix+"-"+bindingGUID) {
+				continue
+			}
+			policyTags, err := iamClient.ListPolicyTags(&iam.ListPolicyTagsInput{PolicyArn: policy.PolicyArn})
+			if err != nil {
+				return fmt.Errorf("could not get tags for policy %s: %w", aws.StringValue(policy.PolicyArn), err)
+			}
+			if hasTags(policyTags.Tags, iamTags) {
+				continue
+			}
+			log.Printf("tagging policy %s", aws.StringValue(policy.PolicyName))
+			_, err = iamClient.TagPolicy(&iam.TagPolicyInput{
+				PolicyArn: policy.PolicyArn,
+				Tags:      iamTags,
+			})
+			if err != nil {
+				return fmt.Errorf("could not tag policy %s: %w", aws.StringValue(policy.PolicyArn), err)
+			}
+		}
+	}
+
+	return nil
+}
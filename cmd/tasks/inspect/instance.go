@@ -0,0 +1,333 @@
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+
+	tasksS3 "github.com/cloud-gov/s3-broker/cmd/tasks/s3"
+)
+
+// notConfiguredCodes are the S3 error codes for bucket settings that are
+// simply not set.
+var notConfiguredCodes = []string{
+	"NoSuchBucketPolicy",
+	"NoSuchTagSet",
+	"NoSuchPublicAccessBlockConfiguration",
+	"OwnershipControlsNotFoundError",
+	"ServerSideEncryptionConfigurationNotFoundError",
+}
+
+// describe formats the result of reading one setting. Settings that cannot
+// be read are reported rather than stopping the inspection, since a broken
+// bucket is usually why it is being inspected.
+func describe(value string, err error) string {
+	if err == nil {
+		return value
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		for _, code := range notConfiguredCodes {
+			if awsErr.Code() == code {
+				return "none"
+			}
+		}
+	}
+	return "error: " + err.Error()
+}
+
+func section(w io.Writer, title string) {
+	fmt.Fprintf(w, "\n%s\n", title)
+}
+
+func row(w io.Writer, name, value string) {
+	fmt.Fprintf(w, "  %s\t%s\n", name, value)
+}
+
+// Instance prints the Cloud Foundry instance with instanceGUID, its bucket's
+// settings and the IAM users and roles that bind to it.
+func Instance(
+	w io.Writer,
+	s3Client s3iface.S3API,
+	iamClient iamiface.IAMAPI,
+	cfClient *cf.Client,
+	environment string,
+	iamPath string,
+	instanceGUID string,
+	now time.Time,
+) error {
+	if instanceGUID == "" {
+		return fmt.Errorf("an instance GUID is required")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	writeCFInstance(tw, cfClient, instanceGUID)
+	writeBucket(tw, s3Client, tasksS3.BucketName(environment, instanceGUID))
+	if err := writeBindings(tw, iamClient, iamPath, instanceGUID, now); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+func writeCFInstance(w io.Writer, cfClient *cf.Client, instanceGUID string) {
+	ctx := context.Background()
+	section(w, "Cloud Foundry instance "+instanceGUID)
+
+	instance, err := cfClient.ServiceInstances.Get(ctx, instanceGUID)
+	if err != nil {
+		row(w, "Status", "not found: "+err.Error())
+		return
+	}
+	row(w, "Name", instance.Name)
+	if instance.LastOperation.Type != "" {
+		row(w, "Last operation", fmt.Sprintf("%s %s", instance.LastOperation.Type, instance.LastOperation.State))
+	}
+
+	plan, err := cfClient.ServicePlans.Get(ctx, instance.Relationships.ServicePlan.Data.GUID)
+	if err != nil {
+		row(w, "Plan", describe("", err))
+	} else {
+		row(w, "Plan", plan.Name)
+	}
+
+	space, err := cfClient.Spaces.Get(ctx, instance.Relationships.Space.Data.GUID)
+	if err != nil {
+		row(w, "Space", describe("", err))
+		return
+	}
+	row(w, "Space", fmt.Sprintf("%s (%s)", space.Name, space.GUID))
+
+	org, err := cfClient.Organizations.Get(ctx, space.Relationships.Organization.Data.GUID)
+	if err != nil {
+		row(w, "Organization", describe("", err))
+		return
+	}
+	row(w, "Organization", fmt.Sprintf("%s (%s)", org.Name, org.GUID))
+}
+
+func writeBucket(w io.Writer, s3Client s3iface.S3API, bucketName string) {
+	bucket := aws.String(bucketName)
+	section(w, "Bucket "+bucketName)
+
+	location, err := s3Client.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: bucket})
+	if err != nil {
+		row(w, "Status", describe("", err))
+		return
+	}
+	// Buckets in us-east-1 have no location constraint.
+	region := aws.StringValue(location.LocationConstraint)
+	if region == "" {
+		region = "us-east-1"
+	}
+	row(w, "Region", region)
+
+	versioning, err := s3Client.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: bucket})
+	if err == nil {
+		status := aws.StringValue(versioning.Status)
+		if status == "" {
+			status = "never enabled"
+		}
+		row(w, "Versioning", status)
+	} else {
+		row(w, "Versioning", describe("", err))
+	}
+
+	encryption, err := s3Client.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: bucket})
+	var rules []string
+	if err == nil {
+		for _, rule := range encryption.ServerSideEncryptionConfiguration.Rules {
+			if byDefault := rule.ApplyServerSideEncryptionByDefault; byDefault != nil {
+				description := aws.StringValue(byDefault.SSEAlgorithm)
+				if keyID := aws.StringValue(byDefault.KMSMasterKeyID); keyID != "" {
+					description += " " + keyID
+				}
+				if aws.BoolValue(rule.BucketKeyEnabled) {
+					description += " (bucket key)"
+				}
+				rules = append(rules, description)
+			}
+		}
+	}
+	row(w, "Encryption", describe(strings.Join(rules, ", "), err))
+
+	ownership, err := s3Client.GetBucketOwnershipControls(&s3.GetBucketOwnershipControlsInput{Bucket: bucket})
+	var ownershipRules []string
+	if err == nil {
+		for _, rule := range ownership.OwnershipControls.Rules {
+			ownershipRules = append(ownershipRules, aws.StringValue(rule.ObjectOwnership))
+		}
+	}
+	row(w, "Object ownership", describe(strings.Join(ownershipRules, ", "), err))
+
+	block, err := s3Client.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: bucket})
+	var blockDescription string
+	if err == nil {
+		config := block.PublicAccessBlockConfiguration
+		blockDescription = fmt.Sprintf(
+			"BlockPublicAcls=%t IgnorePublicAcls=%t BlockPublicPolicy=%t RestrictPublicBuckets=%t",
+			aws.BoolValue(config.BlockPublicAcls),
+			aws.BoolValue(config.IgnorePublicAcls),
+			aws.BoolValue(config.BlockPublicPolicy),
+			aws.BoolValue(config.RestrictPublicBuckets),
+		)
+	}
+	row(w, "Public access block", describe(blockDescription, err))
+
+	tagging, err := s3Client.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: bucket})
+	if err != nil {
+		row(w, "Tags", describe("", err))
+	} else {
+		row(w, "Tags", "")
+		tags := map[string]string{}
+		var keys []string
+		for _, tag := range tagging.TagSet {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			keys = append(keys, aws.StringValue(tag.Key))
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			row(w, "  "+key, tags[key])
+		}
+	}
+
+	policy, err := s3Client.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: bucket})
+	if err != nil {
+		row(w, "Policy", describe("", err))
+		return
+	}
+	row(w, "Policy", "")
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(aws.StringValue(policy.Policy)), "    ", "  "); err != nil {
+		indented.Reset()
+		indented.WriteString(aws.StringValue(policy.Policy))
+	}
+	// The policy's lines hold no tabs, so they do not widen the table.
+	fmt.Fprintf(w, "    %s\n", indented.String())
+}
+
+// bindsTo reports whether IAM tags mark a binding to the instance.
+func bindsTo(tags []*iam.Tag, instanceGUID string) bool {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == brokertags.ServiceInstanceGUIDTagKey {
+			return aws.StringValue(tag.Value) == instanceGUID
+		}
+	}
+	return false
+}
+
+func writeBindings(w io.Writer, iamClient iamiface.IAMAPI, iamPath, instanceGUID string, now time.Time) error {
+	section(w, "Bindings")
+	found := false
+
+	var users []*iam.User
+	err := iamClient.ListUsersPages(&iam.ListUsersInput{
+		PathPrefix: aws.String(iamPath),
+	}, func(page *iam.ListUsersOutput, lastPage bool) bool {
+		users = append(users, page.Users...)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error listing users: %w", err)
+	}
+
+	for _, user := range users {
+		userName := user.UserName
+		tags, err := iamClient.ListUserTags(&iam.ListUserTagsInput{UserName: userName})
+		if err != nil {
+			return fmt.Errorf("could not get tags for user %s: %w", aws.StringValue(userName), err)
+		}
+		if !bindsTo(tags.Tags, instanceGUID) {
+			continue
+		}
+		found = true
+
+		row(w, "User", fmt.Sprintf("%s (created %s)", aws.StringValue(userName), aws.TimeValue(user.CreateDate).Format(time.RFC3339)))
+
+		keys, err := iamClient.ListAccessKeys(&iam.ListAccessKeysInput{UserName: userName})
+		if err != nil {
+			row(w, "  Access keys", describe("", err))
+		} else {
+			for _, key := range keys.AccessKeyMetadata {
+				age := int(now.Sub(aws.TimeValue(key.CreateDate)).Hours() / 24)
+				description := fmt.Sprintf("%s, %d days old", aws.StringValue(key.Status), age)
+
+				lastUsed, err := iamClient.GetAccessKeyLastUsed(&iam.GetAccessKeyLastUsedInput{AccessKeyId: key.AccessKeyId})
+				if err == nil && lastUsed.AccessKeyLastUsed.LastUsedDate != nil {
+					description += fmt.Sprintf(", last used %s with %s",
+						aws.TimeValue(lastUsed.AccessKeyLastUsed.LastUsedDate).Format(time.RFC3339),
+						aws.StringValue(lastUsed.AccessKeyLastUsed.ServiceName),
+					)
+				}
+				row(w, "  Access key "+aws.StringValue(key.AccessKeyId), description)
+			}
+		}
+
+		policies, err := iamClient.ListAttachedUserPolicies(&iam.ListAttachedUserPoliciesInput{
+			UserName:   userName,
+			PathPrefix: aws.String(iamPath),
+		})
+		if err != nil {
+			row(w, "  Policies", describe("", err))
+		} else {
+			for _, policy := range policies.AttachedPolicies {
+				row(w, "  Policy", aws.StringValue(policy.PolicyArn))
+			}
+		}
+	}
+
+	var roles []*iam.Role
+	err = iamClient.ListRolesPages(&iam.ListRolesInput{
+		PathPrefix: aws.String(iamPath),
+	}, func(page *iam.ListRolesOutput, lastPage bool) bool {
+		roles = append(roles, page.Roles...)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error listing roles: %w", err)
+	}
+
+	for _, role := range roles {
+		roleName := role.RoleName
+		tags, err := iamClient.ListRoleTags(&iam.ListRoleTagsInput{RoleName: roleName})
+		if err != nil {
+			return fmt.Errorf("could not get tags for role %s: %w", aws.StringValue(roleName), err)
+		}
+		if !bindsTo(tags.Tags, instanceGUID) {
+			continue
+		}
+		found = true
+
+		row(w, "Role", fmt.Sprintf("%s (created %s)", aws.StringValue(roleName), aws.TimeValue(role.CreateDate).Format(time.RFC3339)))
+
+		policies, err := iamClient.ListAttachedRolePolicies(&iam.ListAttachedRolePoliciesInput{
+			RoleName:   roleName,
+			PathPrefix: aws.String(iamPath),
+		})
+		if err != nil {
+			row(w, "  Policies", describe("", err))
+		} else {
+			for _, policy := range policies.AttachedPolicies {
+				row(w, "  Policy", aws.StringValue(policy.PolicyArn))
+			}
+		}
+	}
+
+	if !found {
+		row(w, "None", "")
+	}
+	return nil
+}
@@ -4,19 +4,32 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/s3"
 	brokertags "github.com/cloud-gov/go-broker-tags"
 	config "github.com/cloud-gov/s3-broker/cmd/tasks/config"
+	tasksIAM "github.com/cloud-gov/s3-broker/cmd/tasks/iam"
+	"github.com/cloud-gov/s3-broker/cmd/tasks/inspect"
 	tasksS3 "github.com/cloud-gov/s3-broker/cmd/tasks/s3"
 	cf "github.com/cloudfoundry/go-cfclient/v3/client"
 	cfconfig "github.com/cloudfoundry/go-cfclient/v3/config"
 )
 
 func run() error {
-	actionPtr := flag.String("action", "", "Action to take. Accepted options: 'reconcile-tags'")
+	actionPtr := flag.String("action", "", "Action to take. Accepted options: 'reconcile-tags', 'reconcile-logging', 'reconcile-quotas', 'revoke-expired-bindings', 'usage-report', 'inspect-instance', 'find-orphans', 'reconcile-policies', 'reconcile-buckets', 'reconcile-iam-tags'")
+	dryRunPtr := flag.Bool("dry-run", false, "Print the changes that 'reconcile-policies' would make without making them")
+	fixPtr := flag.Bool("fix", false, "Correct the differences that 'reconcile-buckets' reports")
+	deletePtr := flag.Bool("delete", false, "Delete the orphaned users and policies that 'find-orphans' reports")
+	instancePtr := flag.String("instance", "", "GUID of the service instance for 'inspect-instance'")
+	formatPtr := flag.String("format", tasksS3.ReportFormatCSV, "Output format of 'usage-report'. Accepted options: 'csv', 'json'")
 	flag.Parse()
 	var settings config.Settings
 
@@ -44,6 +57,12 @@ func run() error {
 	}
 
 
+	// Measuring usage may need an S3-compatible endpoint.
+	s3Config := aws.NewConfig()
+	if settings.S3Endpoint != "" {
+		s3Config.WithEndpoint(settings.S3Endpoint).WithS3ForcePathStyle(true)
+	}
+
 	if *actionPtr == "reconcile-tags" {
 		tagManager, err := brokertags.NewCFTagManager(
 			"s3 broker",
@@ -62,6 +81,177 @@ func run() error {
 		}
 	}
 
+	if *actionPtr == "reconcile-iam-tags" {
+		tagManager, err := brokertags.NewCFTagManager(
+			"s3 broker",
+			settings.Environment,
+			settings.CfApiUrl,
+			settings.CfApiClientId,
+			settings.CfApiClientSecret,
+		)
+		if err != nil {
+			return fmt.Errorf("could not initialize tag manager: %s", err)
+		}
+		err = tasksIAM.ReconcileIAMTags(
+			iam.New(sess),
+			tagManager,
+			client,
+			settings.IamPath,
+			settings.UserPrefix,
+			settings.PolicyPrefix,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *actionPtr == "reconcile-logging" {
+		s3Client := s3.New(sess)
+		err = tasksS3.ReconcileS3BucketLogging(s3Client, client, settings.Environment, settings.AccessLogBucket, settings.AccessLogPrefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *actionPtr == "reconcile-quotas" {
+		s3Client := s3.New(sess, s3Config)
+		partition, _ := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), settings.Region)
+		err = tasksS3.ReconcileS3BucketQuotas(
+			s3Client,
+			cloudwatch.New(sess),
+			client,
+			settings.Environment,
+			partition.ID(),
+			settings.QuotaUsageSource,
+			settings.QuotaEnforce,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *actionPtr == "usage-report" {
+		s3Client := s3.New(sess, s3Config)
+		rows, err := tasksS3.BuildUsageReport(
+			s3Client,
+			cloudwatch.New(sess),
+			client,
+			settings.Environment,
+			settings.QuotaUsageSource,
+		)
+		if err != nil {
+			return err
+		}
+		if err := tasksS3.WriteUsageReport(os.Stdout, rows, *formatPtr); err != nil {
+			return err
+		}
+	}
+
+	if *actionPtr == "inspect-instance" {
+		err = inspect.Instance(
+			os.Stdout,
+			s3.New(sess),
+			iam.New(sess),
+			client,
+			settings.Environment,
+			settings.IamPath,
+			*instancePtr,
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *actionPtr == "find-orphans" {
+		iamClient := iam.New(sess)
+		now := time.Now()
+		buckets, err := tasksS3.FindOrphanedBuckets(s3.New(sess), client, settings.Environment)
+		if err != nil {
+			return err
+		}
+		users, err := tasksIAM.FindOrphanedUsers(iamClient, client, settings.IamPath, settings.UserPrefix, now)
+		if err != nil {
+			return err
+		}
+		roles, err := tasksIAM.FindOrphanedRoles(iamClient, client, settings.IamPath, settings.UserPrefix, now)
+		if err != nil {
+			return err
+		}
+		policies, err := tasksIAM.FindOrphanedPolicies(iamClient, settings.IamPath, settings.PolicyPrefix, now)
+		if err != nil {
+			return err
+		}
+
+		for _, bucket := range buckets {
+			fmt.Printf("bucket\t%s\n", bucket)
+		}
+		for _, user := range users {
+			fmt.Printf("user\t%s\n", user)
+		}
+		for _, role := range roles {
+			fmt.Printf("role\t%s\n", role)
+		}
+		for _, policy := range policies {
+			fmt.Printf("policy\t%s\n", policy)
+		}
+
+		if *deletePtr {
+			if err := tasksIAM.DeleteOrphans(iamClient, settings.IamPath, users, roles, policies); err != nil {
+				return err
+			}
+		}
+	}
+
+	if *actionPtr == "reconcile-policies" {
+		catalog, err := config.LoadCatalog(settings.BrokerConfigFile)
+		if err != nil {
+			return err
+		}
+		err = tasksIAM.ReconcileBindingPolicies(
+			os.Stdout,
+			iam.New(sess),
+			client,
+			catalog,
+			settings.IamPath,
+			settings.UserPrefix,
+			settings.PolicyPrefix,
+			*dryRunPtr,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *actionPtr == "reconcile-buckets" {
+		catalog, err := config.LoadCatalog(settings.BrokerConfigFile)
+		if err != nil {
+			return err
+		}
+		partition, _ := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), settings.Region)
+		err = tasksS3.ReconcileS3BucketConfiguration(
+			os.Stdout,
+			s3.New(sess),
+			kms.New(sess),
+			client,
+			catalog,
+			settings.Environment,
+			partition.ID(),
+			*fixPtr,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *actionPtr == "revoke-expired-bindings" {
+		iamClient := iam.New(sess)
+		err = tasksIAM.RevokeExpiredBindings(iamClient, settings.IamPath, settings.UserPrefix, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,68 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// bucketPrefix is the prefix of the names of the buckets the broker creates
+// in environment.
+func bucketPrefix(environment string) string {
+	if environment != "production" {
+		return environment + "-cg-"
+	}
+	return "cg-"
+}
+
+// BucketName returns the name of the bucket of the instance with instanceGUID.
+func BucketName(environment, instanceGUID string) string {
+	return bucketPrefix(environment) + instanceGUID
+}
+
+// forEachInstanceBucket calls fn with each bucket the broker created in
+// environment and the service instance it belongs to. Buckets whose instance
+// cannot be found in Cloud Foundry, like replica buckets, are skipped.
+func forEachInstanceBucket(
+	s3Client s3iface.S3API,
+	cfClient *cf.Client,
+	environment string,
+	fn func(bucketName string, instance *resource.ServiceInstance) error,
+) error {
+	output, err := s3Client.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return fmt.Errorf("error listing buckets: %w", err)
+	}
+
+	prefix := bucketPrefix(environment)
+
+	for _, bucket := range output.Buckets {
+		if bucket == nil || bucket.Name == nil {
+			continue
+		}
+		bucketName := *bucket.Name
+
+		if !strings.HasPrefix(bucketName, prefix) {
+			continue
+		}
+		instanceUUID := strings.TrimPrefix(bucketName, prefix)
+
+		instance, err := cfClient.ServiceInstances.Get(context.Background(), instanceUUID)
+		if err != nil {
+			log.Printf("Could not find service instance for GUID %s", instanceUUID)
+			continue
+		}
+
+		if err := fn(bucketName, instance); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,421 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+
+	"github.com/cloud-gov/s3-broker/cmd/tasks/config"
+)
+
+// notConfigured is how a setting that is absent from a bucket is reported.
+const notConfigured = "none"
+
+// defaultObjectOwnership must match the object ownership the broker gives
+// buckets when none is requested.
+const defaultObjectOwnership = s3.ObjectOwnershipObjectWriter
+
+// blockAllPublicAccess must match the public access block the broker restores
+// on buckets without a public policy.
+var blockAllPublicAccess = &s3.PublicAccessBlockConfiguration{
+	BlockPublicAcls:       aws.Bool(true),
+	BlockPublicPolicy:     aws.Bool(true),
+	IgnorePublicAcls:      aws.Bool(true),
+	RestrictPublicBuckets: aws.Bool(true),
+}
+
+func isAWSErrorCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
+
+// renderBucketPolicy renders a plan's bucket policy template for a bucket.
+// The template data must match the fields of awss3.BucketDetails that bucket
+// policies use.
+func renderBucketPolicy(policyTemplate, bucketName, partition string) (string, error) {
+	tmpl, err := template.New("policy").Parse(policyTemplate)
+	if err != nil {
+		return "", err
+	}
+	policy := bytes.Buffer{}
+	err = tmpl.Execute(&policy, struct {
+		BucketName   string
+		AwsPartition string
+	}{bucketName, partition})
+	if err != nil {
+		return "", err
+	}
+	return policy.String(), nil
+}
+
+// bucketPolicyStatement must match the broker's type of the same name.
+type bucketPolicyStatement struct {
+	Effect    string   `json:"Effect"`
+	Principal string   `json:"Principal"`
+	Action    []string `json:"Action"`
+}
+
+// isPublicPolicy must match the broker's check for bucket policies that grant
+// anonymous read access, for which it removes the public access block.
+func isPublicPolicy(policy string) (bool, error) {
+	if policy == "" {
+		return false, nil
+	}
+	var parsed struct {
+		Statement []bucketPolicyStatement `json:"Statement"`
+	}
+	if err := json.Unmarshal([]byte(policy), &parsed); err != nil {
+		return false, err
+	}
+	if len(parsed.Statement) > 1 {
+		return false, fmt.Errorf("expected 1 policy statement, got %v", len(parsed.Statement))
+	}
+	return slices.ContainsFunc(parsed.Statement, func(statement bucketPolicyStatement) bool {
+		return statement.Effect == "Allow" &&
+			statement.Principal == "*" &&
+			slices.Equal(statement.Action, []string{"s3:GetObject"})
+	}), nil
+}
+
+// comparablePolicy returns a bucket policy without its quota statement as
+// compact JSON with sorted keys, or notConfigured if it has no statements.
+func comparablePolicy(policy string) (string, error) {
+	document, statements, _, err := policyStatements(policy)
+	if err != nil {
+		return "", err
+	}
+	if len(statements) == 0 {
+		return notConfigured, nil
+	}
+	document["Statement"] = statements
+	compact, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(compact), nil
+}
+
+// describeEncryption describes the default encryption rules of a bucket.
+func describeEncryption(encryption *s3.ServerSideEncryptionConfiguration) string {
+	var rules []string
+	for _, rule := range encryption.Rules {
+		byDefault := rule.ApplyServerSideEncryptionByDefault
+		if byDefault == nil {
+			continue
+		}
+		description := aws.StringValue(byDefault.SSEAlgorithm)
+		if keyID := aws.StringValue(byDefault.KMSMasterKeyID); keyID != "" {
+			description += " " + keyID
+		}
+		if aws.BoolValue(rule.BucketKeyEnabled) {
+			description += " (bucket key)"
+		}
+		rules = append(rules, description)
+	}
+	if len(rules) == 0 {
+		return notConfigured
+	}
+	return strings.Join(rules, ", ")
+}
+
+func describePublicAccessBlock(block *s3.PublicAccessBlockConfiguration) string {
+	return fmt.Sprintf(
+		"BlockPublicAcls=%t IgnorePublicAcls=%t BlockPublicPolicy=%t RestrictPublicBuckets=%t",
+		aws.BoolValue(block.BlockPublicAcls),
+		aws.BoolValue(block.IgnorePublicAcls),
+		aws.BoolValue(block.BlockPublicPolicy),
+		aws.BoolValue(block.RestrictPublicBuckets),
+	)
+}
+
+// expectedEncryption returns the default encryption the broker gives a plan's
+// buckets, or nil if the plan leaves it to S3. Buckets of plans with dedicated
+// keys are encrypted with the instance's key, which is found by its alias.
+func expectedEncryption(kmsClient kmsiface.KMSAPI, plan config.Plan, bucketName string) (*s3.ServerSideEncryptionConfiguration, error) {
+	if plan.S3Properties.DedicatedKMSKey {
+		// The alias must match the broker's kmsKeyAlias.
+		key, err := kmsClient.DescribeKey(&kms.DescribeKeyInput{
+			KeyId: aws.String("alias/" + bucketName),
+		})
+		if isAWSErrorCode(err, kms.ErrCodeNotFoundException) {
+			log.Printf("skipping encryption of bucket %s: its key was not found", bucketName)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not describe key of bucket %s: %w", bucketName, err)
+		}
+		return &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{{
+				ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+					SSEAlgorithm:   aws.String(s3.ServerSideEncryptionAwsKms),
+					KMSMasterKeyID: key.KeyMetadata.Arn,
+				},
+				BucketKeyEnabled: aws.Bool(true),
+			}},
+		}, nil
+	}
+	if plan.S3Properties.Encryption == "" {
+		return nil, nil
+	}
+	var encryption s3.ServerSideEncryptionConfiguration
+	if err := json.Unmarshal([]byte(plan.S3Properties.Encryption), &encryption); err != nil {
+		return nil, fmt.Errorf("could not read encryption of plan %s: %w", plan.Name, err)
+	}
+	return &encryption, nil
+}
+
+// reportDrift writes a setting of a bucket that differs from its plan to w.
+func reportDrift(w io.Writer, bucketName, setting, current, expected string) {
+	fmt.Fprintf(w, "%s\t%s\tcurrent: %s\texpected: %s\n", bucketName, setting, current, expected)
+}
+
+// reconcileBucketConfiguration compares a bucket's encryption, policy, public
+// access block and ownership controls with its plan, reports the differences
+// to w, and corrects them if fix is set.
+func reconcileBucketConfiguration(
+	w io.Writer,
+	s3Client s3iface.S3API,
+	kmsClient kmsiface.KMSAPI,
+	plan config.Plan,
+	bucketName string,
+	partition string,
+	fix bool,
+) error {
+	bucket := aws.String(bucketName)
+
+	encryption, err := expectedEncryption(kmsClient, plan, bucketName)
+	if err != nil {
+		return err
+	}
+	if encryption != nil {
+		current := notConfigured
+		output, err := s3Client.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: bucket})
+		if err == nil {
+			current = describeEncryption(output.ServerSideEncryptionConfiguration)
+		} else if !isAWSErrorCode(err, "ServerSideEncryptionConfigurationNotFoundError") {
+			return fmt.Errorf("could not get encryption of bucket %s: %w", bucketName, err)
+		}
+		if expected := describeEncryption(encryption); current != expected {
+			reportDrift(w, bucketName, "encryption", current, expected)
+			if fix {
+				log.Printf("restoring encryption of bucket %s", bucketName)
+				_, err := s3Client.PutBucketEncryption(&s3.PutBucketEncryptionInput{
+					Bucket:                            bucket,
+					ServerSideEncryptionConfiguration: encryption,
+				})
+				if err != nil {
+					return fmt.Errorf("could not put encryption of bucket %s: %w", bucketName, err)
+				}
+			}
+		}
+	}
+
+	ownership := notConfigured
+	ownershipOutput, err := s3Client.GetBucketOwnershipControls(&s3.GetBucketOwnershipControlsInput{Bucket: bucket})
+	if err == nil && len(ownershipOutput.OwnershipControls.Rules) > 0 {
+		ownership = aws.StringValue(ownershipOutput.OwnershipControls.Rules[0].ObjectOwnership)
+	} else if err != nil && !isAWSErrorCode(err, "OwnershipControlsNotFoundError") {
+		return fmt.Errorf("could not get ownership controls of bucket %s: %w", bucketName, err)
+	}
+	// Instances choose their object ownership when they are created, so only
+	// missing ownership controls are drift. Without them S3 behaves as if the
+	// broker's default were set.
+	if ownership == notConfigured {
+		reportDrift(w, bucketName, "ownership controls", ownership, defaultObjectOwnership)
+		if fix {
+			log.Printf("restoring ownership controls of bucket %s", bucketName)
+			_, err := s3Client.PutBucketOwnershipControls(&s3.PutBucketOwnershipControlsInput{
+				Bucket: bucket,
+				OwnershipControls: &s3.OwnershipControls{
+					Rules: []*s3.OwnershipControlsRule{{ObjectOwnership: aws.String(defaultObjectOwnership)}},
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("could not put ownership controls of bucket %s: %w", bucketName, err)
+			}
+		}
+	}
+
+	var expectedPolicy string
+	if plan.S3Properties.BucketPolicy != "" {
+		expectedPolicy, err = renderBucketPolicy(plan.S3Properties.BucketPolicy, bucketName, partition)
+		if err != nil {
+			return fmt.Errorf("could not render policy of plan %s: %w", plan.Name, err)
+		}
+	}
+	public, err := isPublicPolicy(expectedPolicy)
+	if err != nil {
+		return fmt.Errorf("could not read policy of plan %s: %w", plan.Name, err)
+	}
+
+	var currentPolicy string
+	policyOutput, err := s3Client.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: bucket})
+	if err == nil {
+		currentPolicy = aws.StringValue(policyOutput.Policy)
+	} else if !isAWSErrorCode(err, "NoSuchBucketPolicy") {
+		return fmt.Errorf("could not get policy of bucket %s: %w", bucketName, err)
+	}
+	currentComparable, err := comparablePolicy(currentPolicy)
+	if err != nil {
+		return fmt.Errorf("could not read policy of bucket %s: %w", bucketName, err)
+	}
+	expectedComparable, err := comparablePolicy(expectedPolicy)
+	if err != nil {
+		return fmt.Errorf("could not read policy of plan %s: %w", plan.Name, err)
+	}
+	policyDrifted := currentComparable != expectedComparable
+	if policyDrifted {
+		reportDrift(w, bucketName, "bucket policy", currentComparable, expectedComparable)
+	}
+
+	block := notConfigured
+	blockOutput, err := s3Client.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: bucket})
+	if err == nil {
+		block = describePublicAccessBlock(blockOutput.PublicAccessBlockConfiguration)
+	} else if !isAWSErrorCode(err, "NoSuchPublicAccessBlockConfiguration") {
+		return fmt.Errorf("could not get public access block of bucket %s: %w", bucketName, err)
+	}
+	expectedBlock := describePublicAccessBlock(blockAllPublicAccess)
+	if public {
+		expectedBlock = notConfigured
+	}
+	blockDrifted := block != expectedBlock
+	if blockDrifted {
+		reportDrift(w, bucketName, "public access block", block, expectedBlock)
+	}
+
+	if !fix {
+		return nil
+	}
+	// The public access block rejects public policies, so it is removed
+	// before a public policy is put and restored after a private one, the
+	// way the broker does.
+	if public && blockDrifted {
+		if err := fixPublicAccessBlock(s3Client, bucketName, public); err != nil {
+			return err
+		}
+	}
+	if policyDrifted {
+		if err := fixBucketPolicy(s3Client, bucketName, expectedPolicy, currentPolicy); err != nil {
+			return err
+		}
+	}
+	if !public && blockDrifted {
+		if err := fixPublicAccessBlock(s3Client, bucketName, public); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fixPublicAccessBlock(s3Client s3iface.S3API, bucketName string, public bool) error {
+	log.Printf("restoring public access block of bucket %s", bucketName)
+	if public {
+		_, err := s3Client.DeletePublicAccessBlock(&s3.DeletePublicAccessBlockInput{Bucket: aws.String(bucketName)})
+		if err != nil {
+			return fmt.Errorf("could not delete public access block of bucket %s: %w", bucketName, err)
+		}
+		return nil
+	}
+	_, err := s3Client.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+		Bucket:                         aws.String(bucketName),
+		PublicAccessBlockConfiguration: blockAllPublicAccess,
+	})
+	if err != nil {
+		return fmt.Errorf("could not put public access block of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// fixBucketPolicy replaces a bucket's policy with the plan's, keeping the
+// statement that the reconcile-quotas task manages.
+func fixBucketPolicy(s3Client s3iface.S3API, bucketName, expectedPolicy, currentPolicy string) error {
+	log.Printf("restoring policy of bucket %s", bucketName)
+	_, _, quota, err := policyStatements(currentPolicy)
+	if err != nil {
+		return fmt.Errorf("could not read policy of bucket %s: %w", bucketName, err)
+	}
+	document, statements, _, err := policyStatements(expectedPolicy)
+	if err != nil {
+		return err
+	}
+	if quota != nil {
+		statements = append(statements, quota)
+	}
+
+	if len(statements) == 0 {
+		_, err := s3Client.DeleteBucketPolicy(&s3.DeleteBucketPolicyInput{Bucket: aws.String(bucketName)})
+		if err != nil {
+			return fmt.Errorf("could not delete policy of bucket %s: %w", bucketName, err)
+		}
+		return nil
+	}
+
+	document["Statement"] = statements
+	policy, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	_, err = s3Client.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+		Policy: aws.String(string(policy)),
+	})
+	if err != nil {
+		return fmt.Errorf("could not put policy of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// ReconcileS3BucketConfiguration compares the encryption, policy, public
+// access block and ownership controls of every broker bucket with the plan of
+// its instance in catalog, and writes each difference to w as a line of
+// bucket, setting, current and expected value. With fix, it also puts the
+// settings back the way the broker set them.
+func ReconcileS3BucketConfiguration(
+	w io.Writer,
+	s3Client s3iface.S3API,
+	kmsClient kmsiface.KMSAPI,
+	cfClient *cf.Client,
+	catalog *config.Catalog,
+	environment string,
+	partition string,
+	fix bool,
+) error {
+	log.Println("Reconciling bucket configuration")
+
+	cfPlans := map[string]*resource.ServicePlan{}
+	return forEachInstanceBucket(s3Client, cfClient, environment, func(bucketName string, instance *resource.ServiceInstance) error {
+		planGUID := instance.Relationships.ServicePlan.Data.GUID
+		cfPlan, ok := cfPlans[planGUID]
+		if !ok {
+			var err error
+			cfPlan, err = cfClient.ServicePlans.Get(context.Background(), planGUID)
+			if err != nil {
+				return fmt.Errorf("could not get plan %s: %w", planGUID, err)
+			}
+			cfPlans[planGUID] = cfPlan
+		}
+
+		plan, ok := catalog.FindPlan(cfPlan.BrokerCatalog.ID)
+		if !ok {
+			log.Printf("skipping bucket %s: plan %s is not in the broker's catalog", bucketName, cfPlan.Name)
+			return nil
+		}
+		return reconcileBucketConfiguration(w, s3Client, kmsClient, plan, bucketName, partition, fix)
+	})
+}
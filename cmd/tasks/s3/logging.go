@@ -0,0 +1,99 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	brokertags "github.com/cloud-gov/go-broker-tags"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// defaultAccessLogPrefix matches the broker's default access_log_prefix.
+const defaultAccessLogPrefix = "{{.OrganizationGUID}}/{{.SpaceGUID}}/{{.InstanceGUID}}/"
+
+func renderAccessLogPrefix(tmpl *template.Template, guids brokertags.ResourceGUIDs) (string, error) {
+	var prefix strings.Builder
+	if err := tmpl.Execute(&prefix, guids); err != nil {
+		return "", err
+	}
+	return prefix.String(), nil
+}
+
+func processS3BucketLogging(s3Client s3iface.S3API, bucketName string, loggingEnabled *s3.LoggingEnabled) error {
+	response, err := s3Client.GetBucketLogging(&s3.GetBucketLoggingInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return fmt.Errorf("could not get logging for bucket %s: %s", bucketName, err)
+	}
+
+	existing := response.LoggingEnabled
+	if existing != nil &&
+		aws.StringValue(existing.TargetBucket) == aws.StringValue(loggingEnabled.TargetBucket) &&
+		aws.StringValue(existing.TargetPrefix) == aws.StringValue(loggingEnabled.TargetPrefix) {
+		log.Printf("logging already up to date for bucket %s", bucketName)
+		return nil
+	}
+
+	log.Printf("updating logging for bucket %s", bucketName)
+	_, err = s3Client.PutBucketLogging(&s3.PutBucketLoggingInput{
+		Bucket: aws.String(bucketName),
+		BucketLoggingStatus: &s3.BucketLoggingStatus{
+			LoggingEnabled: loggingEnabled,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error updating logging for bucket %s: %s", bucketName, err)
+	}
+
+	log.Printf("finished updating logging for bucket %s", bucketName)
+	return nil
+}
+
+// ReconcileS3BucketLogging turns server access logging back on for every
+// broker bucket whose logging is off or points somewhere other than the
+// configured log bucket and prefix.
+func ReconcileS3BucketLogging(s3Client s3iface.S3API, cfClient *cf.Client, environment, logBucket, logPrefix string) error {
+	if logBucket == "" {
+		return fmt.Errorf("an access log bucket is required to reconcile logging")
+	}
+	if logPrefix == "" {
+		logPrefix = defaultAccessLogPrefix
+	}
+	prefixTemplate, err := template.New("access-log-prefix").Parse(logPrefix)
+	if err != nil {
+		return fmt.Errorf("invalid access log prefix: %w", err)
+	}
+
+	log.Println("Reconciling logging")
+	return forEachInstanceBucket(s3Client, cfClient, environment, func(bucketName string, instance *resource.ServiceInstance) error {
+		spaceGUID := instance.Relationships.Space.Data.GUID
+
+		space, err := cfClient.Spaces.Get(context.Background(), spaceGUID)
+		if err != nil {
+			log.Printf("Could not find space for instance %s", instance.GUID)
+			return nil
+		}
+
+		prefix, err := renderAccessLogPrefix(prefixTemplate, brokertags.ResourceGUIDs{
+			OrganizationGUID: space.Relationships.Organization.Data.GUID,
+			SpaceGUID:        spaceGUID,
+			InstanceGUID:     instance.GUID,
+		})
+		if err != nil {
+			return fmt.Errorf("error generating log prefix for bucket %s: %s", bucketName, err)
+		}
+
+		return processS3BucketLogging(s3Client, bucketName, &s3.LoggingEnabled{
+			TargetBucket: aws.String(logBucket),
+			TargetPrefix: aws.String(prefix),
+		})
+	})
+}
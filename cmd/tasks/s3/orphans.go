@@ -0,0 +1,49 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// replicaSuffix ends the names of the replica buckets of replicated plans.
+const replicaSuffix = "-replica"
+
+// FindOrphanedBuckets returns the broker buckets in environment, including
+// replica buckets, whose service instance no longer exists in Cloud Foundry.
+// Orphaned buckets may hold data, so they are only reported.
+func FindOrphanedBuckets(s3Client s3iface.S3API, cfClient *cf.Client, environment string) ([]string, error) {
+	output, err := s3Client.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing buckets: %w", err)
+	}
+
+	prefix := bucketPrefix(environment)
+	var orphans []string
+	for _, bucket := range output.Buckets {
+		bucketName := aws.StringValue(bucket.Name)
+		instanceGUID, ok := strings.CutPrefix(bucketName, prefix)
+		if !ok {
+			continue
+		}
+		instanceGUID = strings.TrimSuffix(instanceGUID, replicaSuffix)
+
+		_, err := cfClient.ServiceInstances.Get(context.Background(), instanceGUID)
+		if err == nil {
+			continue
+		}
+		if !resource.IsResourceNotFoundError(err) {
+			return nil, fmt.Errorf("could not get service instance %s: %w", instanceGUID, err)
+		}
+		log.Printf("bucket %s is orphaned: service instance %s not found", bucketName, instanceGUID)
+		orphans = append(orphans, bucketName)
+	}
+	return orphans, nil
+}
@@ -0,0 +1,302 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// Sources of bucket usage for ReconcileS3BucketQuotas.
+const (
+	// UsageSourceCloudWatch reads the daily S3 storage metrics from
+	// CloudWatch. They lag by up to a day but cost nothing to read.
+	UsageSourceCloudWatch = "cloudwatch"
+	// UsageSourceList lists every object in the bucket, for S3-compatible
+	// endpoints without CloudWatch.
+	UsageSourceList = "list"
+)
+
+// quotaStatementSid identifies the bucket policy statement that blocks
+// uploads to buckets over quota. It must match awss3.QuotaStatementSid in the
+// broker, which keeps the statement when an update re-applies the policy.
+const quotaStatementSid = "QuotaExceeded"
+
+// Quota is the storage limit of a plan, which the broker publishes in the
+// plan's catalog metadata under "quota". Zero means unlimited.
+type Quota struct {
+	MaxSizeBytes int64 `json:"max_size_bytes"`
+	MaxObjects   int64 `json:"max_objects"`
+}
+
+// Usage is the storage a bucket uses.
+type Usage struct {
+	SizeBytes int64
+	Objects   int64
+}
+
+func (q Quota) exceededBy(usage Usage) bool {
+	return (q.MaxSizeBytes > 0 && usage.SizeBytes > q.MaxSizeBytes) ||
+		(q.MaxObjects > 0 && usage.Objects > q.MaxObjects)
+}
+
+func quotaForPlan(plan *resource.ServicePlan) (Quota, error) {
+	var metadata struct {
+		Quota Quota `json:"quota"`
+	}
+	if plan.BrokerCatalog.Metadata == nil {
+		return Quota{}, nil
+	}
+	if err := json.Unmarshal(*plan.BrokerCatalog.Metadata, &metadata); err != nil {
+		return Quota{}, fmt.Errorf("could not read metadata of plan %s: %w", plan.Name, err)
+	}
+	return metadata.Quota, nil
+}
+
+// bucketUsageFromCloudWatch returns the latest daily storage metrics for a
+// bucket. BucketSizeBytes is reported per storage class, so the classes are
+// summed.
+func bucketUsageFromCloudWatch(cwClient cloudwatchiface.CloudWatchAPI, bucketName string) (Usage, error) {
+	var usage Usage
+
+	metrics, err := cwClient.ListMetrics(&cloudwatch.ListMetricsInput{
+		Namespace:  aws.String("AWS/S3"),
+		MetricName: aws.String("BucketSizeBytes"),
+		Dimensions: []*cloudwatch.DimensionFilter{
+			{Name: aws.String("BucketName"), Value: aws.String(bucketName)},
+		},
+	})
+	if err != nil {
+		return usage, fmt.Errorf("could not list metrics for bucket %s: %w", bucketName, err)
+	}
+	for _, metric := range metrics.Metrics {
+		size, err := latestDailyAverage(cwClient, "BucketSizeBytes", metric.Dimensions)
+		if err != nil {
+			return usage, fmt.Errorf("could not get size of bucket %s: %w", bucketName, err)
+		}
+		usage.SizeBytes += size
+	}
+
+	objects, err := latestDailyAverage(cwClient, "NumberOfObjects", []*cloudwatch.Dimension{
+		{Name: aws.String("BucketName"), Value: aws.String(bucketName)},
+		{Name: aws.String("StorageType"), Value: aws.String("AllStorageTypes")},
+	})
+	if err != nil {
+		return usage, fmt.Errorf("could not get object count of bucket %s: %w", bucketName, err)
+	}
+	usage.Objects = objects
+
+	return usage, nil
+}
+
+func latestDailyAverage(cwClient cloudwatchiface.CloudWatchAPI, metricName string, dimensions []*cloudwatch.Dimension) (int64, error) {
+	now := time.Now()
+	output, err := cwClient.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/S3"),
+		MetricName: aws.String(metricName),
+		Dimensions: dimensions,
+		StartTime:  aws.Time(now.Add(-3 * 24 * time.Hour)),
+		EndTime:    aws.Time(now),
+		Period:     aws.Int64(86400),
+		Statistics: []*string{aws.String(cloudwatch.StatisticAverage)},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var latest *cloudwatch.Datapoint
+	for _, datapoint := range output.Datapoints {
+		if latest == nil || datapoint.Timestamp.After(*latest.Timestamp) {
+			latest = datapoint
+		}
+	}
+	if latest == nil {
+		return 0, nil
+	}
+	return int64(aws.Float64Value(latest.Average)), nil
+}
+
+// bucketUsageFromListing counts the current objects in a bucket and their size.
+func bucketUsageFromListing(s3Client s3iface.S3API, bucketName string) (Usage, error) {
+	var usage Usage
+	err := s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			usage.Objects++
+			usage.SizeBytes += aws.Int64Value(object.Size)
+		}
+		return true
+	})
+	if err != nil {
+		return usage, fmt.Errorf("could not list objects in bucket %s: %w", bucketName, err)
+	}
+	return usage, nil
+}
+
+func validateUsageSource(usageSource string) error {
+	if usageSource != UsageSourceCloudWatch && usageSource != UsageSourceList {
+		return fmt.Errorf("unknown usage source %q", usageSource)
+	}
+	return nil
+}
+
+// bucketUsage measures a bucket's usage from usageSource.
+func bucketUsage(s3Client s3iface.S3API, cwClient cloudwatchiface.CloudWatchAPI, usageSource, bucketName string) (Usage, error) {
+	if usageSource == UsageSourceList {
+		return bucketUsageFromListing(s3Client, bucketName)
+	}
+	return bucketUsageFromCloudWatch(cwClient, bucketName)
+}
+
+// policyStatements parses a bucket policy and returns its statements, which
+// may be a single statement or a list, with the quota statement set apart.
+func policyStatements(policy string) (document map[string]interface{}, statements []interface{}, quota interface{}, err error) {
+	if policy == "" {
+		return map[string]interface{}{"Version": "2012-10-17"}, nil, nil, nil
+	}
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return nil, nil, nil, err
+	}
+	var all []interface{}
+	switch statement := document["Statement"].(type) {
+	case []interface{}:
+		all = statement
+	case map[string]interface{}:
+		all = []interface{}{statement}
+	}
+	for _, s := range all {
+		if statement, ok := s.(map[string]interface{}); ok && statement["Sid"] == quotaStatementSid {
+			quota = s
+			continue
+		}
+		statements = append(statements, s)
+	}
+	return document, statements, quota, nil
+}
+
+// setQuotaStatement adds the statement that denies uploads to a bucket's
+// policy, or removes it, leaving the rest of the policy alone.
+func setQuotaStatement(s3Client s3iface.S3API, bucketName, bucketARN string, deny bool) error {
+	var current string
+	output, err := s3Client.GetBucketPolicy(&s3.GetBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		if !isAWSErrorCode(err, "NoSuchBucketPolicy") {
+			return fmt.Errorf("could not get policy for bucket %s: %w", bucketName, err)
+		}
+	} else {
+		current = aws.StringValue(output.Policy)
+	}
+	document, kept, quota, err := policyStatements(current)
+	if err != nil {
+		return fmt.Errorf("could not read policy for bucket %s: %w", bucketName, err)
+	}
+
+	denied := quota != nil
+	if denied == deny {
+		return nil
+	}
+
+	if deny {
+		log.Printf("blocking uploads to bucket %s", bucketName)
+		kept = append(kept, map[string]interface{}{
+			"Sid":       quotaStatementSid,
+			"Effect":    "Deny",
+			"Principal": "*",
+			"Action":    "s3:PutObject",
+			"Resource":  bucketARN + "/*",
+		})
+	} else {
+		log.Printf("unblocking uploads to bucket %s", bucketName)
+	}
+
+	if len(kept) == 0 {
+		_, err := s3Client.DeleteBucketPolicy(&s3.DeleteBucketPolicyInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting policy for bucket %s: %w", bucketName, err)
+		}
+		return nil
+	}
+
+	document["Statement"] = kept
+	policy, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	_, err = s3Client.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(bucketName),
+		Policy: aws.String(string(policy)),
+	})
+	if err != nil {
+		return fmt.Errorf("error updating policy for bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// ReconcileS3BucketQuotas measures the usage of every broker bucket whose plan
+// has a quota and warns about buckets over it. With enforce, it also denies
+// uploads to those buckets until their usage drops below the quota.
+func ReconcileS3BucketQuotas(
+	s3Client s3iface.S3API,
+	cwClient cloudwatchiface.CloudWatchAPI,
+	cfClient *cf.Client,
+	environment string,
+	partition string,
+	usageSource string,
+	enforce bool,
+) error {
+	if err := validateUsageSource(usageSource); err != nil {
+		return err
+	}
+
+	log.Println("Reconciling quotas")
+	return forEachInstanceBucket(s3Client, cfClient, environment, func(bucketName string, instance *resource.ServiceInstance) error {
+		planGUID := instance.Relationships.ServicePlan.Data.GUID
+
+		plan, err := cfClient.ServicePlans.Get(context.Background(), planGUID)
+		if err != nil {
+			log.Printf("Could not find service plan for instance %s", instance.GUID)
+			return nil
+		}
+		quota, err := quotaForPlan(plan)
+		if err != nil {
+			return err
+		}
+		if quota.MaxSizeBytes == 0 && quota.MaxObjects == 0 {
+			return nil
+		}
+
+		usage, err := bucketUsage(s3Client, cwClient, usageSource, bucketName)
+		if err != nil {
+			return err
+		}
+
+		over := quota.exceededBy(usage)
+		if over {
+			log.Printf(
+				"WARNING: bucket %s of instance %s is over quota: %d bytes in %d objects, plan %s allows %d bytes in %d objects",
+				bucketName, instance.GUID, usage.SizeBytes, usage.Objects, plan.Name, quota.MaxSizeBytes, quota.MaxObjects,
+			)
+		} else {
+			log.Printf("bucket %s is within quota", bucketName)
+		}
+
+		if !enforce {
+			return nil
+		}
+		return setQuotaStatement(s3Client, bucketName, fmt.Sprintf("arn:%s:s3:::%s", partition, bucketName), over)
+	})
+}
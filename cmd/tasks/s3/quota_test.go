@@ -0,0 +1,147 @@
+package s3
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakePolicyS3 holds the policy of a single bucket.
+type fakePolicyS3 struct {
+	s3iface.S3API
+
+	policy         string
+	putPolicyCalls int
+}
+
+func (f *fakePolicyS3) GetBucketPolicy(input *s3.GetBucketPolicyInput) (*s3.GetBucketPolicyOutput, error) {
+	if f.policy == "" {
+		return nil, awserr.New("NoSuchBucketPolicy", "no policy", errors.New("fail"))
+	}
+	return &s3.GetBucketPolicyOutput{Policy: aws.String(f.policy)}, nil
+}
+
+func (f *fakePolicyS3) PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error) {
+	f.putPolicyCalls++
+	f.policy = aws.StringValue(input.Policy)
+	return &s3.PutBucketPolicyOutput{}, nil
+}
+
+func (f *fakePolicyS3) DeleteBucketPolicy(input *s3.DeleteBucketPolicyInput) (*s3.DeleteBucketPolicyOutput, error) {
+	f.policy = ""
+	return &s3.DeleteBucketPolicyOutput{}, nil
+}
+
+// statementSids returns the Sids of the statements in a bucket policy.
+func statementSids(t *testing.T, policy string) []string {
+	t.Helper()
+	if policy == "" {
+		return nil
+	}
+	var document struct {
+		Statement []struct {
+			Sid string
+		}
+	}
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		t.Fatal(err)
+	}
+	var sids []string
+	for _, statement := range document.Statement {
+		sids = append(sids, statement.Sid)
+	}
+	return sids
+}
+
+func TestSetQuotaStatement(t *testing.T) {
+	planPolicy := `{"Version":"2012-10-17","Statement":[{"Sid":"SecureTransport","Effect":"Deny","Principal":"*","Action":"s3:*","Resource":"arn:aws:s3:::b/*","Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`
+
+	testCases := map[string]struct {
+		policy string
+		// expectSids are the statements of the policy while uploads are
+		// denied, and expectRestored the policy once they are allowed again.
+		expectSids     []string
+		expectRestored []string
+	}{
+		"bucket with a policy": {
+			policy:         planPolicy,
+			expectSids:     []string{"SecureTransport", quotaStatementSid},
+			expectRestored: []string{"SecureTransport"},
+		},
+		"bucket without a policy": {
+			expectSids: []string{quotaStatementSid},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s3Client := &fakePolicyS3{policy: tc.policy}
+
+			if err := setQuotaStatement(s3Client, "b", "arn:aws:s3:::b", true); err != nil {
+				t.Fatal(err)
+			}
+			if sids := statementSids(t, s3Client.policy); !slices.Equal(sids, tc.expectSids) {
+				t.Fatalf("expected statements %v, got %v", tc.expectSids, sids)
+			}
+
+			// Enforcing the quota again leaves the policy alone.
+			if err := setQuotaStatement(s3Client, "b", "arn:aws:s3:::b", true); err != nil {
+				t.Fatal(err)
+			}
+			if s3Client.putPolicyCalls != 1 {
+				t.Errorf("expected the policy to be put once, got %d", s3Client.putPolicyCalls)
+			}
+			if sids := statementSids(t, s3Client.policy); !slices.Equal(sids, tc.expectSids) {
+				t.Fatalf("expected statements %v, got %v", tc.expectSids, sids)
+			}
+
+			// Usage dropping under the quota removes the statement.
+			if err := setQuotaStatement(s3Client, "b", "arn:aws:s3:::b", false); err != nil {
+				t.Fatal(err)
+			}
+			if sids := statementSids(t, s3Client.policy); !slices.Equal(sids, tc.expectRestored) {
+				t.Fatalf("expected statements %v, got %v", tc.expectRestored, sids)
+			}
+		})
+	}
+}
+
+func TestQuotaExceededBy(t *testing.T) {
+	testCases := map[string]struct {
+		quota  Quota
+		usage  Usage
+		expect bool
+	}{
+		"unlimited": {
+			usage: Usage{SizeBytes: 1 << 40, Objects: 1 << 20},
+		},
+		"within size": {
+			quota: Quota{MaxSizeBytes: 100},
+			usage: Usage{SizeBytes: 100},
+		},
+		"over size": {
+			quota:  Quota{MaxSizeBytes: 100},
+			usage:  Usage{SizeBytes: 101},
+			expect: true,
+		},
+		"over objects": {
+			quota:  Quota{MaxSizeBytes: 100, MaxObjects: 10},
+			usage:  Usage{SizeBytes: 50, Objects: 11},
+			expect: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if exceeded := tc.quota.exceededBy(tc.usage); exceeded != tc.expect {
+				t.Errorf("expected exceeded %v, got %v", tc.expect, exceeded)
+			}
+		})
+	}
+}
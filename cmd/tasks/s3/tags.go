@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -13,8 +12,12 @@ import (
 	brokertags "github.com/cloud-gov/go-broker-tags"
 	task_tag "github.com/cloud-gov/s3-broker/cmd/tasks/tags"
 	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
 )
 
+// configuredTagKey matches awss3.ConfiguredTagKey in the broker.
+const configuredTagKey = "Configured at"
+
 func getS3BucketTags(s3Client s3iface.S3API, bucketName string) ([]*s3.Tag, error) {
 	response, err := s3Client.GetBucketTagging(&s3.GetBucketTaggingInput{
 		Bucket: aws.String(bucketName),
@@ -59,11 +62,20 @@ func processS3Bucket(s3Client s3iface.S3API, bucketName string, generatedTags []
 		return nil
 	}
 
+	// Keep the tag the broker sets once a new bucket is fully configured,
+	// which it checks when asked about a provision it lost track of.
+	tagSet := append([]*s3.Tag{}, generatedTags...)
+	for _, tag := range existingTags {
+		if aws.StringValue(tag.Key) == configuredTagKey {
+			tagSet = append(tagSet, tag)
+		}
+	}
+
 	log.Printf("updating tags for resource %s", bucketName)
 	_, err = s3Client.PutBucketTagging(&s3.PutBucketTaggingInput{
 		Bucket: aws.String(bucketName),
 		Tagging: &s3.Tagging{
-			TagSet: generatedTags,
+			TagSet: tagSet,
 		},
 	})
 	if err != nil {
@@ -89,37 +101,13 @@ func convertTagsToS3Tags(tags map[string]string) []*s3.Tag {
 
 func ReconcileS3BucketTags(s3Client s3iface.S3API, tagManager brokertags.TagManager, cfClient *cf.Client, environment string) error {
 	log.Println("Reconciling")
-	output, err := s3Client.ListBuckets(&s3.ListBucketsInput{})
-	if err != nil {
-		return fmt.Errorf("error listing buckets: %w", err)
-	}
-
-	for _, bucket := range output.Buckets {
-		if bucket == nil || bucket.Name == nil {
-			continue
-		}
-		bucketName := *bucket.Name
-
-		bucketPrefix := "cg-"
-		if environment != "production" {
-			bucketPrefix = environment + "-" + bucketPrefix
-		}
-		if !strings.HasPrefix(bucketName, bucketPrefix) {
-			continue
-		}
-		instanceUUID := strings.TrimPrefix(bucketName, bucketPrefix)
-
-		instance, err := cfClient.ServiceInstances.Get(context.Background(), instanceUUID)
-		if err != nil {
-			log.Printf("Could not find service instance for GUID %s", instanceUUID)
-			continue
-		}
+	return forEachInstanceBucket(s3Client, cfClient, environment, func(bucketName string, instance *resource.ServiceInstance) error {
 		planGUID := instance.Relationships.ServicePlan.Data.GUID
 
 		plan, err := cfClient.ServicePlans.Get(context.Background(), planGUID)
 		if err != nil {
-			log.Printf("Could not find service plan for instance %s", instanceUUID)
-			continue
+			log.Printf("Could not find service plan for instance %s", instance.GUID)
+			return nil
 		}
 
 		generatedTags, err := task_tag.GenerateTags(
@@ -127,7 +115,7 @@ func ReconcileS3BucketTags(s3Client s3iface.S3API, tagManager brokertags.TagMana
 			"S3",
 			plan.Name,
 			brokertags.ResourceGUIDs{
-				InstanceGUID: instanceUUID,
+				InstanceGUID: instance.GUID,
 			},
 		)
 		if err != nil {
@@ -135,11 +123,6 @@ func ReconcileS3BucketTags(s3Client s3iface.S3API, tagManager brokertags.TagMana
 		}
 
 		s3Tags := convertTagsToS3Tags(generatedTags)
-		err = processS3Bucket(s3Client, bucketName, s3Tags)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+		return processS3Bucket(s3Client, bucketName, s3Tags)
+	})
 }
@@ -0,0 +1,257 @@
+package s3
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	cf "github.com/cloudfoundry/go-cfclient/v3/client"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// Output formats of WriteUsageReport.
+const (
+	ReportFormatCSV  = "csv"
+	ReportFormatJSON = "json"
+)
+
+// bytesPerGB is the size of the GB that S3 storage is priced in.
+const bytesPerGB = 1 << 30
+
+// PlanCost is one of the costs in a plan's catalog metadata, such as
+// {"amount": {"usd": 0.03}, "unit": "Per GB"}.
+type PlanCost struct {
+	Amount map[string]float64 `json:"amount"`
+	Unit   string             `json:"unit"`
+}
+
+// costOf returns the cost, per currency, of an instance on a plan with costs.
+// Costs priced per GB are charged for the instance's storage; any other cost
+// is charged once per instance.
+func costOf(costs []PlanCost, usage Usage) map[string]float64 {
+	total := map[string]float64{}
+	for _, cost := range costs {
+		multiplier := 1.0
+		if strings.Contains(strings.ToUpper(cost.Unit), "GB") {
+			multiplier = float64(usage.SizeBytes) / bytesPerGB
+		}
+		for currency, amount := range cost.Amount {
+			total[currency] += amount * multiplier
+		}
+	}
+	return total
+}
+
+func costsForPlan(plan *resource.ServicePlan) ([]PlanCost, error) {
+	var metadata struct {
+		Costs []PlanCost `json:"costs"`
+	}
+	if plan.BrokerCatalog.Metadata == nil {
+		return nil, nil
+	}
+	if err := json.Unmarshal(*plan.BrokerCatalog.Metadata, &metadata); err != nil {
+		return nil, fmt.Errorf("could not read metadata of plan %s: %w", plan.Name, err)
+	}
+	return metadata.Costs, nil
+}
+
+// UsageReportRow is the usage and cost of one service instance.
+type UsageReportRow struct {
+	OrganizationGUID string             `json:"organization_guid"`
+	OrganizationName string             `json:"organization_name"`
+	SpaceGUID        string             `json:"space_guid"`
+	SpaceName        string             `json:"space_name"`
+	InstanceGUID     string             `json:"instance_guid"`
+	InstanceName     string             `json:"instance_name"`
+	PlanName         string             `json:"plan_name"`
+	BucketName       string             `json:"bucket_name"`
+	SizeBytes        int64              `json:"size_bytes"`
+	Objects          int64              `json:"objects"`
+	Cost             map[string]float64 `json:"cost"`
+}
+
+// usageReportResolver looks up and caches the plans, spaces and organizations
+// of instances, which many instances share.
+type usageReportResolver struct {
+	cfClient *cf.Client
+	plans    map[string]*resource.ServicePlan
+	spaces   map[string]*resource.Space
+	orgs     map[string]*resource.Organization
+}
+
+func (r *usageReportResolver) plan(guid string) (*resource.ServicePlan, error) {
+	if plan, ok := r.plans[guid]; ok {
+		return plan, nil
+	}
+	plan, err := r.cfClient.ServicePlans.Get(context.Background(), guid)
+	if err != nil {
+		return nil, err
+	}
+	r.plans[guid] = plan
+	return plan, nil
+}
+
+func (r *usageReportResolver) space(guid string) (*resource.Space, error) {
+	if space, ok := r.spaces[guid]; ok {
+		return space, nil
+	}
+	space, err := r.cfClient.Spaces.Get(context.Background(), guid)
+	if err != nil {
+		return nil, err
+	}
+	r.spaces[guid] = space
+	return space, nil
+}
+
+func (r *usageReportResolver) org(guid string) (*resource.Organization, error) {
+	if org, ok := r.orgs[guid]; ok {
+		return org, nil
+	}
+	org, err := r.cfClient.Organizations.Get(context.Background(), guid)
+	if err != nil {
+		return nil, err
+	}
+	r.orgs[guid] = org
+	return org, nil
+}
+
+// BuildUsageReport measures the usage of every broker bucket and prices it
+// with the costs in the catalog metadata of the instance's plan.
+func BuildUsageReport(
+	s3Client s3iface.S3API,
+	cwClient cloudwatchiface.CloudWatchAPI,
+	cfClient *cf.Client,
+	environment string,
+	usageSource string,
+) ([]UsageReportRow, error) {
+	if err := validateUsageSource(usageSource); err != nil {
+		return nil, err
+	}
+
+	resolver := &usageReportResolver{
+		cfClient: cfClient,
+		plans:    map[string]*resource.ServicePlan{},
+		spaces:   map[string]*resource.Space{},
+		orgs:     map[string]*resource.Organization{},
+	}
+
+	log.Println("Building usage report")
+	var rows []UsageReportRow
+	err := forEachInstanceBucket(s3Client, cfClient, environment, func(bucketName string, instance *resource.ServiceInstance) error {
+		plan, err := resolver.plan(instance.Relationships.ServicePlan.Data.GUID)
+		if err != nil {
+			log.Printf("Could not find service plan for instance %s", instance.GUID)
+			return nil
+		}
+		space, err := resolver.space(instance.Relationships.Space.Data.GUID)
+		if err != nil {
+			log.Printf("Could not find space for instance %s", instance.GUID)
+			return nil
+		}
+		org, err := resolver.org(space.Relationships.Organization.Data.GUID)
+		if err != nil {
+			log.Printf("Could not find organization for instance %s", instance.GUID)
+			return nil
+		}
+
+		costs, err := costsForPlan(plan)
+		if err != nil {
+			return err
+		}
+		usage, err := bucketUsage(s3Client, cwClient, usageSource, bucketName)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, UsageReportRow{
+			OrganizationGUID: org.GUID,
+			OrganizationName: org.Name,
+			SpaceGUID:        space.GUID,
+			SpaceName:        space.Name,
+			InstanceGUID:     instance.GUID,
+			InstanceName:     instance.Name,
+			PlanName:         plan.Name,
+			BucketName:       bucketName,
+			SizeBytes:        usage.SizeBytes,
+			Objects:          usage.Objects,
+			Cost:             costOf(costs, usage),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].OrganizationName != rows[j].OrganizationName {
+			return rows[i].OrganizationName < rows[j].OrganizationName
+		}
+		if rows[i].SpaceName != rows[j].SpaceName {
+			return rows[i].SpaceName < rows[j].SpaceName
+		}
+		return rows[i].InstanceName < rows[j].InstanceName
+	})
+	return rows, nil
+}
+
+// WriteUsageReport writes rows to w as CSV or JSON. The CSV has a cost_<currency>
+// column for every currency in the report.
+func WriteUsageReport(w io.Writer, rows []UsageReportRow, format string) error {
+	switch format {
+	case ReportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case ReportFormatCSV:
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+
+	currencySet := map[string]bool{}
+	for _, row := range rows {
+		for currency := range row.Cost {
+			currencySet[currency] = true
+		}
+	}
+	var currencies []string
+	for currency := range currencySet {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	header := []string{
+		"organization_guid", "organization_name", "space_guid", "space_name",
+		"instance_guid", "instance_name", "plan_name", "bucket_name", "size_bytes", "objects",
+	}
+	for _, currency := range currencies {
+		header = append(header, "cost_"+currency)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			row.OrganizationGUID, row.OrganizationName, row.SpaceGUID, row.SpaceName,
+			row.InstanceGUID, row.InstanceName, row.PlanName, row.BucketName,
+			strconv.FormatInt(row.SizeBytes, 10), strconv.FormatInt(row.Objects, 10),
+		}
+		for _, currency := range currencies {
+			record = append(record, strconv.FormatFloat(row.Cost[currency], 'f', 2, 64))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
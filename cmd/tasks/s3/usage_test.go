@@ -0,0 +1,217 @@
+package s3
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// fakeCloudWatch reports the daily storage metrics of a single bucket, with
+// its size broken down by storage type as S3 publishes it.
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+
+	sizeByStorageType map[string]float64
+	objects           float64
+}
+
+func (f *fakeCloudWatch) ListMetrics(input *cloudwatch.ListMetricsInput) (*cloudwatch.ListMetricsOutput, error) {
+	output := &cloudwatch.ListMetricsOutput{}
+	for storageType := range f.sizeByStorageType {
+		output.Metrics = append(output.Metrics, &cloudwatch.Metric{
+			Namespace:  input.Namespace,
+			MetricName: input.MetricName,
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("BucketName"), Value: input.Dimensions[0].Value},
+				{Name: aws.String("StorageType"), Value: aws.String(storageType)},
+			},
+		})
+	}
+	return output, nil
+}
+
+func (f *fakeCloudWatch) GetMetricStatistics(input *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	var storageType string
+	for _, dimension := range input.Dimensions {
+		if aws.StringValue(dimension.Name) == "StorageType" {
+			storageType = aws.StringValue(dimension.Value)
+		}
+	}
+	value := f.sizeByStorageType[storageType]
+	if aws.StringValue(input.MetricName) == "NumberOfObjects" {
+		value = f.objects
+	}
+	// An older datapoint checks that the latest one is used.
+	now := time.Now()
+	return &cloudwatch.GetMetricStatisticsOutput{
+		Datapoints: []*cloudwatch.Datapoint{
+			{Timestamp: aws.Time(now.Add(-24 * time.Hour)), Average: aws.Float64(value)},
+			{Timestamp: aws.Time(now.Add(-48 * time.Hour)), Average: aws.Float64(value / 2)},
+		},
+	}, nil
+}
+
+func TestCostOf(t *testing.T) {
+	perGB := PlanCost{Amount: map[string]float64{"usd": 0.25}, Unit: "Per GB"}
+	monthly := PlanCost{Amount: map[string]float64{"usd": 5, "eur": 4}, Unit: "Monthly"}
+
+	testCases := map[string]struct {
+		costs  []PlanCost
+		usage  Usage
+		expect map[string]float64
+	}{
+		"no costs": {
+			usage:  Usage{SizeBytes: 4 * bytesPerGB},
+			expect: map[string]float64{},
+		},
+		"per GB": {
+			costs:  []PlanCost{perGB},
+			usage:  Usage{SizeBytes: 4 * bytesPerGB},
+			expect: map[string]float64{"usd": 1},
+		},
+		"per GB with a partial GB": {
+			costs:  []PlanCost{perGB},
+			usage:  Usage{SizeBytes: bytesPerGB / 2},
+			expect: map[string]float64{"usd": 0.125},
+		},
+		"unit in lower case": {
+			costs:  []PlanCost{{Amount: map[string]float64{"usd": 0.5}, Unit: "per gb-month"}},
+			usage:  Usage{SizeBytes: 2 * bytesPerGB},
+			expect: map[string]float64{"usd": 1},
+		},
+		"once per instance": {
+			costs:  []PlanCost{monthly},
+			usage:  Usage{SizeBytes: 4 * bytesPerGB},
+			expect: map[string]float64{"usd": 5, "eur": 4},
+		},
+		"per GB and once per instance": {
+			costs:  []PlanCost{perGB, monthly},
+			usage:  Usage{SizeBytes: 4 * bytesPerGB},
+			expect: map[string]float64{"usd": 6, "eur": 4},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cost := costOf(tc.costs, tc.usage)
+			if !reflect.DeepEqual(cost, tc.expect) {
+				t.Errorf("expected cost %v, got %v", tc.expect, cost)
+			}
+		})
+	}
+}
+
+func TestCostOfStorageClasses(t *testing.T) {
+	testCases := map[string]struct {
+		sizeByStorageType map[string]float64
+		expectSizeBytes   int64
+		expectCost        map[string]float64
+	}{
+		"standard": {
+			sizeByStorageType: map[string]float64{"StandardStorage": 2 * bytesPerGB},
+			expectSizeBytes:   2 * bytesPerGB,
+			expectCost:        map[string]float64{"usd": 0.5},
+		},
+		"standard and infrequent access": {
+			sizeByStorageType: map[string]float64{
+				"StandardStorage":   2 * bytesPerGB,
+				"StandardIAStorage": 4 * bytesPerGB,
+			},
+			expectSizeBytes: 6 * bytesPerGB,
+			expectCost:      map[string]float64{"usd": 1.5},
+		},
+		"glacier": {
+			sizeByStorageType: map[string]float64{
+				"StandardStorage":       bytesPerGB,
+				"GlacierStorage":        bytesPerGB,
+				"GlacierStagingStorage": bytesPerGB,
+			},
+			expectSizeBytes: 3 * bytesPerGB,
+			expectCost:      map[string]float64{"usd": 0.75},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cwClient := &fakeCloudWatch{sizeByStorageType: tc.sizeByStorageType, objects: 10}
+			usage, err := bucketUsageFromCloudWatch(cwClient, "b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.SizeBytes != tc.expectSizeBytes || usage.Objects != 10 {
+				t.Errorf("expected %d bytes in 10 objects, got %d bytes in %d objects", tc.expectSizeBytes, usage.SizeBytes, usage.Objects)
+			}
+			cost := costOf([]PlanCost{{Amount: map[string]float64{"usd": 0.25}, Unit: "Per GB"}}, usage)
+			if !reflect.DeepEqual(cost, tc.expectCost) {
+				t.Errorf("expected cost %v, got %v", tc.expectCost, cost)
+			}
+		})
+	}
+}
+
+func TestWriteUsageReport(t *testing.T) {
+	rows := []UsageReportRow{
+		{
+			OrganizationGUID: "org-1",
+			OrganizationName: "agency-a",
+			SpaceGUID:        "space-1",
+			SpaceName:        "prod",
+			InstanceGUID:     "instance-1",
+			InstanceName:     "uploads",
+			PlanName:         "basic",
+			BucketName:       "cg-instance-1",
+			SizeBytes:        4 * bytesPerGB,
+			Objects:          1200,
+			Cost:             map[string]float64{"usd": 1},
+		},
+		{
+			OrganizationGUID: "org-2",
+			OrganizationName: "agency-b",
+			SpaceGUID:        "space-2",
+			SpaceName:        "dev, staging",
+			InstanceGUID:     "instance-2",
+			InstanceName:     "records",
+			PlanName:         "records",
+			BucketName:       "cg-instance-2",
+			SizeBytes:        bytesPerGB / 2,
+			Objects:          3,
+			Cost:             map[string]float64{"usd": 5.125, "eur": 4},
+		},
+	}
+
+	testCases := map[string]struct {
+		format string
+		golden string
+	}{
+		"csv":  {format: ReportFormatCSV, golden: "testdata/usage-report.csv"},
+		"json": {format: ReportFormatJSON, golden: "testdata/usage-report.json"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var report bytes.Buffer
+			if err := WriteUsageReport(&report, rows, tc.format); err != nil {
+				t.Fatal(err)
+			}
+			expected, err := os.ReadFile(tc.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.String() != string(expected) {
+				t.Errorf("expected report\n%s\ngot\n%s", expected, report.String())
+			}
+		})
+	}
+
+	t.Run("unknown format", func(t *testing.T) {
+		if err := WriteUsageReport(&bytes.Buffer{}, rows, "xml"); err == nil {
+			t.Error("expected an error for an unknown format")
+		}
+	})
+}
@@ -11,12 +11,16 @@ import (
 )
 
 type Config struct {
-	LogLevel    string        `yaml:"log_level"`
-	Username    string        `yaml:"username"`
-	Password    string        `yaml:"password"`
-	Environment string        `yaml:"environment"`
-	S3Config    broker.Config `yaml:"s3_config"`
-	CFConfig    *CFConfig     `yaml:"cf_config"`
+	LogLevel string `yaml:"log_level"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// AdminUsername and AdminPassword authenticate the operator API under
+	// /admin, which is only served when they are set.
+	AdminUsername string        `yaml:"admin_username"`
+	AdminPassword string        `yaml:"admin_password"`
+	Environment   string        `yaml:"environment"`
+	S3Config      broker.Config `yaml:"s3_config"`
+	CFConfig      *CFConfig     `yaml:"cf_config"`
 }
 
 type CFConfig struct {
@@ -65,6 +69,14 @@ func (c Config) Validate() error {
 		return errors.New("Must provide a non-empty Password")
 	}
 
+	if (c.AdminUsername == "") != (c.AdminPassword == "") {
+		return errors.New("Must provide both AdminUsername and AdminPassword, or neither")
+	}
+
+	if c.AdminUsername != "" && c.AdminUsername == c.Username {
+		return errors.New("AdminUsername must differ from Username")
+	}
+
 	if err := c.S3Config.Validate(); err != nil {
 		return fmt.Errorf("Validating S3 configuration: %s", err)
 	}
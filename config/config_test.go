@@ -1,6 +1,9 @@
 package config_test
 
 import (
+	"encoding/json"
+	"os"
+	"slices"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -8,6 +11,7 @@ import (
 
 	. "github.com/cloud-gov/s3-broker/config"
 
+	"github.com/cloud-gov/s3-broker/awsiam"
 	"github.com/cloud-gov/s3-broker/broker"
 )
 
@@ -68,6 +72,31 @@ var _ = Describe("Config", func() {
 			Expect(err.Error()).To(ContainSubstring("Must provide a non-empty Password"))
 		})
 
+		It("does not return error if admin credentials are set", func() {
+			config.AdminUsername = "admin-username"
+			config.AdminPassword = "admin-password"
+
+			err := config.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error if only one admin credential is set", func() {
+			config.AdminUsername = "admin-username"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Must provide both AdminUsername and AdminPassword, or neither"))
+		})
+
+		It("returns error if the admin username is the broker username", func() {
+			config.AdminUsername = config.Username
+			config.AdminPassword = "admin-password"
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("AdminUsername must differ from Username"))
+		})
+
 		It("returns error if S3 configuration is not valid", func() {
 			config.S3Config = broker.Config{}
 
@@ -76,4 +105,89 @@ var _ = Describe("Config", func() {
 			Expect(err.Error()).To(ContainSubstring("Validating S3 configuration"))
 		})
 	})
+
+	Describe("config-sample.yml", func() {
+		// The reconcile-policies task renders binding policies with its own
+		// copy of awsiam.RenderPolicy, and checks its output against the same
+		// file.
+		It("renders the binding policies the tasks expect", func() {
+			sampleConfig, err := LoadConfig("../config-sample.yml")
+			Expect(err).ToNot(HaveOccurred())
+
+			data, err := os.ReadFile("../cmd/tasks/iam/testdata/rendered-policies.json")
+			Expect(err).ToNot(HaveOccurred())
+			var renderedPolicies []struct {
+				Plan        string          `json:"plan"`
+				Permissions string          `json:"permissions"`
+				Resources   []string        `json:"resources"`
+				Prefix      string          `json:"prefix"`
+				Policy      json.RawMessage `json:"policy"`
+			}
+			Expect(json.Unmarshal(data, &renderedPolicies)).To(Succeed())
+			Expect(renderedPolicies).ToNot(BeEmpty())
+
+			plans := map[string]broker.ServicePlan{}
+			for _, service := range sampleConfig.S3Config.Catalog.Services {
+				for _, plan := range service.Plans {
+					plans[plan.Name] = plan
+				}
+			}
+			for _, rendered := range renderedPolicies {
+				plan, ok := plans[rendered.Plan]
+				Expect(ok).To(BeTrue(), "plan %s is not in the sample config", rendered.Plan)
+				policyTemplate := plan.S3Properties.IamPolicy
+				if rendered.Permissions != "default" {
+					policyTemplate = plan.S3Properties.IamPolicies[rendered.Permissions]
+				}
+
+				policy, err := awsiam.RenderPolicy(policyTemplate, rendered.Resources, rendered.Prefix)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(policy).To(MatchJSON(rendered.Policy), "plan %s, permissions %s, prefix %q", rendered.Plan, rendered.Permissions, rendered.Prefix)
+			}
+		})
+
+		It("does not let prefix bindings change the bucket's configuration", func() {
+			sampleConfig, err := LoadConfig("../config-sample.yml")
+			Expect(err).ToNot(HaveOccurred())
+
+			resources := []string{"arn:aws:s3:::cf-instance"}
+			for _, service := range sampleConfig.S3Config.Catalog.Services {
+				for _, plan := range service.Plans {
+					policyTemplates := map[string]string{"default": plan.S3Properties.IamPolicy}
+					for permissions, policyTemplate := range plan.S3Properties.IamPolicies {
+						policyTemplates[permissions] = policyTemplate
+					}
+					for permissions, policyTemplate := range policyTemplates {
+						if policyTemplate == "" {
+							continue
+						}
+						policy, err := awsiam.RenderPolicy(policyTemplate, resources, "tenant-x/")
+						Expect(err).ToNot(HaveOccurred())
+
+						var document struct {
+							Statement []struct {
+								Effect    string
+								Action    []string
+								Resource  []string
+								Condition map[string]interface{}
+							}
+						}
+						Expect(json.Unmarshal([]byte(policy), &document)).To(Succeed())
+						for _, statement := range document.Statement {
+							if statement.Effect != "Allow" || !slices.Contains(statement.Resource, resources[0]) {
+								continue
+							}
+							for _, action := range statement.Action {
+								if statement.Condition != nil {
+									Expect(action).To(HavePrefix("s3:List"), "plan %s, permissions %s", plan.Name, permissions)
+								} else {
+									Expect(action).To(HavePrefix("s3:Get"), "plan %s, permissions %s", plan.Name, permissions)
+								}
+							}
+						}
+					}
+				}
+			}
+		})
+	})
 })
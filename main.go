@@ -19,6 +19,7 @@ import (
 	"github.com/pivotal-cf/brokerapi/v10"
 
 	"github.com/cloud-gov/s3-broker/awsiam"
+	"github.com/cloud-gov/s3-broker/awskms"
 	"github.com/cloud-gov/s3-broker/awss3"
 	"github.com/cloud-gov/s3-broker/broker"
 	brokerConfig "github.com/cloud-gov/s3-broker/config"
@@ -80,11 +81,22 @@ func main() {
 	s3svc := s3.New(awsSession)
 	s3bucket := awss3.NewS3Bucket(s3svc, logger)
 
+	// Replicated plans create a second bucket in the replica region.
+	var replicaBucket awss3.Bucket
+	if config.S3Config.ReplicaRegion != "" {
+		replicaSession := session.New(awsConfig.Copy().WithRegion(config.S3Config.ReplicaRegion))
+		replicaBucket = awss3.NewS3Bucket(s3.New(replicaSession), logger)
+	}
+
 	user, err := awsiam.NewUser(config.S3Config.Provider, logger, awsSession, config.S3Config.Endpoint, config.S3Config.InsecureSkipVerify)
 	if err != nil {
 		log.Fatalf("Failure to configure user management: %s", err)
 	}
 
+	role := awsiam.NewRole(logger, awsSession)
+
+	key := awskms.NewKey(logger, awsSession)
+
 	var client *cf.Client
 	if config.CFConfig != nil {
 		cfConfig, err := cfconfig.New(config.CFConfig.ApiAddress, cfconfig.ClientCredentials(config.CFConfig.ClientID, config.CFConfig.ClientSecret))
@@ -111,7 +123,10 @@ func main() {
 	serviceBroker := broker.New(
 		config.S3Config,
 		s3bucket,
+		replicaBucket,
 		user,
+		role,
+		key,
 		client,
 		logger,
 		tagManager,
@@ -125,6 +140,10 @@ func main() {
 	brokerAPI := brokerapi.New(serviceBroker, logger, credentials)
 	http.Handle("/", brokerAPI)
 
+	if config.AdminUsername != "" {
+		http.Handle("/admin/", broker.NewAdminHandler(serviceBroker, config.AdminUsername, config.AdminPassword))
+	}
+
 	fmt.Println("S3 Service Broker started on port " + port + "...")
 	http.ListenAndServe(":"+port, nil)
 }